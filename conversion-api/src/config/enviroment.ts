@@ -11,3 +11,6 @@ export const PG_PORT = Number(process.env.PG_PORT) || 5432;
 export const PG_USER = process.env.PG_USER || 'postgres';
 export const PG_PASSWORD = process.env.PG_PASSWORD || 'postgres123';
 export const PG_DATABASE = process.env.PG_DATABASE || 'converter';
+export const ENCRYPTION_KEY = process.env.ENCRYPTION_KEY || '';
+export const ENCRYPTION_KEY_ID = process.env.ENCRYPTION_KEY_ID || 'primary';
+export const ENCRYPTION_PREVIOUS_KEYS = process.env.ENCRYPTION_PREVIOUS_KEYS || '';
@@ -1,21 +1,33 @@
 import { mkdirSync } from 'fs';
 import multer from 'multer';
-import { uploadDir } from './enviroment';
-import { v4 as uuid } from 'uuid';
+import {
+  ENCRYPTION_KEY,
+  ENCRYPTION_KEY_ID,
+  ENCRYPTION_PREVIOUS_KEYS,
+  uploadDir,
+} from './enviroment';
 import path from 'path';
+import { loadKeyring } from '../utils/encryption';
+import { EncryptedDiskStorage, storedName } from '../utils/uploadStorage';
 
 const uploadDirectory = path.resolve(process.cwd(), uploadDir);
 
 mkdirSync(uploadDirectory, { recursive: true });
 
+const keyring = loadKeyring(
+  ENCRYPTION_KEY,
+  ENCRYPTION_KEY_ID,
+  ENCRYPTION_PREVIOUS_KEYS,
+);
+
+// With an encryption key uploads are stored encrypted, as the workers store
+// their outputs.
 export const upload = multer({
-  storage: multer.diskStorage({
-    destination: (_, __, cb) => cb(null, uploadDir),
-    filename: (_, file, cb) => {
-      const id = uuid();
-      const ext = file.originalname.split('.').pop();
-      cb(null, `${id}.${ext}`);
-    },
-  }),
+  storage: keyring
+    ? new EncryptedDiskStorage(uploadDir, keyring, ENCRYPTION_KEY_ID)
+    : multer.diskStorage({
+        destination: (_, __, cb) => cb(null, uploadDir),
+        filename: (_, file, cb) => cb(null, storedName(file.originalname)),
+      }),
   limits: { fileSize: 2 * 1024 * 1024 * 1024 },
 });
@@ -11,14 +11,39 @@ export class ConvertController {
   async handle(req: Request, res: Response) {
     try {
       const file = req.file;
-      const { format } = req.body;
+      const { format, options } = req.body;
 
       if (!file)
         return res
           .status(BAD_REQUEST_CODE)
           .json({ error: ERRORS.FILE_REQUIRED });
 
-      const task = await this.convertService.process({ file, format });
+      const task = await this.convertService.process({
+        file,
+        format,
+        options,
+      });
+
+      res.status(CREATED_CODE).json(task);
+    } catch (error) {
+      if (error instanceof HttpError) {
+        return res.status(error.statusCode).json({ error: error.message });
+      }
+      res.status(500).json({ error: ERRORS.INTERNAL_SERVER });
+    }
+  }
+
+  async handleCompose(req: Request, res: Response) {
+    try {
+      const files = (req.files ?? []) as Express.Multer.File[];
+      const { format, options, durations } = req.body;
+
+      const task = await this.convertService.compose({
+        files,
+        format,
+        options,
+        durations,
+      });
 
       res.status(CREATED_CODE).json(task);
     } catch (error) {
@@ -27,7 +27,21 @@ export class FileController {
     try {
       const { id } = req.params;
       const file = await this.fileService.download(id);
-      res.download(file);
+      if ('path' in file) {
+        return res.download(file.path);
+      }
+
+      res.attachment(file.fileName);
+      file.content.on('error', (error) => {
+        logger.error(ERRORS.DOWNLOAD_FILE, error);
+        if (res.headersSent) {
+          return res.destroy(error);
+        }
+        res
+          .status(INTERNAL_SERVER_ERROR_CODE)
+          .json({ error: ERRORS.DOWNLOAD_FILE });
+      });
+      file.content.pipe(res);
     } catch (error) {
       if (error instanceof HttpError) {
         return res.status(error.statusCode).json({ error: error.message });
@@ -21,6 +21,17 @@ interface PaginationResult {
   };
 }
 
+// ConversionInput is an input of a multi-input task, in the form
+// create_conversion_task_with_outbox expects in p_inputs.
+export interface ConversionInput {
+  path: string;
+  original_name: string;
+  mimetype: string;
+  file_size: number;
+  duration?: number;
+  encryption_key_id?: string;
+}
+
 export class TaskRepository {
   async createConversion(conversionData: {
     inputPath: string;
@@ -30,10 +41,12 @@ export class TaskRepository {
     originalName: string;
     storedName: string;
     status: string;
+    options?: Record<string, unknown>;
+    inputs?: ConversionInput[];
   }) {
     try {
       const result = await pool.query(
-        `SELECT create_conversion_task_with_outbox($1, $2, $3, $4, $5, $6, $7)`,
+        `SELECT create_conversion_task_with_outbox($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
         [
           conversionData.originalName,
           conversionData.storedName,
@@ -42,6 +55,8 @@ export class TaskRepository {
           conversionData.format,
           conversionData.fileSize,
           null,
+          JSON.stringify(conversionData.options ?? {}),
+          conversionData.inputs ? JSON.stringify(conversionData.inputs) : null,
         ],
       );
 
@@ -1,6 +1,7 @@
 import { Request, Response, Router } from 'express';
 import { ConvertController } from '../controller/ConvertController';
 import { upload } from '../config/multer';
+import { MAX_COMPOSE_FILES } from '../utils/constants';
 
 const router = Router();
 const convertController = new ConvertController();
@@ -8,4 +9,12 @@ const convertController = new ConvertController();
 router.post('/', upload.single('file'), (req: Request, res: Response) => {
   convertController.handle(req, res);
 });
+
+router.post(
+  '/compose',
+  upload.array('files', MAX_COMPOSE_FILES),
+  (req: Request, res: Response) => {
+    convertController.handleCompose(req, res);
+  },
+);
 export default router;
@@ -1,12 +1,22 @@
 import { HttpError } from '../../errors/HttpError';
-import { TaskRepository } from '../../repositories/TaskRepository';
+import {
+  ConversionInput,
+  TaskRepository,
+} from '../../repositories/TaskRepository';
 import {
   BAD_REQUEST_CODE,
   ALLOWED_FORMATS_MAP,
+  COMPOSE_ALLOWED_FORMATS,
+  COMPOSE_MODES,
+  COMPOSE_UNSUPPORTED_OPTIONS,
   STATUS_PENDING,
   ERRORS,
+  SUBTITLE_ALLOWED_FORMATS,
+  SUBTITLE_MIMETYPES,
 } from '../../utils/constants';
+import { parseOptions } from '../../utils/options';
 import { MediaType } from '../../utils/types';
+import { UploadedFile } from '../../utils/uploadStorage';
 
 export class ConversionService {
   constructor(private taskRepository = new TaskRepository()) {
@@ -16,13 +26,17 @@ export class ConversionService {
   async process({
     file,
     format,
+    options,
   }: {
-    file: Express.Multer.File;
+    file: UploadedFile;
     format: string;
+    options?: unknown;
   }) {
     const mediaType = file.mimetype.split('/')[0] as MediaType;
 
-    const allowedFormats = ALLOWED_FORMATS_MAP[mediaType];
+    const allowedFormats = SUBTITLE_MIMETYPES.includes(file.mimetype)
+      ? SUBTITLE_ALLOWED_FORMATS
+      : ALLOWED_FORMATS_MAP[mediaType];
 
     if (!allowedFormats) {
       throw new HttpError(
@@ -47,6 +61,8 @@ export class ConversionService {
       );
     }
 
+    const conversionOptions = withStoredInput(parseOptions(options), file);
+
     const conversionData = {
       inputPath: file.path,
       mimetype: file.mimetype,
@@ -55,6 +71,7 @@ export class ConversionService {
       originalName: file.originalname,
       storedName: file.filename,
       status: STATUS_PENDING,
+      options: conversionOptions,
     };
 
     const task = await this.taskRepository.createConversion(conversionData);
@@ -65,4 +82,150 @@ export class ConversionService {
 
     return task;
   }
+
+  // compose creates a task combining several uploads, in order, as set by
+  // the compose option. The first file is the task's main input.
+  async compose({
+    files,
+    format,
+    options,
+    durations,
+  }: {
+    files: UploadedFile[];
+    format: string;
+    options?: unknown;
+    durations?: unknown;
+  }) {
+    if (files.length < 2) {
+      throw new HttpError(ERRORS.COMPOSE_FILES_REQUIRED, BAD_REQUEST_CODE);
+    }
+
+    for (const file of files) {
+      const mediaType = file.mimetype.split('/')[0] as MediaType;
+      if (!ALLOWED_FORMATS_MAP[mediaType]) {
+        throw new HttpError(
+          ERRORS.UNSUPPORTED_MEDIA_FORMAT,
+          BAD_REQUEST_CODE,
+        );
+      }
+    }
+
+    if (!COMPOSE_ALLOWED_FORMATS.includes(format)) {
+      throw new HttpError(
+        ERRORS.UNSUPPORTED_TARGET_FORMAT,
+        BAD_REQUEST_CODE,
+      );
+    }
+
+    const conversionOptions = withStoredInput(
+      parseOptions(options, { allowCompose: true }),
+      files[0],
+    );
+    const compose = conversionOptions.compose as { mode?: unknown } | undefined;
+    if (!compose || !COMPOSE_MODES.includes(compose.mode as string)) {
+      throw new HttpError(
+        `${ERRORS.INVALID_OPTIONS}: compose.mode must be one of ${COMPOSE_MODES.join(', ')}`,
+        BAD_REQUEST_CODE,
+      );
+    }
+    const unsupported = COMPOSE_UNSUPPORTED_OPTIONS.filter(
+      (name) => name in conversionOptions,
+    );
+    if (unsupported.length > 0) {
+      throw new HttpError(
+        `${ERRORS.INVALID_OPTIONS}: ${compose.mode} does not support ${unsupported.join(', ')}`,
+        BAD_REQUEST_CODE,
+      );
+    }
+
+    const inputDurations = parseDurations(durations, files.length);
+    const inputs: ConversionInput[] = files.map((file, index) => ({
+      path: file.path,
+      original_name: file.originalname,
+      mimetype: file.mimetype,
+      file_size: file.size,
+      duration: inputDurations[index] ?? undefined,
+      encryption_key_id: file.encryptionKeyId,
+    }));
+
+    const [main] = files;
+    const task = await this.taskRepository.createConversion({
+      inputPath: main.path,
+      mimetype: main.mimetype,
+      format,
+      fileSize: main.size,
+      originalName: main.originalname,
+      storedName: main.filename,
+      status: STATUS_PENDING,
+      options: conversionOptions,
+      inputs,
+    });
+
+    if (!task) {
+      throw new HttpError(ERRORS.CREATE_CONVERSION, BAD_REQUEST_CODE);
+    }
+
+    return task;
+  }
+}
+
+// withStoredInput adds to options how the main input is stored. An
+// encrypted upload names its key, so the worker refuses it in plaintext, and
+// its input_checksum is checked here, against the uploaded content, then
+// replaced by the checksum of the stored object, which the worker verifies.
+function withStoredInput(
+  options: Record<string, unknown>,
+  file: UploadedFile,
+): Record<string, unknown> {
+  if (!file.encryptionKeyId) {
+    return options;
+  }
+
+  const stored = { ...options, input_encryption_key_id: file.encryptionKeyId };
+  const checksum = options.input_checksum as string | undefined;
+  if (checksum !== undefined) {
+    if (normalizeChecksum(checksum) !== normalizeChecksum(file.checksum!)) {
+      throw new HttpError(ERRORS.INPUT_CHECKSUM_MISMATCH, BAD_REQUEST_CODE);
+    }
+    stored.input_checksum = file.storedChecksum;
+  }
+  return stored;
+}
+
+// normalizeChecksum reads checksums as the worker does: "sha256:<hex>" or a
+// bare SHA-256 hex digest, in either case.
+function normalizeChecksum(checksum: string): string {
+  const value = checksum.trim().toLowerCase();
+  return value.includes(':') ? value : `sha256:${value}`;
+}
+
+// parseDurations reads the optional per-file durations of a compose request,
+// a JSON array with a number of seconds or null for each file.
+function parseDurations(raw: unknown, count: number): (number | null)[] {
+  if (raw === undefined || raw === null || raw === '') {
+    return [];
+  }
+
+  let durations: unknown = raw;
+  if (typeof raw === 'string') {
+    try {
+      durations = JSON.parse(raw);
+    } catch {
+      durations = undefined;
+    }
+  }
+
+  const valid =
+    Array.isArray(durations) &&
+    durations.length === count &&
+    durations.every(
+      (duration) =>
+        duration === null ||
+        (typeof duration === 'number' && Number.isFinite(duration) && duration > 0),
+    );
+  if (!valid) {
+    throw new HttpError(ERRORS.INVALID_DURATIONS, BAD_REQUEST_CODE);
+  }
+
+  return durations as (number | null)[];
 }
@@ -1,16 +1,47 @@
 import { TaskRepository } from '../../repositories/TaskRepository';
-import { existsSync } from 'fs';
+import { createReadStream, existsSync } from 'fs';
+import path from 'path';
+import { pipeline, Readable } from 'stream';
+import { fileURLToPath } from 'url';
 import { HttpError } from '../../errors/HttpError';
-import { BAD_REQUEST_CODE, ERRORS, NOT_FOUND_CODE } from '../../utils/constants';
+import {
+  BAD_REQUEST_CODE,
+  ERRORS,
+  INTERNAL_SERVER_ERROR_CODE,
+  NOT_FOUND_CODE,
+} from '../../utils/constants';
+import {
+  ENCRYPTION_KEY,
+  ENCRYPTION_KEY_ID,
+  ENCRYPTION_PREVIOUS_KEYS,
+} from '../../config/enviroment';
+import {
+  createDecryptStream,
+  Keyring,
+  loadKeyring,
+} from '../../utils/encryption';
+
+// DownloadFile is either a local file served as it is or, for encrypted
+// outputs, the decrypted content.
+export type DownloadFile =
+  | { fileName: string; path: string }
+  | { fileName: string; content: Readable };
 
 export class FileService {
-  constructor(private taskRepository = new TaskRepository()) {
+  constructor(
+    private taskRepository = new TaskRepository(),
+    private keyring: Keyring | null = loadKeyring(
+      ENCRYPTION_KEY,
+      ENCRYPTION_KEY_ID,
+      ENCRYPTION_PREVIOUS_KEYS,
+    ),
+  ) {
     this.taskRepository = taskRepository;
   }
 
   async getStatus(id: string): Promise<{
     fileName: string | undefined;
-    status: 'pending' | 'processing' | 'done' | 'failed';
+    status: 'pending' | 'processing' | 'done' | 'failed' | 'expired';
   }> {
     const task = await this.taskRepository.getTaskById(id);
     if (!task) {
@@ -19,7 +50,7 @@ export class FileService {
     return { fileName: task.stored_name, status: task.status };
   }
 
-  async download(id: string): Promise<string> {
+  async download(id: string): Promise<DownloadFile> {
     const file = await this.taskRepository.getTaskById(id);
     if (!file) {
       throw new HttpError(ERRORS.FILE_NOT_FOUND, NOT_FOUND_CODE);
@@ -28,11 +59,60 @@ export class FileService {
       throw new HttpError(ERRORS.FILE_NOT_COMPLETED, BAD_REQUEST_CODE);
     }
 
-    if (!file.output_path || !existsSync(file.output_path)) {
-      throw new HttpError(ERRORS.OUTPUT_FILE_NOT_FOUND, BAD_REQUEST_CODE);
+    // Workers record outputs as storage URIs. Local files are served from
+    // disk; outputs in buckets only when encrypted, through the presigned URL
+    // of their ciphertext.
+    const outputPath = file.output_path?.startsWith('file://')
+      ? fileURLToPath(file.output_path)
+      : file.output_path;
+    // Outputs shared through the conversion cache are stored under their
+    // cache key; the task's own file name is in its metadata.
+    const recordedName: string | undefined = file.metadata?.filename;
+    const encrypted = Boolean(file.metadata?.encryption_key_id);
+    const encryptedUrl: string | undefined =
+      file.metadata?.encrypted_download_url;
+
+    if (outputPath && existsSync(outputPath)) {
+      const fileName = recordedName ?? path.basename(outputPath);
+      if (!encrypted) {
+        return { fileName, path: outputPath };
+      }
+      this.requireKeyring();
+      return { fileName, content: this.decrypt(createReadStream(outputPath)) };
     }
 
-    return file.output_path;
+    if (encrypted && encryptedUrl) {
+      this.requireKeyring();
+      const response = await fetch(encryptedUrl);
+      if (!response.ok || !response.body) {
+        throw new HttpError(ERRORS.OUTPUT_FILE_NOT_FOUND, BAD_REQUEST_CODE);
+      }
+      return {
+        fileName:
+          recordedName ??
+          path.posix.basename(new URL(file.output_path).pathname),
+        content: this.decrypt(Readable.fromWeb(response.body as any)),
+      };
+    }
+
+    throw new HttpError(ERRORS.OUTPUT_FILE_NOT_FOUND, BAD_REQUEST_CODE);
+  }
+
+  private requireKeyring(): Keyring {
+    if (!this.keyring) {
+      throw new HttpError(
+        ERRORS.ENCRYPTION_NOT_CONFIGURED,
+        INTERNAL_SERVER_ERROR_CODE,
+      );
+    }
+    return this.keyring;
+  }
+
+  // decrypt returns the plaintext of an encrypted object. Read errors of the
+  // source come out of the returned stream too.
+  private decrypt(source: Readable): Readable {
+    const decrypt = createDecryptStream(this.requireKeyring());
+    return pipeline(source, decrypt, () => {});
   }
 
   async getFiles(params: {
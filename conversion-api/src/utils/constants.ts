@@ -4,6 +4,7 @@ export const STATUS_PENDING = 'pending';
 export const STATUS_PROCESSING = 'processing';
 export const STATUS_DONE = 'done';
 export const STATUS_FAILED = 'failed';
+export const STATUS_EXPIRED = 'expired';
 
 export const OK_CODE = 200;
 export const CREATED_CODE = 201;
@@ -20,10 +21,32 @@ export const VIDEO_ALLOWED_FORMATS = [
   'mov',
   'wmv',
   'flv',
+  'webm',
+  'ogv',
+  'mp4-hevc',
+  'mp4-av1',
+  'm4a',
+  'opus',
   'gif',
+  'webp',
   'images',
+  'subtitles',
+  'sprites',
+  'contactsheet',
+  'waveform',
+  'spectrogram',
+];
+export const IMAGE_ALLOWED_FORMATS = [
+  'jpg',
+  'jpeg',
+  'png',
+  'webp',
+  'gif',
+  'bmp',
+  'avif',
+  'tiff',
+  'ico',
 ];
-export const IMAGE_ALLOWED_FORMATS = ['jpg', 'jpeg', 'png', 'webp'];
 
 export const AUDIO_ALLOWED_FORMATS = [
   'mp3',
@@ -32,11 +55,27 @@ export const AUDIO_ALLOWED_FORMATS = [
   'ogg',
   'wma',
   'aac',
+  'm4a',
+  'opus',
+  'waveform',
+  'spectrogram',
+  'chunks',
 ];
 
+// Subtitle files are matched by their full mimetype, since "text" and
+// "application" cover much more than subtitles.
+export const SUBTITLE_MIMETYPES = [
+  'application/x-subrip',
+  'text/srt',
+  'text/vtt',
+  'application/x-ass',
+  'text/x-ssa',
+];
+export const SUBTITLE_ALLOWED_FORMATS = ['subtitles'];
+
 export const ALLOWED_FORMATS_MAP: Record<MediaType, string[]> = {
-  audio: ['mp3', 'wav', 'flac', 'ogg', 'wma', 'aac'],
-  image: ['jpg', 'jpeg', 'png', 'webp'],
+  audio: AUDIO_ALLOWED_FORMATS,
+  image: IMAGE_ALLOWED_FORMATS,
   video: [
     'mp3',
     'wav',
@@ -46,11 +85,55 @@ export const ALLOWED_FORMATS_MAP: Record<MediaType, string[]> = {
     'mov',
     'wmv',
     'flv',
+    'webm',
+    'ogv',
+    'mp4-hevc',
+    'mp4-av1',
+    'm4a',
+    'opus',
     'gif',
+    'webp',
     'images',
+    'subtitles',
+    'sprites',
+    'contactsheet',
+    'waveform',
+    'spectrogram',
   ],
 };
 
+// Multi-input uploads are combined by the worker's composer, which writes
+// these formats. Compose jobs only apply metadata to their output.
+export const COMPOSE_MODES = ['concat', 'merge_audio', 'replace_audio', 'slideshow'];
+export const COMPOSE_ALLOWED_FORMATS = [
+  'mp4',
+  'avi',
+  'mkv',
+  'mov',
+  'flv',
+  'wmv',
+  'webm',
+  'ogv',
+  'mp4-hevc',
+  'mp4-av1',
+  'mp3',
+  'wav',
+  'flac',
+  'ogg',
+  'wma',
+  'aac',
+  'm4a',
+  'opus',
+];
+export const COMPOSE_UNSUPPORTED_OPTIONS = [
+  'transform',
+  'watermark',
+  'text_overlays',
+  'burn_subtitles',
+  'max_size',
+];
+export const MAX_COMPOSE_FILES = 20;
+
 export const ERRORS = {
   CREATE_CONVERSION: 'Conversion creation failed',
   GET_TASK_BY_ID: 'Task retrieval failed',
@@ -60,13 +143,18 @@ export const ERRORS = {
   DOWNLOAD_FILE: 'File download failed',
 
   FILE_REQUIRED: 'File is required',
+  COMPOSE_FILES_REQUIRED: 'At least two files are required',
   FILE_NOT_FOUND: 'File not found',
   FILE_NOT_COMPLETED: 'File conversion not completed',
   OUTPUT_FILE_NOT_FOUND: 'Output file not found',
+  ENCRYPTION_NOT_CONFIGURED: 'Output is encrypted but no encryption key is configured',
 
   UNSUPPORTED_MEDIA_FORMAT: 'Unsupported source format',
   UNSUPPORTED_TARGET_FORMAT: 'Unsupported target format',
   SAME_FORMAT: 'Source and target formats cannot match',
+  INVALID_OPTIONS: 'Invalid conversion options',
+  INPUT_CHECKSUM_MISMATCH: 'Upload does not match input_checksum',
+  INVALID_DURATIONS: 'Durations must be a JSON array with a positive number or null per file',
 
   INTERNAL_SERVER: 'Internal server error'
 };
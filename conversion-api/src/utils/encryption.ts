@@ -0,0 +1,286 @@
+import { createCipheriv, createDecipheriv, randomBytes } from 'crypto';
+import { Transform, TransformCallback } from 'stream';
+
+// Workers encrypt outputs, and the API uploads, at rest when ENCRYPTION_KEY
+// is set. An encrypted
+// object starts with a header naming the master key and holding the
+// object's data key, wrapped by that master key:
+//
+//   magic "CVENC1\0\0" | chunk size (uint32) | key ID length (uint8) |
+//   key ID | wrapped key length (uint16) | nonce + sealed data key
+//
+// The content follows in AES-256-GCM sealed chunks. A chunk's nonce is its
+// index and its additional data says whether it is the last one.
+const MAGIC = Buffer.from('CVENC1\0\0', 'latin1');
+const KEY_SIZE = 32;
+const NONCE_SIZE = 12;
+const TAG_SIZE = 16;
+const MAX_CHUNK_SIZE = 16 << 20;
+const CHUNK_SIZE = 64 << 10;
+
+export type Keyring = Map<string, Buffer>;
+
+interface Envelope {
+  keyId: string;
+  wrappedKey: Buffer;
+  chunkSize: number;
+  size: number;
+}
+
+export function parseMasterKey(encoded: string): Buffer {
+  const base64 = Buffer.from(encoded, 'base64');
+  if (base64.length === KEY_SIZE && base64.toString('base64') === encoded) {
+    return base64;
+  }
+  const hex = Buffer.from(encoded, 'hex');
+  if (hex.length === KEY_SIZE && hex.toString('hex') === encoded.toLowerCase()) {
+    return hex;
+  }
+  throw new Error(`Encryption key must be ${KEY_SIZE} bytes, in base64 or hex`);
+}
+
+// loadKeyring builds the keyring from the same variables the workers read:
+// the current key and previous keys given as comma-separated "id:key" pairs.
+// It returns null when no key is configured.
+export function loadKeyring(
+  current: string,
+  currentId: string,
+  previous: string,
+): Keyring | null {
+  if (!current) {
+    return null;
+  }
+
+  const keyring: Keyring = new Map();
+  for (const entry of previous.split(',').filter((e) => e.trim() !== '')) {
+    const separator = entry.indexOf(':');
+    if (separator < 0) {
+      throw new Error('Previous encryption keys must be given as id:key');
+    }
+    keyring.set(
+      entry.slice(0, separator).trim(),
+      parseMasterKey(entry.slice(separator + 1).trim()),
+    );
+  }
+  keyring.set(currentId, parseMasterKey(current));
+  return keyring;
+}
+
+// parseEnvelope reads the header at the start of data. It returns null when
+// data is too short to hold the whole header.
+function parseEnvelope(data: Buffer): Envelope | null {
+  if (data.length < MAGIC.length + 5) {
+    return null;
+  }
+  if (!data.subarray(0, MAGIC.length).equals(MAGIC)) {
+    throw new Error('Object is not encrypted');
+  }
+
+  const chunkSize = data.readUInt32BE(MAGIC.length);
+  if (chunkSize === 0 || chunkSize > MAX_CHUNK_SIZE) {
+    throw new Error(`Invalid encryption header: chunk size ${chunkSize}`);
+  }
+  const idLength = data.readUInt8(MAGIC.length + 4);
+  const keyLengthAt = MAGIC.length + 5 + idLength;
+  if (data.length < keyLengthAt + 2) {
+    return null;
+  }
+  const keyLength = data.readUInt16BE(keyLengthAt);
+  const size = keyLengthAt + 2 + keyLength;
+  if (data.length < size) {
+    return null;
+  }
+
+  return {
+    keyId: data.subarray(MAGIC.length + 5, keyLengthAt).toString(),
+    wrappedKey: data.subarray(keyLengthAt + 2, size),
+    chunkSize,
+    size,
+  };
+}
+
+function open(key: Buffer, nonce: Buffer, sealed: Buffer, aad: Buffer): Buffer {
+  if (sealed.length < TAG_SIZE) {
+    throw new Error('Encrypted object is truncated');
+  }
+  const decipher = createDecipheriv('aes-256-gcm', key, nonce);
+  decipher.setAAD(aad);
+  decipher.setAuthTag(sealed.subarray(sealed.length - TAG_SIZE));
+  return Buffer.concat([
+    decipher.update(sealed.subarray(0, sealed.length - TAG_SIZE)),
+    decipher.final(),
+  ]);
+}
+
+function seal(key: Buffer, nonce: Buffer, plain: Buffer, aad: Buffer): Buffer {
+  const cipher = createCipheriv('aes-256-gcm', key, nonce);
+  cipher.setAAD(aad);
+  return Buffer.concat([cipher.update(plain), cipher.final(), cipher.getAuthTag()]);
+}
+
+function unwrapDataKey(keyring: Keyring, envelope: Envelope): Buffer {
+  const master = keyring.get(envelope.keyId);
+  if (!master) {
+    throw new Error(`Unknown encryption key "${envelope.keyId}"`);
+  }
+  const { wrappedKey } = envelope;
+  try {
+    return open(
+      master,
+      wrappedKey.subarray(0, NONCE_SIZE),
+      wrappedKey.subarray(NONCE_SIZE),
+      Buffer.from(envelope.keyId),
+    );
+  } catch {
+    throw new Error(`Failed to unwrap data key with key "${envelope.keyId}"`);
+  }
+}
+
+function chunkNonce(index: number): Buffer {
+  const nonce = Buffer.alloc(NONCE_SIZE);
+  nonce.writeBigUInt64BE(BigInt(index), NONCE_SIZE - 8);
+  return nonce;
+}
+
+// DecryptStream turns an encrypted object into its plaintext as it is read.
+// A full chunk is held back until more data arrives, because only the end
+// of the stream says which chunk is the last.
+class DecryptStream extends Transform {
+  private pending = Buffer.alloc(0);
+  private dataKey: Buffer | null = null;
+  private sealedChunkSize = 0;
+  private index = 0;
+
+  constructor(private keyring: Keyring) {
+    super();
+  }
+
+  _transform(data: Buffer, _encoding: BufferEncoding, callback: TransformCallback) {
+    try {
+      this.pending = Buffer.concat([this.pending, data]);
+      if (!this.dataKey) {
+        const envelope = parseEnvelope(this.pending);
+        if (!envelope) {
+          return callback();
+        }
+        this.dataKey = unwrapDataKey(this.keyring, envelope);
+        this.sealedChunkSize = envelope.chunkSize + TAG_SIZE;
+        this.pending = this.pending.subarray(envelope.size);
+      }
+
+      while (this.pending.length > this.sealedChunkSize) {
+        this.push(this.openChunk(this.pending.subarray(0, this.sealedChunkSize), false));
+        this.pending = this.pending.subarray(this.sealedChunkSize);
+      }
+      callback();
+    } catch (error) {
+      callback(error as Error);
+    }
+  }
+
+  _flush(callback: TransformCallback) {
+    try {
+      if (!this.dataKey) {
+        throw new Error('Encrypted object is truncated');
+      }
+      this.push(this.openChunk(this.pending, true));
+      callback();
+    } catch (error) {
+      callback(error as Error);
+    }
+  }
+
+  private openChunk(sealed: Buffer, final: boolean): Buffer {
+    try {
+      return open(
+        this.dataKey!,
+        chunkNonce(this.index++),
+        sealed,
+        Buffer.from([final ? 1 : 0]),
+      );
+    } catch {
+      throw new Error(`Failed to decrypt chunk ${this.index - 1}`);
+    }
+  }
+}
+
+// EncryptStream writes its input as an encrypted object under a new data
+// key, wrapped by the master key keyId names. Like DecryptStream it holds a
+// full chunk back, so the last chunk is only sealed once the input ends.
+class EncryptStream extends Transform {
+  private pending = Buffer.alloc(0);
+  private dataKey = randomBytes(KEY_SIZE);
+  private index = 0;
+
+  constructor(
+    private master: Buffer,
+    private keyId: string,
+    private chunkSize = CHUNK_SIZE,
+  ) {
+    super();
+  }
+
+  _construct(callback: (error?: Error | null) => void) {
+    const id = Buffer.from(this.keyId);
+    const keyNonce = randomBytes(NONCE_SIZE);
+    const wrappedKey = Buffer.concat([
+      keyNonce,
+      seal(this.master, keyNonce, this.dataKey, id),
+    ]);
+
+    const header = Buffer.alloc(MAGIC.length + 5 + id.length + 2);
+    MAGIC.copy(header);
+    header.writeUInt32BE(this.chunkSize, MAGIC.length);
+    header.writeUInt8(id.length, MAGIC.length + 4);
+    id.copy(header, MAGIC.length + 5);
+    header.writeUInt16BE(wrappedKey.length, MAGIC.length + 5 + id.length);
+    this.push(Buffer.concat([header, wrappedKey]));
+    callback();
+  }
+
+  _transform(data: Buffer, _encoding: BufferEncoding, callback: TransformCallback) {
+    this.pending = Buffer.concat([this.pending, data]);
+    while (this.pending.length > this.chunkSize) {
+      this.push(this.sealChunk(this.pending.subarray(0, this.chunkSize), false));
+      this.pending = this.pending.subarray(this.chunkSize);
+    }
+    callback();
+  }
+
+  _flush(callback: TransformCallback) {
+    // An empty input is still one, empty, final chunk.
+    this.push(this.sealChunk(this.pending, true));
+    callback();
+  }
+
+  private sealChunk(plain: Buffer, final: boolean): Buffer {
+    return seal(
+      this.dataKey,
+      chunkNonce(this.index++),
+      plain,
+      Buffer.from([final ? 1 : 0]),
+    );
+  }
+}
+
+// createEncryptStream encrypts with the master key keyId names in keyring.
+// chunkSize is only meant for tests; objects use 64 KiB chunks, as the
+// workers write them.
+export function createEncryptStream(
+  keyring: Keyring,
+  keyId: string,
+  chunkSize = CHUNK_SIZE,
+): Transform {
+  const master = keyring.get(keyId);
+  if (!master) {
+    throw new Error(`Unknown encryption key "${keyId}"`);
+  }
+  if (Buffer.byteLength(keyId) > 255) {
+    throw new Error('Encryption key ID must be at most 255 bytes');
+  }
+  return new EncryptStream(master, keyId, chunkSize);
+}
+
+export function createDecryptStream(keyring: Keyring): Transform {
+  return new DecryptStream(keyring);
+}
@@ -0,0 +1,90 @@
+import { HttpError } from '../errors/HttpError';
+import { BAD_REQUEST_CODE, ERRORS } from './constants';
+
+type OptionKind = 'object' | 'array' | 'string' | 'size';
+
+// OPTION_KINDS lists the options the worker reads, with the JSON type of
+// each. The worker validates their contents; the API only makes sure the job
+// can be decoded, since a malformed option would fail it on every worker.
+const OPTION_KINDS: Record<string, OptionKind> = {
+  metadata: 'object',
+  watermark: 'object',
+  text_overlays: 'array',
+  subtitles: 'object',
+  burn_subtitles: 'object',
+  frames: 'object',
+  gif: 'object',
+  animated_webp: 'object',
+  max_size: 'size',
+  sprites: 'object',
+  contact_sheet: 'object',
+  waveform: 'object',
+  spectrogram: 'object',
+  compose: 'object',
+  transform: 'object',
+  chunks: 'object',
+  input_checksum: 'string',
+  input_encryption_key_id: 'string',
+};
+
+// Sizes are a number of bytes or a string with a unit, as the worker parses
+// them.
+const SIZE_PATTERN = /^\s*\d+(\.\d+)?\s*(B|KB|MB|GB|KIB|MIB|GIB)?\s*$/i;
+
+const isObject = (value: unknown): value is Record<string, unknown> =>
+  typeof value === 'object' && value !== null && !Array.isArray(value);
+
+const matchesKind = (value: unknown, kind: OptionKind): boolean => {
+  switch (kind) {
+    case 'object':
+      return isObject(value);
+    case 'array':
+      return Array.isArray(value) && value.every(isObject);
+    case 'string':
+      return typeof value === 'string';
+    case 'size':
+      return typeof value === 'number'
+        ? Number.isInteger(value) && value >= 0
+        : typeof value === 'string' && SIZE_PATTERN.test(value);
+  }
+};
+
+const invalid = (reason: string) =>
+  new HttpError(`${ERRORS.INVALID_OPTIONS}: ${reason}`, BAD_REQUEST_CODE);
+
+// parseOptions reads the options of a conversion request, sent as a JSON
+// object or, in multipart forms, as a JSON string. Missing options are an
+// empty object. compose is only accepted when allowCompose is set, for
+// multi-input requests.
+export function parseOptions(
+  raw: unknown,
+  { allowCompose = false } = {},
+): Record<string, unknown> {
+  if (raw === undefined || raw === null || raw === '') {
+    return {};
+  }
+
+  let options: unknown = raw;
+  if (typeof raw === 'string') {
+    try {
+      options = JSON.parse(raw);
+    } catch {
+      throw invalid('not valid JSON');
+    }
+  }
+  if (!isObject(options)) {
+    throw invalid('must be a JSON object');
+  }
+
+  for (const [name, value] of Object.entries(options)) {
+    const kind = OPTION_KINDS[name];
+    if (!kind || (name === 'compose' && !allowCompose)) {
+      throw invalid(`unknown option ${name}`);
+    }
+    if (!matchesKind(value, kind)) {
+      throw invalid(`${name} must be ${kind === 'size' ? 'a size' : `an ${kind}`}`);
+    }
+  }
+
+  return options;
+}
@@ -0,0 +1,75 @@
+import { createHash } from 'crypto';
+import { createWriteStream, unlink } from 'fs';
+import { Request } from 'express';
+import { StorageEngine } from 'multer';
+import path from 'path';
+import { pipeline } from 'stream';
+import { v4 as uuid } from 'uuid';
+import { createEncryptStream, Keyring } from './encryption';
+
+// UploadedFile is an upload as multer hands it over. Uploads stored
+// encrypted also carry the key they were encrypted with and the SHA-256 of
+// their content before and after encryption.
+export type UploadedFile = Express.Multer.File & {
+  encryptionKeyId?: string;
+  checksum?: string;
+  storedChecksum?: string;
+};
+
+// storedName names an upload after a new UUID, keeping its extension.
+export function storedName(originalName: string): string {
+  return `${uuid()}.${originalName.split('.').pop()}`;
+}
+
+// EncryptedDiskStorage writes uploads into directory encrypted in the
+// workers' format, under the master key keyId names, so their plaintext never
+// reaches the disk.
+export class EncryptedDiskStorage implements StorageEngine {
+  constructor(
+    private directory: string,
+    private keyring: Keyring,
+    private keyId: string,
+  ) {}
+
+  _handleFile(
+    _req: Request,
+    file: Express.Multer.File,
+    callback: (error?: any, info?: Partial<UploadedFile>) => void,
+  ) {
+    const filename = storedName(file.originalname);
+    const destination = path.join(this.directory, filename);
+    const plain = createHash('sha256');
+    const stored = createHash('sha256');
+    let size = 0;
+
+    const encrypt = createEncryptStream(this.keyring, this.keyId);
+    file.stream.on('data', (data: Buffer) => {
+      plain.update(data);
+      size += data.length;
+    });
+    encrypt.on('data', (data: Buffer) => stored.update(data));
+
+    pipeline(file.stream, encrypt, createWriteStream(destination), (error) => {
+      if (error) {
+        return unlink(destination, () => callback(error));
+      }
+      callback(null, {
+        destination: this.directory,
+        filename,
+        path: destination,
+        size,
+        encryptionKeyId: this.keyId,
+        checksum: `sha256:${plain.digest('hex')}`,
+        storedChecksum: `sha256:${stored.digest('hex')}`,
+      });
+    });
+  }
+
+  _removeFile(
+    _req: Request,
+    file: Express.Multer.File,
+    callback: (error: Error | null) => void,
+  ) {
+    unlink(file.path, callback);
+  }
+}
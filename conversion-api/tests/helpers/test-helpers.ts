@@ -19,6 +19,7 @@ export interface MockConversionData {
   originalName: string;
   storedName: string;
   status: string;
+  options: Record<string, unknown>;
 }
 
 export const TestDataFactory = {
@@ -65,6 +66,7 @@ export const TestDataFactory = {
       originalName: 'test-file.jpg',
       storedName: 'stored-test-file.jpg',
       status: 'pending',
+      options: { metadata: { mode: 'strip' } },
       ...overrides,
     };
   },
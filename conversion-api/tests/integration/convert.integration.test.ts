@@ -92,6 +92,49 @@ describe('Convert API - Integração', () => {
       expect(response.body).toEqual(mockTask);
 
       expect(mockPool.query).toHaveBeenCalledTimes(2);
+      expect(mockPool.query.mock.calls[0][1][7]).toBe('{}');
+      expect(mockPool.query.mock.calls[0][1][8]).toBeNull();
+    });
+
+    it('deve repassar as opções de conversão para a tarefa', async () => {
+      const mockTaskId = 'options-task-id';
+      const options = {
+        watermark: { asset_id: 'logo', position: 'bottom-right' },
+        max_size: '2MB',
+      };
+
+      mockPool.query
+        .mockResolvedValueOnce({
+          rows: [{ create_conversion_task_with_outbox: mockTaskId }],
+        })
+        .mockResolvedValueOnce({
+          rows: [{ id: mockTaskId }],
+        });
+
+      await request(app)
+        .post('/api/convert')
+        .attach('file', testFilePath)
+        .field('format', 'png')
+        .field('options', JSON.stringify(options))
+        .expect(201);
+
+      const [sql, params] = mockPool.query.mock.calls[0];
+      expect(sql).toBe(
+        'SELECT create_conversion_task_with_outbox($1, $2, $3, $4, $5, $6, $7, $8, $9)',
+      );
+      expect(JSON.parse(params[7])).toEqual(options);
+    });
+
+    it('deve retornar erro 400 para opções inválidas', async () => {
+      const response = await request(app)
+        .post('/api/convert')
+        .attach('file', testFilePath)
+        .field('format', 'png')
+        .field('options', JSON.stringify({ compose: { mode: 'concat' } }))
+        .expect(400);
+
+      expect(response.body.error).toContain(ERRORS.INVALID_OPTIONS);
+      expect(mockPool.query).not.toHaveBeenCalled();
     });
 
     it('deve retornar erro 400 quando nenhum arquivo é enviado', async () => {
@@ -217,4 +260,71 @@ describe('Convert API - Integração', () => {
       });
     });
   });
+
+  describe('POST /api/convert/compose', () => {
+    it('deve criar uma tarefa com todas as entradas em ordem', async () => {
+      const mockTaskId = 'compose-task-id';
+      const secondFilePath = path.join(__dirname, 'fixtures', 'test-image-2.png');
+      await fs.writeFile(secondFilePath, 'fake-image-content');
+
+      mockPool.query
+        .mockResolvedValueOnce({
+          rows: [{ create_conversion_task_with_outbox: mockTaskId }],
+        })
+        .mockResolvedValueOnce({
+          rows: [{ id: mockTaskId }],
+        });
+
+      try {
+        await request(app)
+          .post('/api/convert/compose')
+          .attach('files', testFilePath)
+          .attach('files', secondFilePath)
+          .field('format', 'mp4')
+          .field('options', JSON.stringify({ compose: { mode: 'slideshow' } }))
+          .field('durations', JSON.stringify([5, null]))
+          .expect(201);
+      } finally {
+        await fs.unlink(secondFilePath);
+      }
+
+      const [, params] = mockPool.query.mock.calls[0];
+      const inputs = JSON.parse(params[8]);
+      expect(params[0]).toBe('test-image.jpg');
+      expect(JSON.parse(params[7])).toEqual({ compose: { mode: 'slideshow' } });
+      expect(inputs).toHaveLength(2);
+      expect(inputs[0]).toMatchObject({
+        path: params[2],
+        original_name: 'test-image.jpg',
+        duration: 5,
+      });
+      expect(inputs[1]).toMatchObject({ original_name: 'test-image-2.png' });
+      expect(inputs[1].duration).toBeUndefined();
+    });
+
+    it('deve retornar erro 400 com menos de dois arquivos', async () => {
+      const response = await request(app)
+        .post('/api/convert/compose')
+        .attach('files', testFilePath)
+        .field('format', 'mp4')
+        .field('options', JSON.stringify({ compose: { mode: 'slideshow' } }))
+        .expect(400);
+
+      expect(response.body).toEqual({ error: ERRORS.COMPOSE_FILES_REQUIRED });
+      expect(mockPool.query).not.toHaveBeenCalled();
+    });
+
+    it('deve retornar erro 400 sem um modo de composição válido', async () => {
+      const response = await request(app)
+        .post('/api/convert/compose')
+        .attach('files', testFilePath)
+        .attach('files', testFilePath)
+        .field('format', 'mp4')
+        .field('options', JSON.stringify({ compose: { mode: 'mosaic' } }))
+        .expect(400);
+
+      expect(response.body.error).toContain(ERRORS.INVALID_OPTIONS);
+      expect(mockPool.query).not.toHaveBeenCalled();
+    });
+  });
 });
@@ -43,9 +43,77 @@ describe('ConversionService - Unit Tests', () => {
         originalName: 'user-photo.jpg',
         storedName: 'stored-123.jpg',
         status: TestConstants.STATUS.PENDING,
+        options: {},
       });
     });
 
+    it('should parse the options and pass them to the task', async () => {
+      const mockFile = TestDataFactory.createMockMulterFile();
+      const options = { watermark: { asset_id: 'logo' }, max_size: '25MB' };
+
+      mockTaskRepository.createConversion.mockResolvedValueOnce({
+        id: 'any-id',
+      });
+
+      await conversionService.process({
+        file: mockFile,
+        format: 'png',
+        options: JSON.stringify(options),
+      });
+
+      expect(mockTaskRepository.createConversion).toHaveBeenCalledWith(
+        expect.objectContaining({ options }),
+      );
+    });
+
+    it('should reject invalid options', async () => {
+      const mockFile = TestDataFactory.createMockMulterFile();
+
+      for (const options of ['{not json', '[]', '{"unknown":{}}']) {
+        await expect(
+          conversionService.process({ file: mockFile, format: 'png', options }),
+        ).rejects.toThrow(HttpError);
+      }
+
+      expect(mockTaskRepository.createConversion).not.toHaveBeenCalled();
+    });
+
+    it('should name the key of encrypted uploads and check their checksum', async () => {
+      const mockFile = {
+        ...TestDataFactory.createMockMulterFile(),
+        encryptionKeyId: 'primary',
+        checksum: `sha256:${'a'.repeat(64)}`,
+        storedChecksum: `sha256:${'b'.repeat(64)}`,
+      };
+
+      mockTaskRepository.createConversion.mockResolvedValueOnce({
+        id: 'any-id',
+      });
+
+      await conversionService.process({
+        file: mockFile,
+        format: 'png',
+        options: { input_checksum: 'A'.repeat(64) },
+      });
+
+      expect(mockTaskRepository.createConversion).toHaveBeenCalledWith(
+        expect.objectContaining({
+          options: {
+            input_checksum: `sha256:${'b'.repeat(64)}`,
+            input_encryption_key_id: 'primary',
+          },
+        }),
+      );
+
+      await expect(
+        conversionService.process({
+          file: mockFile,
+          format: 'png',
+          options: { input_checksum: `sha256:${'c'.repeat(64)}` },
+        }),
+      ).rejects.toThrow(HttpError);
+    });
+
     it('should reject unsupported media types based on mimetype parsing', async () => {
       const mockFile = TestDataFactory.createMockMulterFile({
         mimetype: 'application/pdf',
@@ -58,6 +126,40 @@ describe('ConversionService - Unit Tests', () => {
       expect(mockTaskRepository.createConversion).not.toHaveBeenCalled();
     });
 
+    it('should accept subtitle files for the subtitles target', async () => {
+      mockTaskRepository.createConversion.mockResolvedValue({ id: 'any-id' });
+
+      for (const mimetype of ['application/x-subrip', 'text/vtt']) {
+        const mockFile = TestDataFactory.createMockMulterFile({
+          mimetype,
+          originalname: 'episode.srt',
+        });
+
+        await conversionService.process({ file: mockFile, format: 'subtitles' });
+      }
+      expect(mockTaskRepository.createConversion).toHaveBeenCalledTimes(2);
+
+      const mockFile = TestDataFactory.createMockMulterFile({
+        mimetype: 'text/vtt',
+        originalname: 'episode.vtt',
+      });
+      await expect(
+        conversionService.process({ file: mockFile, format: 'mp4' }),
+      ).rejects.toThrow(HttpError);
+    });
+
+    it('should reject other text files', async () => {
+      const mockFile = TestDataFactory.createMockMulterFile({
+        mimetype: 'text/plain',
+      });
+
+      await expect(
+        conversionService.process({ file: mockFile, format: 'subtitles' }),
+      ).rejects.toThrow(HttpError);
+
+      expect(mockTaskRepository.createConversion).not.toHaveBeenCalled();
+    });
+
     it('should reject invalid formats for valid media types', async () => {
       const mockFile = TestDataFactory.createMockMulterFile({
         mimetype: TestConstants.MEDIA_TYPES.IMAGE_JPEG,
@@ -312,7 +414,98 @@ describe('ConversionService - Unit Tests', () => {
         originalName: 'photo.jpg',
         storedName: 'photo-123.jpg',
         status: TestConstants.STATUS.PENDING,
+        options: {},
       });
     });
   });
+
+  describe('compose', () => {
+    const composeFiles = () => [
+      TestDataFactory.createMockMulterFile({
+        originalname: 'first.jpg',
+        path: '/uploads/first.jpg',
+        filename: 'first.jpg',
+        size: 100,
+      }),
+      TestDataFactory.createMockMulterFile({
+        originalname: 'soundtrack.mp3',
+        mimetype: TestConstants.MEDIA_TYPES.AUDIO_MP3,
+        path: '/uploads/soundtrack.mp3',
+        filename: 'soundtrack.mp3',
+        size: 200,
+      }),
+    ];
+    const slideshow = JSON.stringify({ compose: { mode: 'slideshow' } });
+
+    it('should create a task with every file as an input', async () => {
+      mockTaskRepository.createConversion.mockResolvedValueOnce({ id: 'any-id' });
+
+      await conversionService.compose({
+        files: composeFiles(),
+        format: 'mp4',
+        options: slideshow,
+        durations: '[4, null]',
+      });
+
+      expect(mockTaskRepository.createConversion).toHaveBeenCalledWith({
+        inputPath: '/uploads/first.jpg',
+        mimetype: 'image/jpeg',
+        format: 'mp4',
+        fileSize: 100,
+        originalName: 'first.jpg',
+        storedName: 'first.jpg',
+        status: TestConstants.STATUS.PENDING,
+        options: { compose: { mode: 'slideshow' } },
+        inputs: [
+          {
+            path: '/uploads/first.jpg',
+            original_name: 'first.jpg',
+            mimetype: 'image/jpeg',
+            file_size: 100,
+            duration: 4,
+          },
+          {
+            path: '/uploads/soundtrack.mp3',
+            original_name: 'soundtrack.mp3',
+            mimetype: TestConstants.MEDIA_TYPES.AUDIO_MP3,
+            file_size: 200,
+            duration: undefined,
+          },
+        ],
+      });
+    });
+
+    it.each([
+      ['a single file', { files: composeFiles().slice(0, 1) }],
+      ['a format the composer does not write', { format: 'gif' }],
+      ['missing compose options', { options: '{}' }],
+      ['an unknown mode', { options: '{"compose":{"mode":"mosaic"}}' }],
+      [
+        'options compose jobs ignore',
+        { options: '{"compose":{"mode":"concat"},"watermark":{}}' },
+      ],
+      ['a duration per file missing', { durations: '[4]' }],
+      ['a negative duration', { durations: '[4, -1]' }],
+      [
+        'files that are not media',
+        {
+          files: [
+            ...composeFiles(),
+            TestDataFactory.createMockMulterFile({ mimetype: 'application/pdf' }),
+          ],
+        },
+      ],
+    ])('should reject %s', async (_, overrides) => {
+      await expect(
+        conversionService.compose({
+          files: composeFiles(),
+          format: 'mp4',
+          options: slideshow,
+          ...overrides,
+        }),
+      ).rejects.toThrow(HttpError);
+
+      expect(mockTaskRepository.createConversion).not.toHaveBeenCalled();
+    });
+  });
 });
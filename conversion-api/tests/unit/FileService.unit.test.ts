@@ -1,9 +1,11 @@
 import { beforeEach, describe, expect, it, vi } from 'vitest';
 import { FileService } from '../../src/service/file/FileService';
 import { HttpError } from '../../src/errors/HttpError';
-import { existsSync } from 'fs';
+import { createReadStream, existsSync } from 'fs';
+import { Readable } from 'stream';
 import { TestConstants } from '../helpers/test-helpers';
 import { ERRORS } from '../../src/utils/constants';
+import { loadKeyring } from '../../src/utils/encryption';
 
 const mockTaskRepository = {
   getTaskById: vi.fn(),
@@ -11,12 +13,14 @@ const mockTaskRepository = {
 };
 
 vi.mock('fs', () => ({
+  createReadStream: vi.fn(),
   existsSync: vi.fn(),
 }));
 
 describe('FileService - Unit Tests', () => {
   let fileService: FileService;
   const mockExistsSync = vi.mocked(existsSync);
+  const mockCreateReadStream = vi.mocked(createReadStream);
 
   beforeEach(() => {
     vi.clearAllMocks();
@@ -56,7 +60,81 @@ describe('FileService - Unit Tests', () => {
 
       const result = await fileService.download(id);
 
-      expect(result).toEqual(mockFile.output_path);
+      expect(result).toEqual({ fileName: 'file', path: mockFile.output_path });
+    });
+
+    it('should name cached outputs after the task', async () => {
+      const id = '123';
+      const mockFile = {
+        output_path: 'path/to/cache/3f2a.mp4',
+        status: 'completed',
+        metadata: { filename: '123.mp4' },
+      };
+
+      mockTaskRepository.getTaskById.mockResolvedValue(mockFile);
+      mockExistsSync.mockReturnValue(true);
+
+      const result = await fileService.download(id);
+
+      expect(result).toEqual({
+        fileName: '123.mp4',
+        path: mockFile.output_path,
+      });
+    });
+
+    it('should decrypt encrypted outputs', async () => {
+      const id = '123';
+      const mockFile = {
+        output_path: 'path/to/file',
+        status: 'completed',
+        metadata: { encryption_key_id: 'primary' },
+      };
+
+      mockTaskRepository.getTaskById.mockResolvedValue(mockFile);
+      mockExistsSync.mockReturnValue(true);
+      // Written by the worker with the key below.
+      mockCreateReadStream.mockReturnValue(
+        Readable.from([
+          Buffer.from(
+            'Q1ZFTkMxAAAAAQAAB3ByaW1hcnkAPLyYNf8n/Yss6Z0ieX7LiJoTwy3+S8XadgpB1KMkRnhctTxpj8ZEiJE3TIo0qT3/JELWn/96gzbRSsXZVS8LoE8xtzQm43k4pf1EfhIJ4MtfWGWUzfDgQc3kOOSgx0x5ue56qRMwZ3sSO1/egw==',
+            'base64',
+          ),
+        ]) as any,
+      );
+      fileService = new FileService(
+        mockTaskRepository as any,
+        loadKeyring('BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=', 'primary', ''),
+      );
+
+      const result = await fileService.download(id);
+
+      expect(result.fileName).toBe('file');
+      expect('content' in result).toBe(true);
+      const chunks: Buffer[] = [];
+      for await (const chunk of (result as { content: Readable }).content) {
+        chunks.push(chunk);
+      }
+      expect(Buffer.concat(chunks).toString()).toBe(
+        'converted output from the worker',
+      );
+    });
+
+    it('should refuse encrypted outputs without a key', async () => {
+      const id = '123';
+      mockTaskRepository.getTaskById.mockResolvedValue({
+        output_path: 'path/to/file',
+        status: 'completed',
+        metadata: { encryption_key_id: 'primary' },
+      });
+      mockExistsSync.mockReturnValue(true);
+      fileService = new FileService(mockTaskRepository as any, null);
+
+      await expect(fileService.download(id)).rejects.toThrow(
+        new HttpError(
+          ERRORS.ENCRYPTION_NOT_CONFIGURED,
+          TestConstants.HTTP_CODES.INTERNAL_SERVER_ERROR,
+        ),
+      );
     });
 
     it('should throw an error if the file is not found', async () => {
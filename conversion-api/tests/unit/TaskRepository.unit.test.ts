@@ -43,7 +43,7 @@ describe('TaskRepository - Unit Tests', () => {
 
       expect(mockPool.query).toHaveBeenNthCalledWith(
         1,
-        'SELECT create_conversion_task_with_outbox($1, $2, $3, $4, $5, $6, $7)',
+        'SELECT create_conversion_task_with_outbox($1, $2, $3, $4, $5, $6, $7, $8, $9)',
         [
           conversionData.originalName,
           conversionData.storedName,
@@ -52,6 +52,8 @@ describe('TaskRepository - Unit Tests', () => {
           conversionData.format,
           conversionData.fileSize,
           null,
+          JSON.stringify(conversionData.options),
+          null,
         ],
       );
 
@@ -62,6 +64,41 @@ describe('TaskRepository - Unit Tests', () => {
       );
     });
 
+    it('should pass the inputs of multi-input tasks', async () => {
+      const inputs = [
+        {
+          path: '/tmp/uploads/first.jpg',
+          original_name: 'first.jpg',
+          mimetype: 'image/jpeg',
+          file_size: 1024,
+          duration: 5,
+        },
+        {
+          path: '/tmp/uploads/second.jpg',
+          original_name: 'second.jpg',
+          mimetype: 'image/jpeg',
+          file_size: 2048,
+        },
+      ];
+      const conversionData = TestDataFactory.createMockConversionData({
+        inputPath: inputs[0].path,
+        format: 'mp4',
+        options: { compose: { mode: 'slideshow' } },
+      });
+
+      mockPool.query
+        .mockResolvedValueOnce({
+          rows: [{ create_conversion_task_with_outbox: 'slideshow-id' }],
+        } as any)
+        .mockResolvedValueOnce({ rows: [{ id: 'slideshow-id' }] } as any);
+
+      await taskRepository.createConversion({ ...conversionData, inputs });
+
+      const [, params] = mockPool.query.mock.calls[0];
+      expect(JSON.parse(params[7])).toEqual(conversionData.options);
+      expect(JSON.parse(params[8])).toEqual(inputs);
+    });
+
     it('should handle database error during task creation', async () => {
       const conversionData = TestDataFactory.createMockConversionData();
       const dbError = new Error(ERRORS.CREATE_CONVERSION);
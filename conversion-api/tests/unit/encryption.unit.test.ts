@@ -0,0 +1,140 @@
+import { describe, expect, it } from 'vitest';
+import { createCipheriv, randomBytes } from 'crypto';
+import { Readable } from 'stream';
+import {
+  createDecryptStream,
+  createEncryptStream,
+  loadKeyring,
+  parseMasterKey,
+} from '../../src/utils/encryption';
+
+// Written by the worker's storage.CreateEncrypted with the key below.
+const WORKER_KEY = 'BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=';
+const WORKER_OBJECT =
+  'Q1ZFTkMxAAAAAQAAB3ByaW1hcnkAPLyYNf8n/Yss6Z0ieX7LiJoTwy3+S8XadgpB1KMkRnhctTxpj8ZEiJE3TIo0qT3/JELWn/96gzbRSsXZVS8LoE8xtzQm43k4pf1EfhIJ4MtfWGWUzfDgQc3kOOSgx0x5ue56qRMwZ3sSO1/egw==';
+
+function seal(key: Buffer, nonce: Buffer, plain: Buffer, aad: Buffer) {
+  const cipher = createCipheriv('aes-256-gcm', key, nonce);
+  cipher.setAAD(aad);
+  return Buffer.concat([cipher.update(plain), cipher.final(), cipher.getAuthTag()]);
+}
+
+// encrypt builds an object in the worker's format, with small chunks so
+// tests cover several of them.
+function encrypt(master: Buffer, keyId: string, plain: Buffer, chunkSize: number) {
+  const dataKey = randomBytes(32);
+  const keyNonce = randomBytes(12);
+  const wrapped = Buffer.concat([
+    keyNonce,
+    seal(master, keyNonce, dataKey, Buffer.from(keyId)),
+  ]);
+
+  const header = Buffer.alloc(8 + 4 + 1);
+  header.write('CVENC1\0\0', 'latin1');
+  header.writeUInt32BE(chunkSize, 8);
+  header.writeUInt8(keyId.length, 12);
+  const keyLength = Buffer.alloc(2);
+  keyLength.writeUInt16BE(wrapped.length);
+
+  const parts = [header, Buffer.from(keyId), keyLength, wrapped];
+  const chunks = Math.max(Math.ceil(plain.length / chunkSize), 1);
+  for (let i = 0; i < chunks; i++) {
+    const nonce = Buffer.alloc(12);
+    nonce.writeBigUInt64BE(BigInt(i), 4);
+    const final = i === chunks - 1;
+    parts.push(
+      seal(
+        dataKey,
+        nonce,
+        plain.subarray(i * chunkSize, (i + 1) * chunkSize),
+        Buffer.from([final ? 1 : 0]),
+      ),
+    );
+  }
+  return Buffer.concat(parts);
+}
+
+async function decrypt(data: Buffer, keyring = loadKeyring(WORKER_KEY, 'primary', '')!) {
+  // Small pieces make the stream reassemble the header and chunks.
+  const pieces = [];
+  for (let i = 0; i < data.length; i += 7) {
+    pieces.push(data.subarray(i, i + 7));
+  }
+  const output: Buffer[] = [];
+  for await (const piece of Readable.from(pieces).pipe(createDecryptStream(keyring))) {
+    output.push(piece);
+  }
+  return Buffer.concat(output);
+}
+
+describe('encryption - Unit Tests', () => {
+  it('should decrypt an object written by the worker', async () => {
+    const plain = await decrypt(Buffer.from(WORKER_OBJECT, 'base64'));
+    expect(plain.toString()).toBe('converted output from the worker');
+  });
+
+  it('should decrypt objects of several chunks', async () => {
+    const master = parseMasterKey(WORKER_KEY);
+    for (const size of [0, 1, 31, 32, 33, 100]) {
+      const plain = randomBytes(size);
+      const decrypted = await decrypt(encrypt(master, 'primary', plain, 32));
+      expect(decrypted.equals(plain)).toBe(true);
+    }
+  });
+
+  it('should encrypt objects the decrypt stream reads', async () => {
+    const keyring = loadKeyring(WORKER_KEY, 'primary', '')!;
+    for (const size of [0, 1, 31, 32, 33, 100]) {
+      const plain = randomBytes(size);
+      const output: Buffer[] = [];
+      for await (const piece of Readable.from([plain]).pipe(
+        createEncryptStream(keyring, 'primary', 32),
+      )) {
+        output.push(piece);
+      }
+      const encrypted = Buffer.concat(output);
+      expect(encrypted.subarray(0, 8).toString('latin1')).toBe('CVENC1\0\0');
+      expect((await decrypt(encrypted)).equals(plain)).toBe(true);
+    }
+  });
+
+  it('should decrypt with a previous key', async () => {
+    const previous = randomBytes(32);
+    const keyring = loadKeyring(
+      WORKER_KEY,
+      'primary',
+      `old:${previous.toString('hex')}`,
+    )!;
+    const plain = Buffer.from('written before the rotation');
+    const decrypted = await decrypt(encrypt(previous, 'old', plain, 16), keyring);
+    expect(decrypted.equals(plain)).toBe(true);
+  });
+
+  it('should reject truncated objects', async () => {
+    const master = parseMasterKey(WORKER_KEY);
+    const data = encrypt(master, 'primary', randomBytes(100), 32);
+    // Drop the last chunk, so the one before it ends the stream.
+    await expect(decrypt(data.subarray(0, data.length - (100 - 96) - 16))).rejects.toThrow(
+      /Failed to decrypt chunk/,
+    );
+  });
+
+  it('should reject modified objects', async () => {
+    const data = Buffer.from(WORKER_OBJECT, 'base64');
+    data[data.length - 20] ^= 1;
+    await expect(decrypt(data)).rejects.toThrow(/Failed to decrypt chunk 0/);
+  });
+
+  it('should reject unknown keys and plaintext', async () => {
+    const data = encrypt(randomBytes(32), 'other', Buffer.from('x'), 32);
+    await expect(decrypt(data)).rejects.toThrow(/Unknown encryption key "other"/);
+    await expect(decrypt(Buffer.from('plain text, not encrypted'))).rejects.toThrow(
+      /not encrypted/,
+    );
+  });
+
+  it('should return no keyring without a key', () => {
+    expect(loadKeyring('', 'primary', '')).toBeNull();
+    expect(() => parseMasterKey('short')).toThrow(/32 bytes/);
+  });
+});
@@ -0,0 +1,47 @@
+import { describe, it, expect } from 'vitest';
+import { parseOptions } from '../../src/utils/options';
+import { HttpError } from '../../src/errors/HttpError';
+import { ERRORS } from '../../src/utils/constants';
+
+describe('Options - parseOptions', () => {
+  it('should return an empty object when no options are sent', () => {
+    expect(parseOptions(undefined)).toEqual({});
+    expect(parseOptions(null)).toEqual({});
+    expect(parseOptions('')).toEqual({});
+  });
+
+  it('should parse options sent as a JSON string', () => {
+    const options = {
+      metadata: { mode: 'strip' },
+      text_overlays: [{ text: 'Hello' }],
+      max_size: '25 MiB',
+      input_checksum: 'abc',
+    };
+
+    expect(parseOptions(JSON.stringify(options))).toEqual(options);
+  });
+
+  it('should accept options sent as an object', () => {
+    expect(parseOptions({ max_size: 1024 })).toEqual({ max_size: 1024 });
+  });
+
+  it('should only accept compose when allowed', () => {
+    const options = { compose: { mode: 'concat' } };
+
+    expect(() => parseOptions(options)).toThrow(HttpError);
+    expect(parseOptions(options, { allowCompose: true })).toEqual(options);
+  });
+
+  it.each([
+    ['invalid JSON', '{not json'],
+    ['a non-object', '[]'],
+    ['an unknown option', { scale: 2 }],
+    ['an object option that is not an object', { watermark: 'logo' }],
+    ['overlays that are not objects', { text_overlays: ['Hello'] }],
+    ['a size without a valid unit', { max_size: '25 parsecs' }],
+    ['a negative size', { max_size: -1 }],
+    ['a checksum that is not a string', { input_checksum: 42 }],
+  ])('should reject %s', (_, options) => {
+    expect(() => parseOptions(options)).toThrow(ERRORS.INVALID_OPTIONS);
+  });
+});
@@ -0,0 +1,53 @@
+// Command decrypt writes the plaintext of a file the worker stored with
+// encryption at rest. It reads the same ENCRYPTION_KEY, ENCRYPTION_KEY_ID and
+// ENCRYPTION_PREVIOUS_KEYS variables as the worker:
+//
+//	decrypt /tmp/output/<task_id>.mp4 > video.mp4
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/storage"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: decrypt <file>")
+		os.Exit(2)
+	}
+	if err := run(os.Args[1], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "decrypt:", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, out io.Writer) error {
+	keyID := os.Getenv("ENCRYPTION_KEY_ID")
+	if keyID == "" {
+		keyID = "primary"
+	}
+	var previous []string
+	for _, entry := range strings.Split(os.Getenv("ENCRYPTION_PREVIOUS_KEYS"), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			previous = append(previous, entry)
+		}
+	}
+
+	keys, err := storage.ParseKeyring(keyID, os.Getenv("ENCRYPTION_KEY"), previous)
+	if err != nil {
+		return err
+	}
+
+	file, err := storage.OpenDecrypted(path, keys)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(out, io.NewSectionReader(file, 0, file.Size()))
+	return err
+}
@@ -2,15 +2,23 @@ package main
 
 import (
 	"context"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/config"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/converter"
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/database"
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/queue"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/server"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/storage"
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/worker"
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -31,10 +39,12 @@ func main() {
 }
 
 type App struct {
-	config *config.Config
-	pool   *worker.Pool
-	queue  queue.Queue
-	db     database.Repository
+	config    *config.Config
+	pool      *worker.Pool
+	retention *worker.Retention
+	server    *server.Server
+	queue     queue.Queue
+	db        database.Repository
 }
 
 func initializeServices(cfg *config.Config) (*App, error) {
@@ -52,25 +62,188 @@ func initializeServices(cfg *config.Config) (*App, error) {
 	}
 	logger.Info("Connected to PostgreSQL")
 
+	reportCapabilities(ctx)
+
+	outputURI, err := storage.ParseURI(cfg.Storage.OutputURI)
+	if err != nil {
+		return nil, err
+	}
+	outputName, err := worker.ParseNameTemplate(cfg.Storage.OutputName)
+	if err != nil {
+		return nil, err
+	}
+
+	workDir := worker.WorkspaceRoot(cfg.Worker.WorkDir)
+	if removed, err := worker.CleanWorkspaces(workDir); err != nil {
+		logger.Warn("Failed to clean up leftover workspaces in %s: %v", workDir, err)
+	} else if removed > 0 {
+		logger.Info("Removed %d leftover workspaces from %s", removed, workDir)
+	}
+
+	keys, err := keyring(cfg.Storage.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	// Local files may only be read and written in the upload, output and
+	// work directories.
+	inputDir, err := filepath.Abs(cfg.Storage.InputDir)
+	if err != nil {
+		return nil, err
+	}
+	localDirs := []string{inputDir, workDir}
+	if outputURI.IsLocal() {
+		localDirs = append(localDirs, outputURI.Key)
+	}
+	store, err := storage.NewManager(localDirs, s3Config(cfg.Storage), httpConfig(cfg.Storage.HTTP), keys)
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		logger.Info("Encryption at rest enabled with key %q", keys.KeyID())
+	}
+	if cfg.Storage.Encryption.Required {
+		store.RequireEncryption()
+		logger.Info("Objects stored in plaintext will be rejected")
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	workerMetrics := worker.NewMetrics(registry)
+	instrumentedQueue := workerMetrics.Queue(redisQueue)
+	instrumentedDB := workerMetrics.Repository(db)
+
+	cacheSettings := worker.CacheSettings{TTL: cfg.Storage.CacheTTL}
+	retention := worker.NewRetention(instrumentedDB, store, worker.RetentionSettings{
+		DeleteInputs: cfg.Retention.DeleteInputs,
+		InputDir:     inputDir,
+		OutputTTL:    cfg.Retention.OutputTTL,
+		Interval:     cfg.Retention.Interval,
+		OutboxDays:   cfg.Retention.OutboxDays,
+		DryRun:       cfg.Retention.DryRun,
+		CollectCache: cacheSettings.TTL > 0,
+	})
+	workerMetrics.WatchRetention(retention)
+
 	poolConfig := worker.PoolConfig{
 		LightWorkers: cfg.Worker.LightWorkers,
 		HeavyWorkers: cfg.Worker.HeavyWorkers,
 		WorkerType:   cfg.Worker.Type,
+		Settings: worker.Settings{
+			AssetsDir:  cfg.Worker.AssetsDir,
+			WorkDir:    workDir,
+			Storage:    store,
+			OutputURI:  outputURI,
+			OutputName: outputName,
+			Cache:      cacheSettings,
+			Retention:  retention,
+			Admission: worker.AdmissionSettings{
+				MinFreeDisk:   cfg.Worker.MinFreeDisk,
+				MinFreeMemory: cfg.Worker.MinFreeMemory,
+				RetryDelay:    cfg.Worker.RetryDelay,
+				MaxDeferrals:  cfg.Worker.MaxDeferrals,
+			},
+			Liveness: worker.LivenessSettings{
+				JobBase:     cfg.Server.JobBase,
+				JobFactor:   cfg.Server.JobFactor,
+				LoopTimeout: cfg.Server.LoopTimeout,
+			},
+			PresignExpiry: cfg.Storage.PresignExpiry,
+			Metrics:       workerMetrics,
+		},
 	}
-	workerPool := worker.NewPool(poolConfig, redisQueue, db)
+	workerPool := worker.NewPool(poolConfig, instrumentedQueue, instrumentedDB)
+
+	dirs := []string{os.TempDir(), workDir}
+	if outputURI.IsLocal() {
+		dirs = append(dirs, outputURI.Key)
+	}
+	health := worker.NewHealth(redisQueue, db, workerPool, poolConfig.Settings.Liveness, dirs...)
+
+	httpServer := server.New(cfg.Server.Addr)
+	httpServer.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	httpServer.Handle("/healthz", http.HandlerFunc(health.Live))
+	httpServer.Handle("/readyz", http.HandlerFunc(health.Ready))
+	httpServer.Handle("/status", http.HandlerFunc(health.Status))
 
 	return &App{
-		config: cfg,
-		pool:   workerPool,
-		queue:  redisQueue,
-		db:     db,
+		config:    cfg,
+		pool:      workerPool,
+		retention: retention,
+		server:    httpServer,
+		queue:     redisQueue,
+		db:        db,
 	}, nil
 }
 
+// s3Config returns the S3 settings, or nil when no credentials are set and
+// s3:// URIs cannot be used.
+func s3Config(cfg config.StorageConfig) *storage.S3Config {
+	if cfg.S3AccessKey == "" {
+		return nil
+	}
+	return &storage.S3Config{
+		Endpoint:        cfg.S3Endpoint,
+		Region:          cfg.S3Region,
+		AccessKeyID:     cfg.S3AccessKey,
+		SecretAccessKey: cfg.S3SecretKey,
+		PathStyle:       cfg.S3PathStyle,
+	}
+}
+
+// httpConfig returns the limits for http(s):// inputs, or nil when they are
+// disabled.
+func httpConfig(cfg config.HTTPInputConfig) *storage.HTTPConfig {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &storage.HTTPConfig{
+		Timeout:      cfg.Timeout,
+		MaxSize:      cfg.MaxSize,
+		ContentTypes: cfg.ContentTypes,
+		Allow:        cfg.Allow,
+	}
+}
+
+// keyring returns the master keys for encryption at rest, or nil when no key
+// is set and objects are stored in plaintext.
+func keyring(cfg config.EncryptionConfig) (*storage.Keyring, error) {
+	if cfg.Key == "" {
+		return nil, nil
+	}
+	return storage.ParseKeyring(cfg.KeyID, cfg.Key, cfg.PreviousKeys)
+}
+
+func reportCapabilities(ctx context.Context) {
+	caps, err := converter.DetectCapabilities(ctx)
+	if err != nil {
+		logger.Warn("Could not detect ffmpeg capabilities: %v", err)
+		return
+	}
+
+	for _, feature := range caps.Features() {
+		if feature.Supported {
+			logger.Info("ffmpeg capability %s: available (%s)", feature.Name, feature.Detail)
+		} else {
+			logger.Warn("ffmpeg capability %s: unavailable (requires %s)", feature.Name, feature.Detail)
+		}
+	}
+
+	for _, target := range caps.DisabledTargets() {
+		logger.Warn("Target %s disabled: %s", target.Name, target.Detail)
+	}
+}
+
 func (app *App) run() error {
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	app.pool.Start(ctx)
+	go app.retention.Run(ctx)
+	go func() {
+		logger.Info("Serving metrics and health checks on %s", app.server.Addr())
+		if err := app.server.Run(ctx); err != nil {
+			logger.Error("HTTP server stopped: %v", err)
+		}
+	}()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
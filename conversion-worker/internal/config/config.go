@@ -4,15 +4,21 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Redis    RedisConfig
-	Database DatabaseConfig
-	Worker   WorkerConfig
-	App      AppConfig
+	Redis     RedisConfig
+	Database  DatabaseConfig
+	Worker    WorkerConfig
+	Storage   StorageConfig
+	Retention RetentionConfig
+	Server    ServerConfig
+	App       AppConfig
 }
 
 type RedisConfig struct {
@@ -33,6 +39,72 @@ type WorkerConfig struct {
 	LightWorkers int
 	HeavyWorkers int
 	Type         string
+	AssetsDir    string
+	WorkDir      string
+	// Admission control: resources kept free, how long to wait after
+	// deferring a job that does not fit, and how often a job may be deferred
+	// before it fails.
+	MinFreeDisk   int64
+	MinFreeMemory int64
+	RetryDelay    time.Duration
+	MaxDeferrals  int
+}
+
+// StorageConfig says where outputs are written, where the API leaves
+// uploads, how to reach S3-compatible buckets referenced by s3:// URIs and
+// what http(s):// inputs may be fetched.
+type StorageConfig struct {
+	InputDir      string
+	OutputURI     string
+	OutputName    string
+	S3Endpoint    string
+	S3Region      string
+	S3AccessKey   string
+	S3SecretKey   string
+	S3PathStyle   bool
+	PresignExpiry time.Duration
+	CacheTTL      time.Duration
+	HTTP          HTTPInputConfig
+	Encryption    EncryptionConfig
+}
+
+// EncryptionConfig holds the master keys for encryption at rest. Previous
+// keys are "id:key" pairs kept to read objects written before a rotation.
+// Required rejects objects stored in plaintext instead of reading them as
+// they are.
+type EncryptionConfig struct {
+	Key          string
+	KeyID        string
+	PreviousKeys []string
+	Required     bool
+}
+
+// HTTPInputConfig limits downloads of http(s):// inputs.
+type HTTPInputConfig struct {
+	Enabled      bool
+	Timeout      time.Duration
+	MaxSize      int64
+	ContentTypes []string
+	Allow        []string
+}
+
+// RetentionConfig controls what the worker deletes and when.
+type RetentionConfig struct {
+	DeleteInputs bool
+	OutputTTL    time.Duration
+	Interval     time.Duration
+	OutboxDays   int
+	DryRun       bool
+}
+
+type ServerConfig struct {
+	Addr string
+	// Liveness: a job is expected to take JobBase plus JobFactor seconds per
+	// second of media, and an idle worker to look for jobs every
+	// LoopTimeout.
+	JobBase     time.Duration
+	JobFactor   int
+	LoopTimeout time.Duration
 }
 
 type AppConfig struct {
@@ -56,9 +128,53 @@ func Load() (*Config, error) {
 			Database: getEnvOrDefault("POSTGRES_DB", "conversion"),
 		},
 		Worker: WorkerConfig{
-			LightWorkers: getEnvInt("LIGHT_WORKERS", 2),
-			HeavyWorkers: getEnvInt("HEAVY_WORKERS", 1),
-			Type:         getEnvOrDefault("WORKER_TYPE", ""),
+			LightWorkers:  getEnvInt("LIGHT_WORKERS", 2),
+			HeavyWorkers:  getEnvInt("HEAVY_WORKERS", 1),
+			Type:          getEnvOrDefault("WORKER_TYPE", ""),
+			AssetsDir:     getEnvOrDefault("ASSETS_DIR", "/tmp/assets"),
+			WorkDir:       getEnvOrDefault("WORK_DIR", "/tmp/work"),
+			MinFreeDisk:   getEnvByteSize("ADMISSION_MIN_FREE_DISK", 512<<20),
+			MinFreeMemory: getEnvByteSize("ADMISSION_MIN_FREE_MEMORY", 256<<20),
+			RetryDelay:    getEnvDuration("ADMISSION_RETRY_DELAY", 30*time.Second),
+			MaxDeferrals:  getEnvInt("ADMISSION_MAX_DEFERRALS", 60),
+		},
+		Storage: StorageConfig{
+			InputDir:      getEnvOrDefault("INPUT_DIR", "/tmp/input"),
+			OutputURI:     getEnvOrDefault("STORAGE_OUTPUT_URI", "file:///tmp/output"),
+			OutputName:    getEnvOrDefault("OUTPUT_NAME_TEMPLATE", "{task_id}.{ext}"),
+			S3Endpoint:    getEnvOrDefault("S3_ENDPOINT", ""),
+			S3Region:      getEnvOrDefault("S3_REGION", "us-east-1"),
+			S3AccessKey:   getEnvOrDefault("S3_ACCESS_KEY_ID", ""),
+			S3SecretKey:   getEnvOrDefault("S3_SECRET_ACCESS_KEY", ""),
+			S3PathStyle:   getEnvOrDefault("S3_PATH_STYLE", "true") == "true",
+			PresignExpiry: getEnvDuration("S3_PRESIGN_EXPIRY", 24*time.Hour),
+			CacheTTL:      getEnvDuration("CACHE_TTL", 0),
+			HTTP: HTTPInputConfig{
+				Enabled:      getEnvOrDefault("HTTP_INPUT_ENABLED", "false") == "true",
+				Timeout:      getEnvDuration("HTTP_INPUT_TIMEOUT", 10*time.Minute),
+				MaxSize:      getEnvByteSize("HTTP_INPUT_MAX_SIZE", 2<<30),
+				ContentTypes: getEnvList("HTTP_INPUT_CONTENT_TYPES", "video/*,audio/*,image/*,application/octet-stream"),
+				Allow:        getEnvList("HTTP_INPUT_ALLOW", ""),
+			},
+			Encryption: EncryptionConfig{
+				Key:          getEnvOrDefault("ENCRYPTION_KEY", ""),
+				KeyID:        getEnvOrDefault("ENCRYPTION_KEY_ID", "primary"),
+				PreviousKeys: getEnvList("ENCRYPTION_PREVIOUS_KEYS", ""),
+				Required:     getEnvOrDefault("ENCRYPTION_REQUIRED", "false") == "true",
+			},
+		},
+		Retention: RetentionConfig{
+			DeleteInputs: getEnvOrDefault("RETENTION_DELETE_INPUTS", "false") == "true",
+			OutputTTL:    getEnvDuration("RETENTION_OUTPUT_TTL", 0),
+			Interval:     getEnvDuration("RETENTION_INTERVAL", time.Hour),
+			OutboxDays:   getEnvInt("RETENTION_OUTBOX_DAYS", 7),
+			DryRun:       getEnvOrDefault("RETENTION_DRY_RUN", "false") == "true",
+		},
+		Server: ServerConfig{
+			Addr:        getEnvOrDefault("HTTP_ADDR", ":9090"),
+			JobBase:     getEnvDuration("LIVENESS_JOB_BASE", 15*time.Minute),
+			JobFactor:   getEnvInt("LIVENESS_JOB_FACTOR", 10),
+			LoopTimeout: getEnvDuration("LIVENESS_LOOP_TIMEOUT", 5*time.Minute),
 		},
 		App: AppConfig{
 			Environment: getEnvOrDefault("ENVIRONMENT", "development"),
@@ -94,6 +210,17 @@ func (c *Config) validate() error {
 	if c.Database.Database == "" {
 		return fmt.Errorf("POSTGRES_DB is required")
 	}
+	if strings.HasPrefix(c.Storage.OutputURI, "s3://") && (c.Storage.S3AccessKey == "" || c.Storage.S3SecretKey == "") {
+		return fmt.Errorf("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for an s3:// STORAGE_OUTPUT_URI")
+	}
+	if c.Storage.Encryption.Required && c.Storage.Encryption.Key == "" {
+		return fmt.Errorf("ENCRYPTION_KEY is required when ENCRYPTION_REQUIRED is true")
+	}
+	// A worker waits ADMISSION_RETRY_DELAY after deferring a job without
+	// looking for another one, which must not look stuck.
+	if c.Server.LoopTimeout > 0 && c.Server.LoopTimeout <= c.Worker.RetryDelay {
+		return fmt.Errorf("LIVENESS_LOOP_TIMEOUT (%v) must exceed ADMISSION_RETRY_DELAY (%v)", c.Server.LoopTimeout, c.Worker.RetryDelay)
+	}
 	return nil
 }
 
@@ -105,3 +232,32 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
+func getEnvByteSize(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if size, err := models.ParseByteSize(value); err == nil {
+			return int64(size)
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries.
+func getEnvList(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnvOrDefault(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
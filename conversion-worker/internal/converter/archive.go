@@ -0,0 +1,55 @@
+package converter
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+)
+
+// zipArchive writes a ZIP file entry by entry, so outputs made of many files
+// can be streamed into the archive as they are produced.
+type zipArchive struct {
+	file   io.WriteCloser
+	writer *zip.Writer
+}
+
+// createZipArchive creates the ZIP output of req at path.
+func createZipArchive(req Request, path string) (*zipArchive, error) {
+	file, err := createOutput(req, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ZIP file: %w", err)
+	}
+	return &zipArchive{file: file, writer: zip.NewWriter(file)}, nil
+}
+
+// create starts an entry under name and returns its writer, valid until
+// the next entry is started. Entries are not compressed again because the
+// media files put in archives are compressed already.
+func (a *zipArchive) create(name string) (io.Writer, error) {
+	entry, err := a.writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add %s to ZIP: %w", name, err)
+	}
+	return entry, nil
+}
+
+// add stores data under name.
+func (a *zipArchive) add(name string, data []byte) error {
+	entry, err := a.create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := entry.Write(data); err != nil {
+		return fmt.Errorf("failed to add %s to ZIP: %w", name, err)
+	}
+	return nil
+}
+
+func (a *zipArchive) Close() error {
+	writerErr := a.writer.Close()
+	fileErr := a.file.Close()
+	if writerErr != nil {
+		return fmt.Errorf("failed to finalize ZIP file: %w", writerErr)
+	}
+	return fileErr
+}
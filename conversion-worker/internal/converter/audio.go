@@ -3,7 +3,6 @@ package converter
 import (
 	"context"
 	"fmt"
-	"os/exec"
 )
 
 type AudioConverter struct {
@@ -11,36 +10,87 @@ type AudioConverter struct {
 }
 
 func (c *AudioConverter) SupportedFormats() []string {
-	return []string{"mp3", "wav", "flac", "ogg", "wma", "aac"}
+	return []string{"mp3", "wav", "flac", "ogg", "wma", "aac", "m4a", "opus", "waveform", "spectrogram", "chunks"}
 }
 
-func (c *AudioConverter) Convert(ctx context.Context, input, format, output string) error {
-	if err := c.validatePaths(input, output); err != nil {
+func (c *AudioConverter) Convert(ctx context.Context, req Request) error {
+	if err := c.validatePaths(req.Input, req.Output); err != nil {
+		return err
+	}
+	if err := checkTransformTarget(req); err != nil {
+		return err
+	}
+
+	switch req.Format {
+	case "waveform":
+		return renderWaveform(ctx, req)
+	case "spectrogram":
+		return renderSpectrogram(ctx, req)
+	case "chunks":
+		return c.convertToChunks(ctx, req)
+	}
+
+	if _, err := requireTarget(ctx, req.Format); err != nil {
+		return err
+	}
+	codecArgs, err := audioCodecArgs(req.Format)
+	if err != nil {
 		return err
 	}
 
-	var cmd *exec.Cmd
+	coverArgs, err := coverArtArgs(req.Format, req.Options.Metadata)
+	if err != nil {
+		return err
+	}
 
-	switch format {
-	case "mp3":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-vn", "-acodec", "libmp3lame", output)
-	case "wav":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, output)
-	case "flac":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-vn", "-acodec", "flac", output)
-	case "ogg":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-vn", "-acodec", "libvorbis", output)
-	case "wma":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-vn", "-acodec", "wmav2", output)
-	case "aac":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-vn", "-acodec", "aac", output)
-	default:
-		return fmt.Errorf("unsupported audio format: %s", format)
+	metaArgs, err := metadataArgs(ctx, req)
+	if err != nil {
+		return err
 	}
 
-	if err := cmd.Run(); err != nil {
+	args := []string{"-y", "-i", req.Input}
+	if coverArgs != nil {
+		args = append(args, coverArgs...)
+	} else {
+		args = append(args, "-vn")
+	}
+	args = append(args, codecArgs...)
+	args = append(args, metaArgs...)
+
+	if req.Options.MaxSize > 0 {
+		return encodeAudioToSize(ctx, req, args)
+	}
+
+	if err := runFFmpeg(ctx, req, args, req.Output); err != nil {
 		return fmt.Errorf("ffmpeg conversion failed: %w", err)
 	}
 
 	return nil
 }
+
+// audioFormat is the codec matrix entry of an audio target.
+type audioFormat struct {
+	codec string
+	muxer string
+}
+
+var audioFormats = map[string]audioFormat{
+	"mp3":  {"libmp3lame", "mp3"},
+	"wav":  {"pcm_s16le", "wav"},
+	"flac": {"flac", "flac"},
+	"ogg":  {"libvorbis", "ogg"},
+	"wma":  {"wmav2", "asf"},
+	"aac":  {"aac", "adts"},
+	"m4a":  {"aac", "ipod"},
+	"opus": {"libopus", "opus"},
+}
+
+// audioCodecArgs returns the encoder and muxer arguments of an audio output
+// format.
+func audioCodecArgs(format string) ([]string, error) {
+	target, ok := audioFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported audio format: %s", format)
+	}
+	return []string{"-acodec", target.codec, "-f", target.muxer}, nil
+}
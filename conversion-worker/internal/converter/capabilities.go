@@ -0,0 +1,342 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	FeatureHEIFDecode   = "heif_decode"
+	FeatureTIFFDecode   = "tiff_decode"
+	FeatureTIFFEncode   = "tiff_encode"
+	FeatureAVIFEncode   = "avif_encode"
+	FeatureICOEncode    = "ico_encode"
+	FeatureWebPEncode   = "webp_encode"
+	FeatureAnimatedWebP = "animated_webp_encode"
+)
+
+// avifEncoders lists the AV1 encoders usable for AVIF output, in order of preference.
+var avifEncoders = []string{"libaom-av1", "libsvtav1", "librav1e"}
+
+// av1VideoEncoders lists the AV1 encoders for video targets. SVT-AV1 comes
+// first because libaom is far too slow for full-length videos.
+var av1VideoEncoders = []string{"libsvtav1", "libaom-av1", "librav1e"}
+
+type Capabilities struct {
+	Encoders map[string]bool
+	Decoders map[string]bool
+	Muxers   map[string]bool
+	Demuxers map[string]bool
+	// Version is the release of the build, e.g. "7.1.1", or "" for
+	// development builds that name a git revision instead.
+	Version string
+}
+
+type Feature struct {
+	Name      string
+	Supported bool
+	Detail    string
+}
+
+var (
+	capsMu     sync.Mutex
+	cachedCaps *Capabilities
+)
+
+// DetectCapabilities inspects the local ffmpeg build and caches the result
+// for the lifetime of the process. Failures are not cached, so a probe cut
+// short by a cancelled context, or run before ffmpeg was reachable, is
+// retried by the next call.
+func DetectCapabilities(ctx context.Context) (*Capabilities, error) {
+	capsMu.Lock()
+	defer capsMu.Unlock()
+
+	if cachedCaps != nil {
+		return cachedCaps, nil
+	}
+	caps, err := probeCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cachedCaps = caps
+	return caps, nil
+}
+
+func probeCapabilities(ctx context.Context) (*Capabilities, error) {
+	caps := &Capabilities{}
+	lists := []struct {
+		flag   string
+		target *map[string]bool
+	}{
+		{"-encoders", &caps.Encoders},
+		{"-decoders", &caps.Decoders},
+		{"-muxers", &caps.Muxers},
+		{"-demuxers", &caps.Demuxers},
+	}
+
+	for _, list := range lists {
+		out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", list.flag).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ffmpeg %s: %w", strings.TrimPrefix(list.flag, "-"), err)
+		}
+		*list.target = parseFFmpegList(out)
+	}
+
+	out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ffmpeg version: %w", err)
+	}
+	caps.Version = parseFFmpegVersion(out)
+
+	return caps, nil
+}
+
+// parseFFmpegVersion extracts the release from the first line of
+// `ffmpeg -version`, "ffmpeg version 7.1.1-static ..." or "ffmpeg version
+// n7.1 ...". Development builds report a git revision and give "".
+func parseFFmpegVersion(out []byte) string {
+	line, _, _ := bytes.Cut(out, []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) < 3 || fields[1] != "version" {
+		return ""
+	}
+
+	version := strings.TrimPrefix(fields[2], "n")
+	end := strings.IndexFunc(version, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if end >= 0 {
+		version = version[:end]
+	}
+	if version == "" || version[0] == '.' {
+		return ""
+	}
+	return version
+}
+
+// VersionAtLeast reports whether the build is release major.minor or later.
+// Development builds are assumed to be recent.
+func (c *Capabilities) VersionAtLeast(major, minor int) bool {
+	if c.Version == "" {
+		return true
+	}
+	parts := strings.Split(c.Version, ".")
+	haveMajor, _ := strconv.Atoi(parts[0])
+	haveMinor := 0
+	if len(parts) > 1 {
+		haveMinor, _ = strconv.Atoi(parts[1])
+	}
+	return haveMajor > major || haveMajor == major && haveMinor >= minor
+}
+
+// parseFFmpegList extracts component names from the output of `ffmpeg -encoders`,
+// `-decoders`, `-muxers` or `-demuxers`. Each listing has a legend terminated by a
+// dashed separator, followed by one "<flags> <name> <description>" row per component.
+func parseFFmpegList(out []byte) map[string]bool {
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	inBody := false
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !inBody {
+			inBody = strings.HasPrefix(line, "--")
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, name := range strings.Split(fields[1], ",") {
+			names[name] = true
+		}
+	}
+
+	return names
+}
+
+func (c *Capabilities) HasEncoder(name string) bool { return c.Encoders[name] }
+func (c *Capabilities) HasDecoder(name string) bool { return c.Decoders[name] }
+func (c *Capabilities) HasMuxer(name string) bool   { return c.Muxers[name] }
+func (c *Capabilities) HasDemuxer(name string) bool { return c.Demuxers[name] }
+
+// AVIFEncoder returns the preferred AV1 encoder available for AVIF output, or an
+// empty string when none is compiled in.
+func (c *Capabilities) AVIFEncoder() string {
+	for _, name := range avifEncoders {
+		if c.HasEncoder(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// AV1Encoder returns the preferred AV1 encoder available for video output,
+// or an empty string when none is compiled in.
+func (c *Capabilities) AV1Encoder() string {
+	for _, name := range av1VideoEncoders {
+		if c.HasEncoder(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// TargetError explains why an output target cannot be produced by this
+// ffmpeg build, or returns nil when it can. Only the targets of the video
+// and audio codec matrices are checked; image targets are covered by
+// Features.
+func (c *Capabilities) TargetError(format string) error {
+	var encoders []string
+	var muxer string
+
+	if container, ok := videoContainers[format]; ok {
+		video := container.videoCodec
+		if video == av1Codec {
+			if video = c.AV1Encoder(); video == "" {
+				return fmt.Errorf("no AV1 encoder available")
+			}
+		}
+		encoders, muxer = []string{video, container.audioCodec}, container.muxer
+	} else if target, ok := audioFormats[format]; ok {
+		encoders, muxer = []string{target.codec}, target.muxer
+	} else {
+		return nil
+	}
+
+	for _, encoder := range encoders {
+		if !c.HasEncoder(encoder) {
+			return fmt.Errorf("missing %s encoder", encoder)
+		}
+	}
+	if !c.HasMuxer(muxer) {
+		return fmt.Errorf("missing %s muxer", muxer)
+	}
+	return nil
+}
+
+// DisabledTargets returns the video and audio targets this ffmpeg build
+// cannot produce, sorted, with the reason for each.
+func (c *Capabilities) DisabledTargets() []Feature {
+	formats := make([]string, 0, len(videoContainers)+len(audioFormats))
+	for format := range videoContainers {
+		formats = append(formats, format)
+	}
+	for format := range audioFormats {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	var disabled []Feature
+	for _, format := range formats {
+		if err := c.TargetError(format); err != nil {
+			disabled = append(disabled, Feature{Name: format, Detail: err.Error()})
+		}
+	}
+	return disabled
+}
+
+func (c *Capabilities) Features() []Feature {
+	avif := c.AVIFEncoder()
+
+	return []Feature{
+		{
+			Name:      FeatureHEIFDecode,
+			Supported: c.HasDecoder("hevc") && (c.HasDemuxer("heif") || c.HasDemuxer("mov") && c.VersionAtLeast(7, 1)),
+			Detail:    "hevc decoder + heif demuxer (mov demuxer of ffmpeg 7.1 or later)",
+		},
+		{
+			Name:      FeatureTIFFDecode,
+			Supported: c.HasDecoder("tiff"),
+			Detail:    "tiff decoder",
+		},
+		{
+			Name:      FeatureTIFFEncode,
+			Supported: c.HasEncoder("tiff"),
+			Detail:    "tiff encoder",
+		},
+		{
+			Name:      FeatureAVIFEncode,
+			Supported: avif != "" && c.HasMuxer("avif"),
+			Detail:    fmt.Sprintf("%s encoder + avif muxer", orNone(avif)),
+		},
+		{
+			Name:      FeatureICOEncode,
+			Supported: c.HasEncoder("png"),
+			Detail:    "png encoder (icon container is assembled by the worker)",
+		},
+		{
+			Name:      FeatureWebPEncode,
+			Supported: c.HasEncoder("libwebp") && c.HasMuxer("webp"),
+			Detail:    "libwebp encoder + webp muxer",
+		},
+		{
+			Name:      FeatureAnimatedWebP,
+			Supported: c.HasEncoder("libwebp_anim") && c.HasMuxer("webp"),
+			Detail:    "libwebp_anim encoder + webp muxer",
+		},
+	}
+}
+
+func (c *Capabilities) Supports(feature string) bool {
+	for _, f := range c.Features() {
+		if f.Name == feature {
+			return f.Supported
+		}
+	}
+	return false
+}
+
+// requireFeature fails fast with a descriptive error when the local ffmpeg build
+// lacks something a conversion needs.
+func requireFeature(ctx context.Context, feature string) error {
+	caps, err := DetectCapabilities(ctx)
+	if err != nil {
+		return err
+	}
+	if !caps.Supports(feature) {
+		return fmt.Errorf("ffmpeg build does not support %s", feature)
+	}
+	return nil
+}
+
+// CheckTarget returns an error when the local ffmpeg build cannot produce an
+// output target, so jobs for disabled targets fail before they are admitted.
+// It returns nil when the build cannot be inspected; the conversion reports
+// that instead.
+func CheckTarget(ctx context.Context, format string) error {
+	caps, err := DetectCapabilities(ctx)
+	if err != nil {
+		return nil
+	}
+	if err := caps.TargetError(format); err != nil {
+		return fmt.Errorf("target %s is disabled on this worker: %w", format, err)
+	}
+	return nil
+}
+
+// requireTarget fails fast when the local ffmpeg build cannot produce an
+// output target, instead of letting the encode fail halfway.
+func requireTarget(ctx context.Context, format string) (*Capabilities, error) {
+	caps, err := DetectCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := caps.TargetError(format); err != nil {
+		return nil, fmt.Errorf("target %s is disabled on this worker: %w", format, err)
+	}
+	return caps, nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "no AV1"
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFFmpegVersion(t *testing.T) {
+	tests := map[string]string{
+		"ffmpeg version 7.1.1 Copyright (c) 2000-2025 the FFmpeg developers\n": "7.1.1",
+		"ffmpeg version n6.0 Copyright (c) 2000-2023\n":                        "6.0",
+		"ffmpeg version 4.4.2-0ubuntu0.22.04.1 Copyright\n":                    "4.4.2",
+		"ffmpeg version N-112233-gabcdef Copyright\n":                          "",
+		"ffmpeg version git-2024-01-01 Copyright\n":                            "",
+		"not ffmpeg output": "",
+		"":                  "",
+	}
+	for out, want := range tests {
+		if got := parseFFmpegVersion([]byte(out)); got != want {
+			t.Errorf("parseFFmpegVersion(%q) = %q, want %q", out, got, want)
+		}
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version      string
+		major, minor int
+		want         bool
+	}{
+		{"7.1.1", 7, 1, true},
+		{"7.0", 7, 1, false},
+		{"8", 7, 1, true},
+		{"6.1", 7, 0, false},
+		{"", 7, 1, true},
+	}
+	for _, tt := range tests {
+		caps := &Capabilities{Version: tt.version}
+		if got := caps.VersionAtLeast(tt.major, tt.minor); got != tt.want {
+			t.Errorf("VersionAtLeast(%d, %d) on %q = %v, want %v", tt.major, tt.minor, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestParseFFmpegList(t *testing.T) {
+	out := []byte(`Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC
+ V....D libaom-av1           libaom AV1
+ A....D aac                  AAC (Advanced Audio Coding)
+`)
+	want := map[string]bool{"libx264": true, "libaom-av1": true, "aac": true}
+	if got := parseFFmpegList(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseFFmpegList(encoders) = %v, want %v", got, want)
+	}
+
+	// Muxer rows may name several formats separated by commas.
+	out = []byte(`File formats:
+ D. = Demuxing supported
+ --
+ D  mov,mp4,m4a,3gp,3g2,mj2 QuickTime / MOV
+  E webp            WebP
+`)
+	want = map[string]bool{"mov": true, "mp4": true, "m4a": true, "3gp": true, "3g2": true, "mj2": true, "webp": true}
+	if got := parseFFmpegList(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseFFmpegList(formats) = %v, want %v", got, want)
+	}
+
+	if got := parseFFmpegList([]byte("no separator\n V libx264 x264\n")); len(got) != 0 {
+		t.Errorf("parseFFmpegList without a separator = %v, want none", got)
+	}
+}
@@ -0,0 +1,258 @@
+package converter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+const (
+	defaultChunkDuration    = 300
+	defaultChunkFormat      = "mp3"
+	defaultSilenceThreshold = -35
+	defaultSilenceDuration  = 0.5
+
+	// minChunkDuration is the shortest chunk duration accepted. It also
+	// keeps the last chunk from being a sliver; a shorter remainder is added
+	// to the chunk before it.
+	minChunkDuration = 5
+	// maxChunks bounds how many chunks, each a run of ffmpeg, one job makes.
+	maxChunks = 1000
+
+	chunkManifestName = "manifest.json"
+)
+
+var (
+	silenceStartPattern = regexp.MustCompile(`silence_start: (-?[0-9.]+)`)
+	silenceEndPattern   = regexp.MustCompile(`silence_end: (-?[0-9.]+)`)
+)
+
+type chunkSpan struct {
+	start float64
+	end   float64
+}
+
+type chunkManifest struct {
+	Mode     string       `json:"mode"`
+	Format   string       `json:"format"`
+	Duration float64      `json:"duration"`
+	Overlap  float64      `json:"overlap"`
+	Chunks   []chunkEntry `json:"chunks"`
+}
+
+type chunkEntry struct {
+	Index    int     `json:"index"`
+	File     string  `json:"file"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Duration float64 `json:"duration"`
+}
+
+// convertToChunks cuts the first audio track into numbered files and writes
+// them to a ZIP with a manifest of each chunk's start and end time.
+func (c *AudioConverter) convertToChunks(ctx context.Context, req Request) error {
+	opts := models.ChunkOptions{}
+	if req.Options.Chunks != nil {
+		opts = *req.Options.Chunks
+	}
+	if err := validateChunkOptions(&opts); err != nil {
+		return err
+	}
+
+	if _, err := requireTarget(ctx, opts.Format); err != nil {
+		return err
+	}
+	codecArgs, err := audioCodecArgs(opts.Format)
+	if err != nil {
+		return err
+	}
+
+	probe, err := Probe(ctx, req.Input)
+	if err != nil {
+		return err
+	}
+	if !probe.HasStream("audio") {
+		return fmt.Errorf("input has no audio stream")
+	}
+	total := probe.Duration()
+	if total <= 0 {
+		return fmt.Errorf("cannot split audio: input duration is unknown")
+	}
+
+	var silences []float64
+	if opts.Mode == "silence" {
+		if silences, err = detectSilences(ctx, req.Input, opts.SilenceThreshold, opts.SilenceDuration); err != nil {
+			return err
+		}
+	}
+	spans, err := planChunks(total, opts.Duration, opts.Overlap, silences)
+	if err != nil {
+		return err
+	}
+
+	archive, err := createZipArchive(req, req.Output)
+	if err != nil {
+		return err
+	}
+
+	manifest := chunkManifest{Mode: opts.Mode, Format: opts.Format, Duration: total, Overlap: opts.Overlap}
+	for i, span := range spans {
+		name := fmt.Sprintf("chunk_%03d.%s", i+1, opts.Format)
+		var entry io.Writer
+		if entry, err = archive.create(name); err != nil {
+			break
+		}
+		if err = encodeChunk(ctx, req.Input, entry, span, codecArgs, req.Output+".chunk"); err != nil {
+			break
+		}
+
+		manifest.Chunks = append(manifest.Chunks, chunkEntry{
+			Index:    i + 1,
+			File:     name,
+			Start:    span.start,
+			End:      span.end,
+			Duration: span.end - span.start,
+		})
+	}
+
+	if err == nil {
+		var content []byte
+		if content, err = json.MarshalIndent(manifest, "", "  "); err == nil {
+			err = archive.add(chunkManifestName, content)
+		}
+	}
+
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// encodeChunk encodes a span of the input straight into its archive entry,
+// so chunks never sit in the workspace on their own, except in formats whose
+// muxer seeks, which go through scratch.
+func encodeChunk(ctx context.Context, input string, entry io.Writer, span chunkSpan, codecArgs []string, scratch string) error {
+	args := []string{"-y",
+		"-ss", fmt.Sprintf("%.3f", span.start), "-t", fmt.Sprintf("%.3f", span.end-span.start),
+		"-i", input, "-map", "0:a:0", "-vn"}
+	args = append(args, codecArgs...)
+
+	if err := pipeFFmpeg(ctx, args, entry, scratch); err != nil {
+		return fmt.Errorf("failed to encode chunk at %.3fs: %w", span.start, err)
+	}
+	return nil
+}
+
+// planChunks splits total seconds into chunks of at most size seconds. With
+// silences, each chunk ends at the last silence in the second half of its
+// window, so words are not cut in two; the next chunk starts overlap seconds
+// before the previous one ends. It fails rather than plan more than
+// maxChunks chunks.
+func planChunks(total, size, overlap float64, silences []float64) ([]chunkSpan, error) {
+	var spans []chunkSpan
+	start := 0.0
+
+	for {
+		end := start + size
+		if end >= total-minChunkDuration {
+			return append(spans, chunkSpan{start, total}), nil
+		}
+		if len(spans) == maxChunks-1 {
+			return nil, fmt.Errorf("input of %.0fs would be split into more than %d chunks; use a longer chunk duration", total, maxChunks)
+		}
+
+		for i := len(silences) - 1; i >= 0; i-- {
+			if silences[i] <= end && silences[i] >= start+size/2 {
+				end = silences[i]
+				break
+			}
+		}
+
+		spans = append(spans, chunkSpan{start, end})
+		start = end - overlap
+	}
+}
+
+// detectSilences runs ffmpeg's silencedetect over the first audio track and
+// returns the midpoint of every silence, in order.
+func detectSilences(ctx context.Context, input string, threshold, minDuration float64) ([]float64, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-nostats", "-i", input,
+		"-map", "0:a:0", "-af", fmt.Sprintf("silencedetect=noise=%gdB:d=%g", threshold, minDuration),
+		"-f", "null", "-")
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ffmpeg output: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start silence detection: %w", err)
+	}
+
+	var silences []float64
+	silenceStart := -1.0
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := silenceStartPattern.FindStringSubmatch(line); m != nil {
+			silenceStart, _ = strconv.ParseFloat(m[1], 64)
+		} else if m := silenceEndPattern.FindStringSubmatch(line); m != nil && silenceStart >= 0 {
+			end, _ := strconv.ParseFloat(m[1], 64)
+			silences = append(silences, (max(silenceStart, 0)+end)/2)
+			silenceStart = -1
+		}
+	}
+	io.Copy(io.Discard, stderr)
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("silence detection failed: %w", err)
+	}
+	return silences, nil
+}
+
+// validateChunkOptions checks the options and fills in the defaults.
+func validateChunkOptions(opts *models.ChunkOptions) error {
+	switch opts.Mode {
+	case "":
+		opts.Mode = "fixed"
+	case "fixed", "silence":
+	default:
+		return fmt.Errorf("unsupported chunk mode: %s", opts.Mode)
+	}
+
+	if opts.Duration < 0 || opts.Overlap < 0 || opts.SilenceDuration < 0 {
+		return fmt.Errorf("chunk options cannot be negative")
+	}
+	if opts.Duration == 0 {
+		opts.Duration = defaultChunkDuration
+	}
+	if opts.Duration < minChunkDuration {
+		return fmt.Errorf("chunk duration must be at least %d seconds", minChunkDuration)
+	}
+	if opts.Overlap >= opts.Duration/2 {
+		return fmt.Errorf("chunk overlap must be shorter than half the chunk duration")
+	}
+
+	if opts.Format == "" {
+		opts.Format = defaultChunkFormat
+	}
+	if _, ok := audioFormats[opts.Format]; !ok {
+		return fmt.Errorf("unsupported chunk format: %s", opts.Format)
+	}
+
+	if opts.SilenceThreshold > 0 {
+		return fmt.Errorf("silence threshold is in dB and cannot be positive")
+	}
+	if opts.SilenceThreshold == 0 {
+		opts.SilenceThreshold = defaultSilenceThreshold
+	}
+	if opts.SilenceDuration == 0 {
+		opts.SilenceDuration = defaultSilenceDuration
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanChunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    float64
+		size     float64
+		overlap  float64
+		silences []float64
+		want     []chunkSpan
+	}{
+		{
+			name: "shorter than one chunk", total: 8, size: 10,
+			want: []chunkSpan{{0, 8}},
+		},
+		{
+			name: "short tail joins the last chunk", total: 25, size: 10,
+			want: []chunkSpan{{0, 10}, {10, 25}},
+		},
+		{
+			name: "overlap", total: 30, size: 10, overlap: 1,
+			want: []chunkSpan{{0, 10}, {9, 19}, {18, 30}},
+		},
+		{
+			name: "ends at silences", total: 30, size: 10, silences: []float64{3, 7, 9.5, 12, 16},
+			want: []chunkSpan{{0, 9.5}, {9.5, 16}, {16, 30}},
+		},
+		{
+			name: "ignores silences in the first half", total: 30, size: 10, silences: []float64{2, 13},
+			want: []chunkSpan{{0, 10}, {10, 20}, {20, 30}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planChunks(tt.total, tt.size, tt.overlap, tt.silences)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planChunks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanChunksLimit(t *testing.T) {
+	// The last chunk takes a tail of up to minChunkDuration seconds.
+	total := float64((maxChunks + 1) * minChunkDuration)
+	spans, err := planChunks(total, minChunkDuration, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != maxChunks {
+		t.Errorf("planChunks made %d chunks, want %d", len(spans), maxChunks)
+	}
+
+	if _, err := planChunks(total+1, minChunkDuration, 0, nil); err == nil {
+		t.Errorf("planChunks made more than %d chunks", maxChunks)
+	}
+}
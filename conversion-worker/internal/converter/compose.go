@@ -0,0 +1,367 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+const (
+	defaultSlideDuration   = 3
+	defaultSlideshowWidth  = 1280
+	defaultSlideshowHeight = 720
+	defaultSlideshowFPS    = 25
+	defaultComposeFPS      = 30
+)
+
+// ComposeConverter handles jobs with several inputs: joining clips one after
+// the other, mixing audio files, replacing the audio track of a video and
+// building a slideshow from images.
+type ComposeConverter struct {
+	BaseConverter
+}
+
+func (c *ComposeConverter) SupportedFormats() []string {
+	return []string{"mp4", "avi", "mkv", "mov", "flv", "wmv", "webm", "ogv", "mp4-hevc", "mp4-av1",
+		"mp3", "wav", "flac", "ogg", "wma", "aac", "m4a", "opus"}
+}
+
+// composeInput is an input together with what ffprobe found in it.
+type composeInput struct {
+	models.InputFile
+	probe *ProbeResult
+}
+
+func (c *ComposeConverter) Convert(ctx context.Context, req Request) error {
+	if err := c.validatePaths(req.Input, req.Output); err != nil {
+		return err
+	}
+	if req.Options.Compose == nil {
+		return fmt.Errorf("compose options are required for multi-input jobs")
+	}
+	mode := req.Options.Compose.Mode
+	if unsupported := unsupportedComposeOptions(req.Options); len(unsupported) > 0 {
+		return fmt.Errorf("%s does not support %s", mode, strings.Join(unsupported, ", "))
+	}
+
+	if len(req.Inputs) < 2 {
+		return fmt.Errorf("%s needs at least two inputs", mode)
+	}
+
+	inputs := make([]composeInput, len(req.Inputs))
+	for i, file := range req.Inputs {
+		if file.Path == "" {
+			return fmt.Errorf("input %d has no path", i+1)
+		}
+		probe, err := Probe(ctx, file.Path)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", i+1, err)
+		}
+		inputs[i] = composeInput{InputFile: file, probe: probe}
+	}
+
+	var args []string
+	var err error
+
+	switch mode {
+	case models.ComposeConcat:
+		args, err = concatArgs(ctx, req, inputs)
+	case models.ComposeMergeAudio:
+		args, err = mergeAudioArgs(ctx, req, inputs)
+	case models.ComposeReplaceAudio:
+		args, err = replaceAudioArgs(ctx, req, inputs)
+	case models.ComposeSlideshow:
+		args, err = slideshowArgs(ctx, req, inputs)
+	default:
+		return fmt.Errorf("unsupported compose mode: %s", mode)
+	}
+	if err != nil {
+		return err
+	}
+
+	metaArgs, err := metadataArgs(ctx, req)
+	if err != nil {
+		return err
+	}
+	args = append(args, metaArgs...)
+
+	if err := runFFmpeg(ctx, req, args, req.Output); err != nil {
+		return fmt.Errorf("failed to compose inputs: %w", err)
+	}
+
+	return nil
+}
+
+// unsupportedComposeOptions lists the options set on a compose job that it
+// would otherwise ignore. Only metadata applies to the composed output.
+func unsupportedComposeOptions(opts models.JobOptions) []string {
+	var unsupported []string
+	if opts.Transform != nil {
+		unsupported = append(unsupported, "transform")
+	}
+	if opts.Watermark != nil {
+		unsupported = append(unsupported, "watermark")
+	}
+	if len(opts.TextOverlays) > 0 {
+		unsupported = append(unsupported, "text_overlays")
+	}
+	if opts.BurnSubtitles != nil {
+		unsupported = append(unsupported, "burn_subtitles")
+	}
+	if opts.MaxSize > 0 {
+		unsupported = append(unsupported, "max_size")
+	}
+	return unsupported
+}
+
+// concatArgs joins the inputs one after the other. Video inputs are scaled
+// and padded to a common size and frame rate, and audio is resampled to a
+// common layout, so clips from different sources can be joined.
+func concatArgs(ctx context.Context, req Request, inputs []composeInput) ([]string, error) {
+	video := isVideoTarget(req.Format)
+
+	withAudio := 0
+	for i, input := range inputs {
+		if video && input.probe.FirstVideo() == nil {
+			return nil, fmt.Errorf("input %d has no video stream", i+1)
+		}
+		if input.probe.HasStream("audio") {
+			withAudio++
+		} else if !video {
+			return nil, fmt.Errorf("input %d has no audio stream", i+1)
+		}
+	}
+	if withAudio != 0 && withAudio != len(inputs) {
+		return nil, fmt.Errorf("cannot concat inputs with and without audio: %d of %d inputs have an audio stream", withAudio, len(inputs))
+	}
+	hasAudio := withAudio > 0
+
+	args := []string{"-y"}
+	for _, input := range inputs {
+		args = append(args, "-i", input.Path)
+	}
+
+	var chains []string
+	var segments strings.Builder
+
+	if video {
+		first := inputs[0].probe.FirstVideo()
+		firstWidth, firstHeight := first.DisplaySize()
+		width, height, fps := composeSize(req.Options.Compose, firstWidth, firstHeight, first.FPS())
+
+		for i, input := range inputs {
+			chains = append(chains, fmt.Sprintf("[%d:%d]%s[v%d]", i, input.probe.FirstVideo().Index, fitFrame(width, height, fps), i))
+		}
+	}
+	if hasAudio {
+		for i := range inputs {
+			chains = append(chains, fmt.Sprintf("[%d:a:0]%s[a%d]", i, commonAudio, i))
+		}
+	}
+
+	for i := range inputs {
+		if video {
+			fmt.Fprintf(&segments, "[v%d]", i)
+		}
+		if hasAudio {
+			fmt.Fprintf(&segments, "[a%d]", i)
+		}
+	}
+
+	concat := fmt.Sprintf("%sconcat=n=%d:v=%d:a=%d", segments.String(), len(inputs), boolInt(video), boolInt(hasAudio))
+	var maps []string
+	if video {
+		concat += "[v]"
+		maps = append(maps, "-map", "[v]")
+	}
+	if hasAudio {
+		concat += "[a]"
+		maps = append(maps, "-map", "[a]")
+	}
+	chains = append(chains, concat)
+
+	codecArgs, err := composeCodecArgs(ctx, req.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, "-filter_complex", strings.Join(chains, ";"))
+	args = append(args, maps...)
+	return append(args, codecArgs...), nil
+}
+
+// mergeAudioArgs mixes the audio of every input into a single track that
+// lasts as long as the longest input.
+func mergeAudioArgs(ctx context.Context, req Request, inputs []composeInput) ([]string, error) {
+	if isVideoTarget(req.Format) {
+		return nil, fmt.Errorf("merge_audio produces audio, not %s", req.Format)
+	}
+
+	args := []string{"-y"}
+	var mix strings.Builder
+	for i, input := range inputs {
+		if !input.probe.HasStream("audio") {
+			return nil, fmt.Errorf("input %d has no audio stream", i+1)
+		}
+		args = append(args, "-i", input.Path)
+		fmt.Fprintf(&mix, "[%d:a:0]", i)
+	}
+	fmt.Fprintf(&mix, "amix=inputs=%d:duration=longest:dropout_transition=0[a]", len(inputs))
+
+	codecArgs, err := composeCodecArgs(ctx, req.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, "-filter_complex", mix.String(), "-map", "[a]")
+	return append(args, codecArgs...), nil
+}
+
+// replaceAudioArgs keeps the video of the first input and takes the audio of
+// the second, ending with the shorter of the two.
+func replaceAudioArgs(ctx context.Context, req Request, inputs []composeInput) ([]string, error) {
+	if !isVideoTarget(req.Format) {
+		return nil, fmt.Errorf("replace_audio produces video, not %s", req.Format)
+	}
+	if len(inputs) != 2 {
+		return nil, fmt.Errorf("replace_audio needs exactly two inputs: a video and an audio file")
+	}
+
+	video := inputs[0].probe.FirstVideo()
+	if video == nil {
+		return nil, fmt.Errorf("input 1 has no video stream")
+	}
+	if !inputs[1].probe.HasStream("audio") {
+		return nil, fmt.Errorf("input 2 has no audio stream")
+	}
+
+	codecArgs, err := composeCodecArgs(ctx, req.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-y", "-i", inputs[0].Path, "-i", inputs[1].Path,
+		"-map", fmt.Sprintf("0:%d", video.Index), "-map", "1:a:0", "-shortest"}
+	return append(args, codecArgs...), nil
+}
+
+// slideshowArgs shows every image for its duration. An input with audio and
+// no video is used as the soundtrack, padded with silence or cut to the
+// length of the slideshow.
+func slideshowArgs(ctx context.Context, req Request, inputs []composeInput) ([]string, error) {
+	if !isVideoTarget(req.Format) {
+		return nil, fmt.Errorf("slideshow produces video, not %s", req.Format)
+	}
+
+	width, height, fps := composeSize(req.Options.Compose, defaultSlideshowWidth, defaultSlideshowHeight, defaultSlideshowFPS)
+
+	args := []string{"-y"}
+	var chains []string
+	var slides strings.Builder
+	soundtrack := -1
+	count := 0
+
+	for i, input := range inputs {
+		if input.probe.FirstVideo() == nil {
+			if !input.probe.HasStream("audio") {
+				return nil, fmt.Errorf("input %d is neither an image nor audio", i+1)
+			}
+			if soundtrack >= 0 {
+				return nil, fmt.Errorf("slideshow accepts a single soundtrack, found another in input %d", i+1)
+			}
+			soundtrack = i
+			args = append(args, "-i", input.Path)
+			continue
+		}
+
+		if input.Duration < 0 {
+			return nil, fmt.Errorf("input %d: duration cannot be negative", i+1)
+		}
+		duration := input.Duration
+		if duration == 0 {
+			duration = defaultSlideDuration
+		}
+
+		args = append(args, "-loop", "1", "-t", fmt.Sprintf("%.3f", duration), "-i", input.Path)
+		chains = append(chains, fmt.Sprintf("[%d:%d]%s[v%d]", i, input.probe.FirstVideo().Index, fitFrame(width, height, fps), i))
+		fmt.Fprintf(&slides, "[v%d]", i)
+		count++
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("slideshow needs at least one image")
+	}
+
+	chains = append(chains, fmt.Sprintf("%sconcat=n=%d:v=1:a=0[v]", slides.String(), count))
+	maps := []string{"-map", "[v]"}
+	if soundtrack >= 0 {
+		chains = append(chains, fmt.Sprintf("[%d:a:0]apad[a]", soundtrack))
+		maps = append(maps, "-map", "[a]", "-shortest")
+	}
+
+	codecArgs, err := composeCodecArgs(ctx, req.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, "-filter_complex", strings.Join(chains, ";"))
+	args = append(args, maps...)
+	return append(args, codecArgs...), nil
+}
+
+// commonAudio brings audio streams to the same sample rate and layout so
+// they can be concatenated.
+const commonAudio = "aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo"
+
+// fitFrame scales a video stream into a width x height frame, keeping its
+// aspect ratio with letterboxing, at a fixed frame rate.
+func fitFrame(width, height int, fps float64) string {
+	return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%g,format=yuv420p",
+		width, height, width, height, fps)
+}
+
+// composeSize applies the requested output size and frame rate over the
+// given defaults. Dimensions are rounded down to even numbers, as required
+// by yuv420p.
+func composeSize(opts *models.ComposeOptions, width, height int, fps float64) (int, int, float64) {
+	if opts.Width > 0 {
+		width = opts.Width
+	}
+	if opts.Height > 0 {
+		height = opts.Height
+	}
+	if opts.FPS > 0 {
+		fps = opts.FPS
+	}
+	if fps <= 0 {
+		fps = defaultComposeFPS
+	}
+	return max(width/2*2, 2), max(height/2*2, 2), fps
+}
+
+func composeCodecArgs(ctx context.Context, format string) ([]string, error) {
+	if isVideoTarget(format) {
+		container, err := resolveContainer(ctx, format)
+		if err != nil {
+			return nil, err
+		}
+		return container.codecArgs(true), nil
+	}
+	if _, err := requireTarget(ctx, format); err != nil {
+		return nil, err
+	}
+	return audioCodecArgs(format)
+}
+
+func isVideoTarget(format string) bool {
+	_, ok := videoContainers[format]
+	return ok
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
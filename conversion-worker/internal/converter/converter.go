@@ -3,31 +3,76 @@ package converter
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
 )
 
 type Converter interface {
-	Convert(ctx context.Context, input, format, output string) error
+	Convert(ctx context.Context, req Request) error
 	SupportedFormats() []string
 }
 
+// Request describes a single conversion: where to read, what to produce and
+// where to write it. Converters record how they carried it out in Metadata.
+// Scratch files go in Workspace, which the caller removes after the job; an
+// empty Workspace means the system temp directory. With a Sealer the output
+// is encrypted as it is written.
+type Request struct {
+	Input     string
+	Inputs    []models.InputFile
+	Format    string
+	Output    string
+	Options   models.JobOptions
+	Metadata  Metadata
+	Workspace string
+	Sealer    Sealer
+}
+
+// Metadata collects facts about a conversion, such as the encoding path
+// taken, which the worker stores with the task.
+type Metadata map[string]any
+
+// Set records a value. It is a no-op on a nil Metadata, so converters don't
+// need to check whether the caller asked for it.
+func (m Metadata) Set(key string, value any) {
+	if m != nil {
+		m[key] = value
+	}
+}
+
 type Registry struct {
 	converters map[string]Converter
+	composer   Converter
 }
 
 func NewRegistry() Registry {
 	registry := Registry{
 		converters: make(map[string]Converter),
+		composer:   &ComposeConverter{},
 	}
 
 	registry.converters["image"] = &ImageConverter{}
 	registry.converters["audio"] = &AudioConverter{}
 	registry.converters["video"] = &VideoConverter{}
 
+	subtitles := &SubtitleConverter{}
+	registry.converters["text"] = subtitles
+	registry.converters["application/x-subrip"] = subtitles
+	registry.converters["application/x-ass"] = subtitles
+
 	return registry
 }
 
+// GetConverter looks the converter up by the full mimetype first, for types
+// such as "application/x-subrip" whose top-level type is too generic, and then
+// by media type.
 func (r *Registry) GetConverter(mimetype string) (Converter, error) {
+	if converter, exists := r.converters[mimetype]; exists {
+		return converter, nil
+	}
+
 	mediaType := strings.Split(mimetype, "/")[0]
 
 	converter, exists := r.converters[mediaType]
@@ -38,6 +83,15 @@ func (r *Registry) GetConverter(mimetype string) (Converter, error) {
 	return converter, nil
 }
 
+// ConverterFor returns the converter of a job: multi-input jobs are handled
+// by the composer, everything else by the converter of its mimetype.
+func (r *Registry) ConverterFor(job *models.JobData) (Converter, error) {
+	if job.Options.Compose != nil {
+		return r.composer, nil
+	}
+	return r.GetConverter(job.Mimetype)
+}
+
 func (r *Registry) ListSupportedTypes() []string {
 	types := make([]string, 0, len(r.converters))
 	for mediaType := range r.converters {
@@ -46,6 +100,47 @@ func (r *Registry) ListSupportedTypes() []string {
 	return types
 }
 
+// SupportedFormats returns every target format some converter produces.
+func (r *Registry) SupportedFormats() []string {
+	converters := []Converter{r.composer}
+	for _, converter := range r.converters {
+		converters = append(converters, converter)
+	}
+
+	seen := make(map[string]bool)
+	var formats []string
+	for _, converter := range converters {
+		for _, format := range converter.SupportedFormats() {
+			if !seen[format] {
+				seen[format] = true
+				formats = append(formats, format)
+			}
+		}
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// OutputExtension returns the file extension of the output produced for a
+// target format.
+func OutputExtension(format string, opts models.JobOptions) string {
+	switch format {
+	case "images", "sprites", "chunks":
+		return "zip"
+	case "subtitles":
+		return SubtitleFormat(opts.Subtitles)
+	case "contactsheet":
+		return ContactSheetFormat(opts.ContactSheet)
+	case "waveform":
+		return WaveformFormat(opts.Waveform)
+	case "spectrogram":
+		return "png"
+	case "mp4-hevc", "mp4-av1":
+		return "mp4"
+	}
+	return format
+}
+
 type BaseConverter struct {
 	name string
 }
@@ -0,0 +1,187 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// filterGraph builds an ffmpeg -filter_complex graph on top of the video
+// stream of the primary input (input 0). Filters are chained one after the
+// other, each consuming the label produced by the previous one, and extra
+// inputs such as watermark images are numbered from 1. Audio filters form a
+// separate chain on the first audio stream of the primary input.
+type filterGraph struct {
+	inputs   []string
+	chains   []string
+	video    string
+	audio    string
+	counter  int
+	tempRoot string
+	tempDir  string
+}
+
+// newFilterGraph starts a graph on the first video stream. Auxiliary files
+// are written below tempRoot.
+func newFilterGraph(tempRoot string) *filterGraph {
+	return &filterGraph{video: "0:v", tempRoot: tempRoot}
+}
+
+// addInput registers an extra input file and returns its stream label.
+func (g *filterGraph) addInput(path string) string {
+	g.inputs = append(g.inputs, path)
+	return fmt.Sprintf("%d:v", len(g.inputs))
+}
+
+func (g *filterGraph) nextLabel(prefix string) string {
+	g.counter++
+	return fmt.Sprintf("%s%d", prefix, g.counter)
+}
+
+// apply appends a filter to the main video chain.
+func (g *filterGraph) apply(filter string) {
+	out := g.nextLabel("v")
+	g.chains = append(g.chains, fmt.Sprintf("[%s]%s[%s]", g.video, filter, out))
+	g.video = out
+}
+
+// applyAudio appends a filter to the audio chain.
+func (g *filterGraph) applyAudio(filter string) {
+	in := g.audio
+	if in == "" {
+		in = "0:a:0"
+	}
+	out := g.nextLabel("a")
+	g.chains = append(g.chains, fmt.Sprintf("[%s]%s[%s]", in, filter, out))
+	g.audio = out
+}
+
+// applyWith appends a filter that takes the main video and one side stream,
+// such as overlay.
+func (g *filterGraph) applyWith(side, filter string) {
+	out := g.nextLabel("v")
+	g.chains = append(g.chains, fmt.Sprintf("[%s][%s]%s[%s]", g.video, side, filter, out))
+	g.video = out
+}
+
+// prepare runs a filter on a side stream and returns the label of its output.
+func (g *filterGraph) prepare(in, filter string) string {
+	out := g.nextLabel("s")
+	g.chains = append(g.chains, fmt.Sprintf("[%s]%s[%s]", in, filter, out))
+	return out
+}
+
+// prepareWith runs a filter that takes a side stream and the main video and
+// outputs both, such as scale2ref, and returns the label of the side
+// stream's output. The main video is passed through unchanged.
+func (g *filterGraph) prepareWith(side, filter string) string {
+	out := g.nextLabel("s")
+	video := g.nextLabel("v")
+	g.chains = append(g.chains, fmt.Sprintf("[%s][%s]%s[%s][%s]", side, g.video, filter, out, video))
+	g.video = video
+	return out
+}
+
+func (g *filterGraph) empty() bool {
+	return len(g.chains) == 0
+}
+
+// writeTempFile stores auxiliary filter input, such as drawtext text files,
+// in a directory removed by cleanup.
+func (g *filterGraph) writeTempFile(name, content string) (string, error) {
+	if g.tempDir == "" {
+		dir, err := os.MkdirTemp(g.tempRoot, "filters_*")
+		if err != nil {
+			return "", fmt.Errorf("failed to create filter temp directory: %w", err)
+		}
+		g.tempDir = dir
+	}
+
+	path := filepath.Join(g.tempDir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write filter file: %w", err)
+	}
+	return path, nil
+}
+
+func (g *filterGraph) cleanup() {
+	if g.tempDir != "" {
+		os.RemoveAll(g.tempDir)
+	}
+}
+
+func (g *filterGraph) inputArgs() []string {
+	var args []string
+	for _, input := range g.inputs {
+		args = append(args, "-i", input)
+	}
+	return args
+}
+
+// outputArgs maps the graph output. With keepAudio the filtered audio is
+// mapped, or, without audio filters, the audio streams of the primary input
+// are carried over untouched, if there are any.
+func (g *filterGraph) outputArgs(keepAudio bool) []string {
+	if g.empty() {
+		return nil
+	}
+
+	args := []string{"-filter_complex", strings.Join(g.chains, ";")}
+	if g.video == "0:v" {
+		args = append(args, "-map", "0:v")
+	} else {
+		args = append(args, "-map", "["+g.video+"]")
+	}
+
+	switch {
+	case !keepAudio:
+	case g.audio != "":
+		args = append(args, "-map", "["+g.audio+"]")
+	default:
+		args = append(args, "-map", "0:a?")
+	}
+	return args
+}
+
+// filterValue escapes a filter option value for use inside a filtergraph
+// description. ffmpeg parses option values and the graph itself separately,
+// so special characters are escaped once for each level.
+func filterValue(value string) string {
+	optionLevel := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(optionLevel)
+}
+
+// positionExpr returns x/y expressions that place an element of size
+// (elemW, elemH) inside a frame of size (frameW, frameH) at one of nine
+// anchor points, keeping margin pixels away from the edges.
+func positionExpr(position string, margin int, frameW, frameH, elemW, elemH string) (string, string, error) {
+	left := fmt.Sprintf("%d", margin)
+	centerX := fmt.Sprintf("(%s-%s)/2", frameW, elemW)
+	right := fmt.Sprintf("%s-%s-%d", frameW, elemW, margin)
+	top := fmt.Sprintf("%d", margin)
+	centerY := fmt.Sprintf("(%s-%s)/2", frameH, elemH)
+	bottom := fmt.Sprintf("%s-%s-%d", frameH, elemH, margin)
+
+	switch position {
+	case "top-left":
+		return left, top, nil
+	case "top":
+		return centerX, top, nil
+	case "top-right":
+		return right, top, nil
+	case "left":
+		return left, centerY, nil
+	case "center":
+		return centerX, centerY, nil
+	case "right":
+		return right, centerY, nil
+	case "bottom-left":
+		return left, bottom, nil
+	case "bottom":
+		return centerX, bottom, nil
+	case "", "bottom-right":
+		return right, bottom, nil
+	}
+	return "", "", fmt.Errorf("unsupported position: %s", position)
+}
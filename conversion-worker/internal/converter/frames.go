@@ -0,0 +1,243 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+// frameEncoders maps the supported frame formats to their ffmpeg encoder and
+// the pixel format they are written in.
+var frameEncoders = map[string]struct{ codec, pixFmt string }{
+	"png":  {"png", "rgb24"},
+	"jpg":  {"mjpeg", "yuvj420p"},
+	"webp": {"libwebp", "yuv420p"},
+}
+
+// convertToFrames extracts frames from the video and streams them into a ZIP
+// as ffmpeg produces them, so no frame ever touches the disk on its own.
+func (c *VideoConverter) convertToFrames(ctx context.Context, req Request) error {
+	opts := models.FrameOptions{}
+	if req.Options.Frames != nil {
+		opts = *req.Options.Frames
+	}
+
+	format, err := frameFormat(opts)
+	if err != nil {
+		return err
+	}
+	if err := validateFrameOptions(opts); err != nil {
+		return err
+	}
+
+	archive, err := createZipArchive(req, req.Output)
+	if err != nil {
+		return err
+	}
+
+	if len(opts.Timestamps) > 0 {
+		err = c.extractFramesAt(ctx, req.Input, format, opts, archive)
+	} else {
+		err = c.extractFrameStream(ctx, req.Input, format, opts, archive)
+	}
+
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *VideoConverter) extractFrameStream(ctx context.Context, input, format string, opts models.FrameOptions, archive *zipArchive) error {
+	var filters []string
+	var outputArgs []string
+
+	switch {
+	case opts.FPS > 0:
+		filters = append(filters, fmt.Sprintf("fps=%g", opts.FPS))
+	case opts.SceneThreshold > 0:
+		filters = append(filters, "select="+filterValue(fmt.Sprintf("gt(scene,%g)", opts.SceneThreshold)))
+		outputArgs = append(outputArgs, "-fps_mode", "vfr")
+	}
+	if scale := frameScale(opts); scale != "" {
+		filters = append(filters, scale)
+	}
+	if opts.MaxFrames > 0 {
+		outputArgs = append(outputArgs, "-frames:v", fmt.Sprint(opts.MaxFrames))
+	}
+
+	args := []string{"-y", "-i", input, "-an", "-sn"}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	args = append(args, outputArgs...)
+	args = append(args, frameEncodeArgs(format, opts.Quality)...)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(args, "pipe:1")...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open ffmpeg output: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start frame extraction: %w", err)
+	}
+
+	stream := newImageStream(stdout, format)
+	count := 0
+	var streamErr error
+
+	for {
+		frame, err := stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			streamErr = err
+			break
+		}
+
+		count++
+		if err := archive.add(fmt.Sprintf("frame_%04d.%s", count, format), frame); err != nil {
+			streamErr = err
+			break
+		}
+	}
+
+	if streamErr != nil {
+		cancel()
+		cmd.Wait()
+		return streamErr
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to extract frames: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("no frames were extracted")
+	}
+
+	return nil
+}
+
+// extractFramesAt grabs one frame per requested timestamp. Seeking before the
+// input makes each extraction jump straight to its keyframe.
+func (c *VideoConverter) extractFramesAt(ctx context.Context, input, format string, opts models.FrameOptions, archive *zipArchive) error {
+	timestamps := opts.Timestamps
+	if opts.MaxFrames > 0 && len(timestamps) > opts.MaxFrames {
+		timestamps = timestamps[:opts.MaxFrames]
+	}
+
+	for i, ts := range timestamps {
+		args := []string{"-y", "-ss", fmt.Sprintf("%.3f", ts), "-i", input, "-an", "-sn", "-frames:v", "1"}
+		if scale := frameScale(opts); scale != "" {
+			args = append(args, "-vf", scale)
+		}
+		args = append(args, frameEncodeArgs(format, opts.Quality)...)
+
+		cmd := exec.CommandContext(ctx, "ffmpeg", append(args, "pipe:1")...)
+		frame, err := cmd.Output()
+		if err != nil {
+			return fmt.Errorf("failed to extract frame at %.3fs: %w", ts, err)
+		}
+		if len(frame) == 0 {
+			return fmt.Errorf("no frame at %.3fs (beyond the end of the video?)", ts)
+		}
+
+		if err := archive.add(fmt.Sprintf("frame_%04d.%s", i+1, format), frame); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func frameFormat(opts models.FrameOptions) (string, error) {
+	format := strings.ToLower(opts.Format)
+	switch format {
+	case "":
+		return "png", nil
+	case "jpeg":
+		format = "jpg"
+	}
+	if _, ok := frameEncoders[format]; !ok {
+		return "", fmt.Errorf("unsupported frame format: %s", opts.Format)
+	}
+	return format, nil
+}
+
+func validateFrameOptions(opts models.FrameOptions) error {
+	modes := 0
+	if opts.FPS > 0 {
+		modes++
+	}
+	if len(opts.Timestamps) > 0 {
+		modes++
+	}
+	if opts.SceneThreshold > 0 {
+		modes++
+	}
+	if modes > 1 {
+		return fmt.Errorf("fps, timestamps and scene_threshold are mutually exclusive")
+	}
+
+	if opts.FPS < 0 || opts.MaxFrames < 0 || opts.Width < 0 || opts.Height < 0 {
+		return fmt.Errorf("frame options cannot be negative")
+	}
+	if opts.SceneThreshold < 0 || opts.SceneThreshold > 1 {
+		return fmt.Errorf("scene_threshold must be between 0 and 1")
+	}
+	if opts.Quality < 0 || opts.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100, or 0 for the default")
+	}
+	for _, ts := range opts.Timestamps {
+		if ts < 0 {
+			return fmt.Errorf("timestamps cannot be negative")
+		}
+	}
+	return nil
+}
+
+// frameScale returns the resize filter. A missing dimension follows the
+// aspect ratio, rounded to an even number for the chroma subsampled formats.
+func frameScale(opts models.FrameOptions) string {
+	if opts.Width == 0 && opts.Height == 0 {
+		return ""
+	}
+	width, height := opts.Width, opts.Height
+	if width == 0 {
+		width = -2
+	}
+	if height == 0 {
+		height = -2
+	}
+	return fmt.Sprintf("scale=%d:%d:flags=lanczos", width, height)
+}
+
+// frameEncodeArgs selects the encoder and maps quality (1-100, higher is
+// better) to the encoder's own scale. PNG is lossless and ignores it.
+func frameEncodeArgs(format string, quality int) []string {
+	encoder := frameEncoders[format]
+	args := []string{"-c:v", encoder.codec, "-pix_fmt", encoder.pixFmt}
+
+	switch format {
+	case "jpg":
+		if quality == 0 {
+			quality = 90
+		}
+		// mjpeg's qscale goes from 2 (best) to 31 (worst).
+		args = append(args, "-q:v", fmt.Sprint(2+(100-quality)*29/99))
+	case "webp":
+		if quality == 0 {
+			quality = 80
+		}
+		args = append(args, "-quality", fmt.Sprint(quality))
+	}
+
+	return append(args, "-f", "image2pipe")
+}
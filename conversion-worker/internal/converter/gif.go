@@ -0,0 +1,173 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/pkg/logger"
+)
+
+const (
+	defaultGIFWidth  = 480
+	defaultGIFFPS    = 15
+	defaultGIFDither = "floyd_steinberg"
+
+	// Limits for the automatic step-down when a GIF exceeds its size budget.
+	minGIFFPS      = 5
+	minGIFWidth    = 120
+	maxGIFAttempts = 10
+)
+
+var gifDithers = map[string]bool{
+	"none":            true,
+	"bayer":           true,
+	"heckbert":        true,
+	"floyd_steinberg": true,
+	"sierra2":         true,
+	"sierra2_4a":      true,
+	"sierra3":         true,
+	"burkes":          true,
+	"atkinson":        true,
+}
+
+type gifSettings struct {
+	width int
+	fps   float64
+}
+
+// convertToGIF renders a two-pass palette GIF. Each conversion gets its own
+// temp workspace for the palette and intermediate attempts, so concurrent
+// workers never share files.
+func (c *VideoConverter) convertToGIF(ctx context.Context, req Request) error {
+	opts := models.GIFOptions{}
+	if req.Options.GIF != nil {
+		opts = *req.Options.GIF
+	}
+	if err := validateGIFOptions(opts); err != nil {
+		return err
+	}
+
+	workspace, err := os.MkdirTemp(req.Workspace, "gif_*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(workspace)
+
+	settings := gifSettings{width: opts.Width, fps: opts.FPS}
+	if settings.width == 0 {
+		settings.width = defaultGIFWidth
+	}
+	if settings.fps == 0 {
+		settings.fps = defaultGIFFPS
+	}
+
+	attempt := filepath.Join(workspace, "attempt.gif")
+
+	for i := 1; ; i++ {
+		if err := c.renderGIF(ctx, req, attempt, workspace, opts, settings); err != nil {
+			return err
+		}
+
+		size, err := outputSize(req, attempt)
+		if err != nil {
+			return fmt.Errorf("failed to stat GIF: %w", err)
+		}
+		if opts.MaxSize == 0 || size <= int64(opts.MaxSize) {
+			return moveFile(attempt, req.Output)
+		}
+
+		next, ok := stepDownGIF(settings)
+		if !ok || i == maxGIFAttempts {
+			return fmt.Errorf("GIF is %d bytes at %dpx/%gfps, still above the %d bytes limit",
+				size, settings.width, settings.fps, opts.MaxSize)
+		}
+
+		logger.Debug("GIF is %d bytes, above the %d bytes limit; retrying at %dpx/%gfps",
+			size, opts.MaxSize, next.width, next.fps)
+		settings = next
+	}
+}
+
+// renderGIF writes one attempt to output, encrypted like the final output
+// would be.
+func (c *VideoConverter) renderGIF(ctx context.Context, req Request, output, workspace string, opts models.GIFOptions, settings gifSettings) error {
+	input := req.Input
+	dither := opts.Dither
+	if dither == "" {
+		dither = defaultGIFDither
+	}
+	loop := 0
+	if opts.Loop != nil {
+		loop = *opts.Loop
+	}
+
+	var trim []string
+	if opts.Start > 0 {
+		trim = append(trim, "-ss", fmt.Sprintf("%.3f", opts.Start))
+	}
+	if opts.Duration > 0 {
+		trim = append(trim, "-t", fmt.Sprintf("%.3f", opts.Duration))
+	}
+
+	base := fmt.Sprintf("fps=%g,scale=%d:-1:flags=lanczos", settings.fps, settings.width)
+	palette := filepath.Join(workspace, "palette.png")
+
+	paletteArgs := append([]string{"-y"}, trim...)
+	paletteArgs = append(paletteArgs, "-i", input, "-vf", base+",palettegen=stats_mode=diff", palette)
+	paletteCmd := exec.CommandContext(ctx, "ffmpeg", paletteArgs...)
+
+	if err := paletteCmd.Run(); err != nil {
+		return fmt.Errorf("failed to generate palette: %w", err)
+	}
+
+	gifArgs := append([]string{"-y"}, trim...)
+	gifArgs = append(gifArgs, "-i", input, "-i", palette,
+		"-lavfi", fmt.Sprintf("%s[x];[x][1:v]paletteuse=dither=%s", base, dither),
+		"-loop", fmt.Sprint(loop), "-f", "gif")
+
+	if err := runFFmpeg(ctx, req, gifArgs, output); err != nil {
+		return fmt.Errorf("failed to generate GIF: %w", err)
+	}
+
+	return nil
+}
+
+// stepDownGIF lowers the frame rate first, down to 10 fps, because it costs
+// less visual quality than a smaller width; after that it shrinks the width
+// and finally drops the frame rate to its floor.
+func stepDownGIF(current gifSettings) (gifSettings, bool) {
+	next := current
+
+	switch {
+	case current.fps > 10:
+		next.fps = max(10, current.fps*0.75)
+	case current.width > minGIFWidth:
+		next.width = max(minGIFWidth, int(float64(current.width)*0.8)/2*2)
+	case current.fps > minGIFFPS:
+		next.fps = max(minGIFFPS, current.fps-2)
+	default:
+		return current, false
+	}
+
+	return next, true
+}
+
+func validateGIFOptions(opts models.GIFOptions) error {
+	if opts.Width < 0 || opts.FPS < 0 || opts.Start < 0 || opts.Duration < 0 || opts.MaxSize < 0 {
+		return fmt.Errorf("GIF options cannot be negative")
+	}
+	if opts.FPS > 50 {
+		return fmt.Errorf("GIF fps cannot exceed 50")
+	}
+	if opts.Loop != nil && *opts.Loop < -1 {
+		return fmt.Errorf("GIF loop must be -1, 0 or a positive repeat count")
+	}
+	if opts.Dither != "" && !gifDithers[opts.Dither] {
+		return fmt.Errorf("unsupported GIF dither: %s", opts.Dither)
+	}
+	return nil
+}
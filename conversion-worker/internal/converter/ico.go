@@ -0,0 +1,97 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+)
+
+// icoSizes are the square icon sizes embedded in every generated .ico file.
+var icoSizes = []int{16, 24, 32, 48, 64, 128, 256}
+
+// convertToICO renders the input once per icon size as PNG and packs the
+// results into a single multi-resolution ICO container. ffmpeg's own ico
+// encoder only writes one image per file, which is why the container is
+// assembled here.
+func (c *ImageConverter) convertToICO(ctx context.Context, req Request) error {
+	images := make([][]byte, 0, len(icoSizes))
+
+	for _, size := range icoSizes {
+		filter := fmt.Sprintf(
+			"scale=%d:%d:force_original_aspect_ratio=decrease:flags=lanczos,format=rgba,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=black@0",
+			size, size, size, size)
+
+		var stdout bytes.Buffer
+		cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", req.Input,
+			"-frames:v", "1", "-vf", filter, "-c:v", "png", "-f", "image2pipe", "pipe:1")
+		cmd.Stdout = &stdout
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to render %dx%d icon: %w", size, size, err)
+		}
+		images = append(images, stdout.Bytes())
+	}
+
+	data, err := encodeICO(icoSizes, images)
+	if err != nil {
+		return err
+	}
+
+	if err := writeOutput(req, req.Output, data); err != nil {
+		return fmt.Errorf("failed to write ICO file: %w", err)
+	}
+
+	return nil
+}
+
+// encodeICO builds an ICO file whose entries are PNG-compressed images, as
+// allowed since Windows Vista. sizes[i] is the edge length of images[i].
+func encodeICO(sizes []int, images [][]byte) ([]byte, error) {
+	if len(sizes) != len(images) {
+		return nil, fmt.Errorf("icon sizes and images length mismatch")
+	}
+
+	const headerSize, entrySize = 6, 16
+
+	var buf bytes.Buffer
+	header := struct {
+		Reserved uint16
+		Type     uint16
+		Count    uint16
+	}{0, 1, uint16(len(images))}
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+
+	offset := uint32(headerSize + entrySize*len(images))
+	for i, img := range images {
+		if sizes[i] < 1 || sizes[i] > 256 {
+			return nil, fmt.Errorf("invalid icon size: %d", sizes[i])
+		}
+
+		// A dimension of 0 means 256 pixels.
+		dim := uint8(sizes[i] % 256)
+		entry := struct {
+			Width, Height uint8
+			Colors        uint8
+			Reserved      uint8
+			Planes        uint16
+			BitCount      uint16
+			BytesInRes    uint32
+			ImageOffset   uint32
+		}{dim, dim, 0, 0, 1, 32, uint32(len(img)), offset}
+
+		if err := binary.Write(&buf, binary.LittleEndian, entry); err != nil {
+			return nil, err
+		}
+		offset += uint32(len(img))
+	}
+
+	for _, img := range images {
+		buf.Write(img)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncodeICO(t *testing.T) {
+	sizes := []int{16, 48, 256}
+	images := [][]byte{[]byte("sixteen"), []byte("forty-eight"), []byte("two hundred fifty-six")}
+
+	data, err := encodeICO(sizes, images)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	le := binary.LittleEndian
+	if reserved, kind, count := le.Uint16(data[0:]), le.Uint16(data[2:]), le.Uint16(data[4:]); reserved != 0 || kind != 1 || count != 3 {
+		t.Fatalf("header = %d, %d, %d; want 0, 1, 3", reserved, kind, count)
+	}
+
+	tests := []struct {
+		dim    byte
+		offset uint32
+	}{
+		{dim: 16, offset: 6 + 3*16},
+		{dim: 48, offset: 6 + 3*16 + 7},
+		// 256 pixels is written as 0.
+		{dim: 0, offset: 6 + 3*16 + 7 + 11},
+	}
+	for i, tt := range tests {
+		entry := data[6+16*i:]
+		if entry[0] != tt.dim || entry[1] != tt.dim {
+			t.Errorf("entry %d is %dx%d, want %dx%d", i, entry[0], entry[1], tt.dim, tt.dim)
+		}
+		if planes, bits := le.Uint16(entry[4:]), le.Uint16(entry[6:]); planes != 1 || bits != 32 {
+			t.Errorf("entry %d has %d planes and %d bits, want 1 and 32", i, planes, bits)
+		}
+		size, offset := le.Uint32(entry[8:]), le.Uint32(entry[12:])
+		if size != uint32(len(images[i])) || offset != tt.offset {
+			t.Errorf("entry %d is %d bytes at %d, want %d at %d", i, size, offset, len(images[i]), tt.offset)
+		}
+		if got := data[offset : offset+size]; !bytes.Equal(got, images[i]) {
+			t.Errorf("entry %d image = %q, want %q", i, got, images[i])
+		}
+	}
+}
+
+func TestEncodeICOInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		sizes  []int
+		images [][]byte
+	}{
+		{name: "length mismatch", sizes: []int{16, 32}, images: [][]byte{{1}}},
+		{name: "zero size", sizes: []int{0}, images: [][]byte{{1}}},
+		{name: "too large", sizes: []int{512}, images: [][]byte{{1}}},
+	}
+	for _, tt := range tests {
+		if _, err := encodeICO(tt.sizes, tt.images); err == nil {
+			t.Errorf("%s: encodeICO succeeded", tt.name)
+		}
+	}
+}
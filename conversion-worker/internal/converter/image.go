@@ -3,7 +3,7 @@ package converter
 import (
 	"context"
 	"fmt"
-	"os/exec"
+	"strings"
 )
 
 type ImageConverter struct {
@@ -11,26 +11,143 @@ type ImageConverter struct {
 }
 
 func (c *ImageConverter) SupportedFormats() []string {
-	return []string{"png", "jpeg", "jpg", "webp", "gif", "bmp"}
+	return []string{"png", "jpeg", "jpg", "webp", "gif", "bmp", "avif", "tiff", "tif", "ico"}
 }
 
-func (c *ImageConverter) Convert(ctx context.Context, input, format, output string) error {
-	if err := c.validatePaths(input, output); err != nil {
+func (c *ImageConverter) Convert(ctx context.Context, req Request) error {
+	input := req.Input
+	if err := c.validatePaths(input, req.Output); err != nil {
+		return err
+	}
+	if err := checkTransformTarget(req); err != nil {
 		return err
 	}
 
-	var cmd *exec.Cmd
+	probe, err := Probe(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to read input image: %w", err)
+	}
+	if err := checkImageInput(ctx, probe); err != nil {
+		return err
+	}
 
-	switch format {
-	case "png", "jpeg", "jpg", "webp", "gif", "bmp":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, output)
+	var args []string
+
+	switch req.Format {
+	case "png", "jpeg", "jpg", "bmp":
+		args = []string{"-frames:v", "1", "-f", "image2"}
+	case "gif":
+		args = []string{"-f", "gif"}
+	case "webp":
+		if isAnimatedImage(probe) {
+			if err := requireFeature(ctx, FeatureAnimatedWebP); err != nil {
+				return err
+			}
+			args = []string{"-c:v", "libwebp_anim", "-loop", "0", "-q:v", "75", "-f", "webp"}
+		} else {
+			if err := requireFeature(ctx, FeatureWebPEncode); err != nil {
+				return err
+			}
+			args = []string{"-frames:v", "1", "-c:v", "libwebp", "-q:v", "80", "-f", "webp"}
+		}
+	case "avif":
+		avif, err := avifArgs(ctx)
+		if err != nil {
+			return err
+		}
+		args = avif
+	case "tiff", "tif":
+		if err := requireFeature(ctx, FeatureTIFFEncode); err != nil {
+			return err
+		}
+		args = []string{"-frames:v", "1", "-c:v", "tiff", "-compression_algo", "lzw", "-f", "image2"}
+	case "ico":
+		if err := requireFeature(ctx, FeatureICOEncode); err != nil {
+			return err
+		}
+		if hasOverlays(req.Options) {
+			return fmt.Errorf("overlays are not supported for ico output")
+		}
+		return c.convertToICO(ctx, req)
 	default:
-		return fmt.Errorf("unsupported image format: %s", format)
+		return fmt.Errorf("unsupported image format: %s", req.Format)
+	}
+
+	metaArgs, err := metadataArgs(ctx, req)
+	if err != nil {
+		return err
 	}
 
-	if err := cmd.Run(); err != nil {
+	graph := newFilterGraph(req.Workspace)
+	defer graph.cleanup()
+
+	if err := addOverlays(graph, req); err != nil {
+		return err
+	}
+
+	ffmpegArgs := append([]string{"-y", "-i", input}, graph.inputArgs()...)
+	ffmpegArgs = append(ffmpegArgs, graph.outputArgs(false)...)
+	ffmpegArgs = append(ffmpegArgs, args...)
+	ffmpegArgs = append(ffmpegArgs, metaArgs...)
+
+	if err := runFFmpeg(ctx, req, ffmpegArgs, req.Output); err != nil {
 		return fmt.Errorf("ffmpeg conversion failed: %w", err)
 	}
 
 	return nil
 }
+
+// heifBrands are the major brands of HEIF files, which older ffmpeg builds
+// report as plain MOV.
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"hevc": true, "hevx": true, "mif1": true, "msf1": true,
+}
+
+// checkImageInput verifies that ffmpeg can decode source formats that are
+// not part of every build, such as HEIC photos uploaded from iPhones. The
+// format is taken from the probed content, not the file name.
+func checkImageInput(ctx context.Context, probe *ProbeResult) error {
+	if strings.Contains(probe.Format.FormatName, "heif") || heifBrands[probe.Format.Tags["major_brand"]] {
+		return requireFeature(ctx, FeatureHEIFDecode)
+	}
+	if video := probe.FirstVideo(); video != nil && video.CodecName == "tiff" {
+		return requireFeature(ctx, FeatureTIFFDecode)
+	}
+	return nil
+}
+
+func avifArgs(ctx context.Context) ([]string, error) {
+	if err := requireFeature(ctx, FeatureAVIFEncode); err != nil {
+		return nil, err
+	}
+
+	caps, err := DetectCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-frames:v", "1", "-pix_fmt", "yuv420p"}
+
+	switch encoder := caps.AVIFEncoder(); encoder {
+	case "libaom-av1":
+		args = append(args, "-c:v", encoder, "-still-picture", "1", "-crf", "30", "-b:v", "0")
+	case "libsvtav1":
+		args = append(args, "-c:v", encoder, "-crf", "30")
+	default:
+		args = append(args, "-c:v", encoder, "-qp", "80")
+	}
+
+	return append(args, "-f", "avif"), nil
+}
+
+// isAnimatedImage reports whether the input is a GIF or APNG with more than
+// one frame. GIFs rarely record a frame count, so those are taken as
+// animated unless they say otherwise.
+func isAnimatedImage(probe *ProbeResult) bool {
+	video := probe.FirstVideo()
+	if video == nil || (video.CodecName != "gif" && video.CodecName != "apng") {
+		return false
+	}
+	return video.NbFrames != "1"
+}
@@ -0,0 +1,194 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// imageStream splits the output of ffmpeg's image2pipe muxer, which is just
+// the encoded images written back to back, into individual images.
+type imageStream struct {
+	reader *bufio.Reader
+	format string
+}
+
+func newImageStream(r io.Reader, format string) *imageStream {
+	return &imageStream{reader: bufio.NewReaderSize(r, 1<<20), format: format}
+}
+
+// Next returns the next encoded image, or io.EOF once the stream ends cleanly
+// on an image boundary.
+func (s *imageStream) Next() ([]byte, error) {
+	if _, err := s.reader.Peek(1); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	var err error
+
+	switch s.format {
+	case "png":
+		err = s.readPNG(&buf)
+	case "jpg":
+		err = s.readJPEG(&buf)
+	case "webp":
+		err = s.readWebP(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported image stream format: %s", s.format)
+	}
+
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s image in stream: %w", s.format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+// readPNG copies the signature and every chunk up to and including IEND.
+func (s *imageStream) readPNG(buf *bytes.Buffer) error {
+	if _, err := io.CopyN(buf, s.reader, int64(len(pngSignature))); err != nil {
+		return err
+	}
+	if !bytes.Equal(buf.Bytes(), pngSignature) {
+		return fmt.Errorf("bad PNG signature")
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(s.reader, header[:]); err != nil {
+			return err
+		}
+		buf.Write(header[:])
+
+		length := binary.BigEndian.Uint32(header[:4])
+		// Chunk data is followed by a 4 byte CRC.
+		if _, err := io.CopyN(buf, s.reader, int64(length)+4); err != nil {
+			return err
+		}
+
+		if string(header[4:8]) == "IEND" {
+			return nil
+		}
+	}
+}
+
+// readJPEG copies marker segments until EOI. After a start-of-scan segment
+// the entropy coded data is scanned for the next marker, skipping stuffed
+// 0xFF00 bytes and restart markers, which may appear inside the scan.
+func (s *imageStream) readJPEG(buf *bytes.Buffer) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(s.reader, soi[:]); err != nil {
+		return err
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return fmt.Errorf("bad JPEG start marker")
+	}
+	buf.Write(soi[:])
+
+	marker, err := s.nextJPEGMarker(buf)
+	for err == nil {
+		switch {
+		case marker == 0xD9:
+			return nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			marker, err = s.nextJPEGMarker(buf)
+		default:
+			var size [2]byte
+			if _, err = io.ReadFull(s.reader, size[:]); err != nil {
+				return err
+			}
+			buf.Write(size[:])
+			length := int64(binary.BigEndian.Uint16(size[:])) - 2
+			if _, err = io.CopyN(buf, s.reader, length); err != nil {
+				return err
+			}
+
+			if marker == 0xDA {
+				marker, err = s.scanEntropyData(buf)
+			} else {
+				marker, err = s.nextJPEGMarker(buf)
+			}
+		}
+	}
+	return err
+}
+
+// nextJPEGMarker reads a marker (0xFF followed by a code, with optional 0xFF
+// fill bytes) and returns its code.
+func (s *imageStream) nextJPEGMarker(buf *bytes.Buffer) (byte, error) {
+	b, err := s.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("expected JPEG marker, got 0x%02x", b)
+	}
+	buf.WriteByte(b)
+
+	for {
+		code, err := s.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		buf.WriteByte(code)
+		if code != 0xFF {
+			return code, nil
+		}
+	}
+}
+
+func (s *imageStream) scanEntropyData(buf *bytes.Buffer) (byte, error) {
+	for {
+		b, err := s.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		buf.WriteByte(b)
+		if b != 0xFF {
+			continue
+		}
+
+		code, err := s.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		for code == 0xFF {
+			buf.WriteByte(code)
+			if code, err = s.reader.ReadByte(); err != nil {
+				return 0, err
+			}
+		}
+		buf.WriteByte(code)
+
+		if code == 0x00 || (code >= 0xD0 && code <= 0xD7) {
+			continue
+		}
+		return code, nil
+	}
+}
+
+// readWebP copies a RIFF container: "RIFF", a little endian payload size and
+// the payload, padded to an even length.
+func (s *imageStream) readWebP(buf *bytes.Buffer) error {
+	var header [8]byte
+	if _, err := io.ReadFull(s.reader, header[:]); err != nil {
+		return err
+	}
+	if string(header[:4]) != "RIFF" {
+		return fmt.Errorf("bad RIFF header")
+	}
+	buf.Write(header[:])
+
+	size := int64(binary.LittleEndian.Uint32(header[4:]))
+	size += size % 2
+	_, err := io.CopyN(buf, s.reader, size)
+	return err
+}
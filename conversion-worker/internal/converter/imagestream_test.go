@@ -0,0 +1,110 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func testImage(shade uint8) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for i := range img.Pix {
+		img.Pix[i] = shade + uint8(i)
+	}
+	return img
+}
+
+func encodedPNG(t *testing.T, shade uint8) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(shade)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodedJPEG(t *testing.T, shade uint8) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(shade), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// riff builds a WebP-like RIFF container around payload, padded to an even
+// length as the format requires.
+func riff(payload []byte) []byte {
+	data := []byte("RIFF")
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(payload)))
+	data = append(data, payload...)
+	if len(payload)%2 == 1 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// jpegWithEntropyMarkers is a minimal JPEG whose scan holds a stuffed 0xFF00
+// byte, a restart marker and fill bytes, none of which end the image.
+var jpegWithEntropyMarkers = []byte{
+	0xFF, 0xD8, // SOI
+	0xFF, 0xDA, 0x00, 0x04, 0x01, 0x02, // SOS with a two byte header
+	0x11, 0xFF, 0x00, 0x22, 0xFF, 0xD0, 0x33, 0xFF, 0xFF, 0xD9, // scan, then EOI
+}
+
+func TestImageStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		images [][]byte
+	}{
+		{name: "png", format: "png", images: [][]byte{encodedPNG(t, 0), encodedPNG(t, 100), encodedPNG(t, 200)}},
+		{name: "jpeg", format: "jpg", images: [][]byte{encodedJPEG(t, 0), encodedJPEG(t, 100)}},
+		{name: "jpeg scan markers", format: "jpg", images: [][]byte{jpegWithEntropyMarkers, jpegWithEntropyMarkers}},
+		{name: "webp", format: "webp", images: [][]byte{riff([]byte("WEBPVP8 odd")), riff([]byte("WEBPVP8 even"))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := newImageStream(bytes.NewReader(bytes.Join(tt.images, nil)), tt.format)
+			for i, want := range tt.images {
+				got, err := stream.Next()
+				if err != nil {
+					t.Fatalf("image %d: %v", i, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("image %d is %d bytes, want %d", i, len(got), len(want))
+				}
+			}
+			if _, err := stream.Next(); err != io.EOF {
+				t.Errorf("Next at the end = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestImageStreamMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   []byte
+	}{
+		{name: "truncated png", format: "png", data: encodedPNG(t, 0)[:40]},
+		{name: "bad png signature", format: "png", data: []byte("not a png image")},
+		{name: "truncated jpeg", format: "jpg", data: encodedJPEG(t, 0)[:100]},
+		{name: "bad jpeg start", format: "jpg", data: []byte{0xFF, 0xD9}},
+		{name: "truncated webp", format: "webp", data: riff([]byte("WEBPVP8 data"))[:12]},
+		{name: "bad riff header", format: "webp", data: []byte("RIFX\x04\x00\x00\x00WEBP")},
+		{name: "unknown format", format: "bmp", data: []byte("BM")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newImageStream(bytes.NewReader(tt.data), tt.format).Next()
+			if err == nil || errors.Is(err, io.EOF) {
+				t.Errorf("Next = %v, want a malformed image error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+// coverArtFormats are the audio outputs whose containers can embed a picture.
+var coverArtFormats = map[string]bool{
+	"mp3":  true,
+	"flac": true,
+}
+
+// metadataArgs translates the job's metadata policy into ffmpeg output
+// options. It returns nil when the job has no policy, which keeps ffmpeg's
+// default of copying the global metadata of the first input.
+func metadataArgs(ctx context.Context, req Request) ([]string, error) {
+	opts := req.Options.Metadata
+	if opts == nil {
+		return nil, nil
+	}
+
+	var args []string
+
+	switch opts.Mode {
+	case "", models.MetadataKeep:
+		args = append(args, "-map_metadata", "0")
+		args = append(args, containerMetadataArgs(req.Format)...)
+	case models.MetadataStrip:
+		args = append(args, "-map_metadata", "-1", "-map_chapters", "-1")
+	case models.MetadataAllow:
+		tags, err := allowedTags(ctx, req.Input, opts.Allow)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-map_metadata", "-1")
+		for _, key := range sortedKeys(tags) {
+			args = append(args, "-metadata", key+"="+tags[key])
+		}
+		args = append(args, containerMetadataArgs(req.Format)...)
+	default:
+		return nil, fmt.Errorf("unsupported metadata mode: %s", opts.Mode)
+	}
+
+	overrides := []struct{ key, value string }{
+		{"title", opts.Title},
+		{"artist", opts.Artist},
+		{"album", opts.Album},
+	}
+	for _, tag := range overrides {
+		if tag.value != "" {
+			args = append(args, "-metadata", tag.key+"="+tag.value)
+		}
+	}
+
+	return args, nil
+}
+
+// containerMetadataArgs enables the muxer options some containers need to
+// actually write arbitrary tags.
+func containerMetadataArgs(format string) []string {
+	switch format {
+	case "mp4", "mp4-hevc", "mp4-av1", "mov", "m4a":
+		return []string{"-movflags", "use_metadata_tags"}
+	case "mp3":
+		return []string{"-id3v2_version", "3"}
+	}
+	return nil
+}
+
+// allowedTags reads the tags of the input and keeps the ones named in the
+// allow-list. Ogg, Opus and FLAC keep their tags on the audio stream rather
+// than the container, so the first audio stream's tags are read too; global
+// tags win where both are set. Tag names are matched case-insensitively
+// because containers disagree on casing (e.g. "ARTIST" in FLAC, "artist" in
+// MP3).
+func allowedTags(ctx context.Context, input string, allow []string) (map[string]string, error) {
+	probe, err := Probe(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input metadata: %w", err)
+	}
+
+	wanted := make(map[string]bool, len(allow))
+	for _, key := range allow {
+		wanted[strings.ToLower(key)] = true
+	}
+
+	sources := []map[string]string{probe.Format.Tags}
+	if audio := probe.StreamsOfType("audio"); len(audio) > 0 {
+		sources = []map[string]string{audio[0].Tags, probe.Format.Tags}
+	}
+
+	tags := make(map[string]string)
+	for _, source := range sources {
+		for key, value := range source {
+			if lower := strings.ToLower(key); wanted[lower] {
+				tags[lower] = value
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+// coverArtArgs adds the cover image as a second input and maps it as an
+// attached picture. It replaces the "-vn" audio converters use otherwise.
+func coverArtArgs(format string, opts *models.MetadataOptions) ([]string, error) {
+	if opts == nil || opts.CoverArtPath == "" {
+		return nil, nil
+	}
+	if !coverArtFormats[format] {
+		return nil, fmt.Errorf("cover art is not supported for %s output", format)
+	}
+	if _, err := os.Stat(opts.CoverArtPath); err != nil {
+		return nil, fmt.Errorf("cover art not found: %w", err)
+	}
+
+	return []string{
+		"-i", opts.CoverArtPath,
+		"-map", "0:a", "-map", "1:v",
+		"-c:v", "mjpeg", "-disposition:v", "attached_pic",
+	}, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,217 @@
+package converter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Sealer encrypts outputs as they are written, so the plaintext of a
+// conversion never lands in the workspace. Converters reach it through the
+// output helpers below, which fall back to plain files when a request has
+// no Sealer.
+type Sealer interface {
+	// Create creates an encrypted file at path.
+	Create(path string) (io.WriteCloser, error)
+	// Open gives random access to the plaintext of a file made by Create.
+	Open(path string) (SealedFile, error)
+	// Serve returns a URL ffmpeg and ffprobe can read the plaintext of a
+	// file made by Create from, valid until the job ends.
+	Serve(path string) (string, error)
+}
+
+// SealedFile is the plaintext of an encrypted output.
+type SealedFile interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// streamingMovFlags make the MP4 family of muxers write fragments as they
+// go instead of seeking back to the start to write the index.
+const streamingMovFlags = "+frag_keyframe+empty_moov+default_base_moof"
+
+// seekingMuxers go back over their output to fill in sizes, durations or
+// indexes, and write broken files to a pipe; they write to a file in the
+// workspace first instead. See runToFile.
+var seekingMuxers = map[string]bool{
+	"avi":  true,
+	"asf":  true,
+	"wav":  true,
+	"flac": true,
+	"webp": true,
+	"avif": true,
+}
+
+// runFFmpeg runs ffmpeg with args, which end with the "-f" muxer option,
+// writing to output. With a Sealer the output is encrypted as ffmpeg
+// produces it; see pipeFFmpeg.
+func runFFmpeg(ctx context.Context, req Request, args []string, output string) error {
+	if req.Sealer == nil {
+		return exec.CommandContext(ctx, "ffmpeg", append(args, output)...).Run()
+	}
+
+	file, err := req.Sealer.Create(output)
+	if err != nil {
+		return err
+	}
+	err = pipeFFmpeg(ctx, args, file, output+".muxing")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output)
+	}
+	return err
+}
+
+// pipeFFmpeg runs ffmpeg with args, which end with the "-f" muxer option,
+// and copies its output to w. ffmpeg writes to a pipe: the MP4 family
+// switches to fragmented output and image2 to image2pipe, so the muxer never
+// needs to seek. Muxers that cannot do without write to scratch, a path in
+// the workspace, first.
+func pipeFFmpeg(ctx context.Context, args []string, w io.Writer, scratch string) error {
+	muxer := outputMuxer(args)
+	switch {
+	case muxer == "":
+		return fmt.Errorf("piped ffmpeg outputs need an explicit muxer")
+	case seekingMuxers[muxer]:
+		return runToFile(ctx, args, w, scratch)
+	}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(streamingArgs(args, muxer), "pipe:1")...)
+	cmd.Stdout = w
+	return cmd.Run()
+}
+
+// runToFile lets a muxer that seeks write to path, then copies the result to
+// w and removes the file. Outputs of any size fit, at the cost of their
+// plaintext sitting in the workspace until they have been copied.
+func runToFile(ctx context.Context, args []string, w io.Writer, path string) error {
+	defer os.Remove(path)
+	if err := exec.CommandContext(ctx, "ffmpeg", append(args, path)...).Run(); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// outputMuxer returns the value of the last "-f" option, the one that
+// applies to the output.
+func outputMuxer(args []string) string {
+	for i := len(args) - 2; i >= 0; i-- {
+		if args[i] == "-f" {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// streamingArgs adapts the output options of args to a pipe.
+func streamingArgs(args []string, muxer string) []string {
+	args = append([]string{}, args...)
+
+	switch muxer {
+	case "mp4", "mov", "ipod":
+		for i := len(args) - 2; i >= 0; i-- {
+			if args[i] == "-movflags" {
+				args[i+1] += streamingMovFlags
+				return args
+			}
+		}
+		return append(args, "-movflags", streamingMovFlags)
+	case "image2":
+		for i := len(args) - 2; i >= 0; i-- {
+			if args[i] == "-f" {
+				args[i+1] = "image2pipe"
+				break
+			}
+		}
+	}
+	return args
+}
+
+// createOutput creates a file the converter writes itself, encrypted when
+// the request has a Sealer.
+func createOutput(req Request, path string) (io.WriteCloser, error) {
+	if req.Sealer != nil {
+		return req.Sealer.Create(path)
+	}
+	return os.Create(path)
+}
+
+// writeOutput writes data to a file, encrypted when the request has a
+// Sealer.
+func writeOutput(req Request, path string, data []byte) error {
+	file, err := createOutput(req, path)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readableOutput returns a path or URL ffmpeg and ffprobe can read a file
+// written through the output helpers from.
+func readableOutput(req Request, path string) (string, error) {
+	if req.Sealer == nil {
+		return path, nil
+	}
+	return req.Sealer.Serve(path)
+}
+
+// outputSize returns the plaintext size of a file written through the
+// output helpers.
+func outputSize(req Request, path string) (int64, error) {
+	file, err := openOutput(req, path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.Size(), nil
+}
+
+// openOutput opens the plaintext of a file written through the output
+// helpers.
+func openOutput(req Request, path string) (SealedFile, error) {
+	if req.Sealer != nil {
+		return req.Sealer.Open(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return plainFile{File: file, size: info.Size()}, nil
+}
+
+type plainFile struct {
+	*os.File
+	size int64
+}
+
+func (f plainFile) Size() int64 {
+	return f.size
+}
+
+// outputReader reads the plaintext of an output from the start.
+func outputReader(file SealedFile) io.Reader {
+	return bufio.NewReader(io.NewSectionReader(file, 0, file.Size()))
+}
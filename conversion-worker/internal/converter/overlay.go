@@ -0,0 +1,146 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+const (
+	defaultOverlayMargin = 10
+	defaultTextSize      = 24
+	defaultTextColor     = "white"
+)
+
+func hasOverlays(opts models.JobOptions) bool {
+	return opts.Watermark != nil || len(opts.TextOverlays) > 0
+}
+
+// addOverlays is the watermark and text overlay filter stage. It appends the
+// job's watermark first and then each text overlay, in order, so text is
+// always drawn above the watermark.
+func addOverlays(g *filterGraph, req Request) error {
+	if wm := req.Options.Watermark; wm != nil {
+		if err := addWatermark(g, wm); err != nil {
+			return err
+		}
+	}
+
+	for i, overlay := range req.Options.TextOverlays {
+		if err := addTextOverlay(g, i, overlay); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addWatermark(g *filterGraph, wm *models.WatermarkOptions) error {
+	if wm.Path == "" {
+		return fmt.Errorf("watermark requires a stored asset ID")
+	}
+	if _, err := os.Stat(wm.Path); err != nil {
+		return fmt.Errorf("watermark not found: %w", err)
+	}
+	if wm.Opacity < 0 || wm.Opacity > 1 {
+		return fmt.Errorf("watermark opacity must be between 0 and 1")
+	}
+	if wm.Scale < 0 || wm.Scale > 1 {
+		return fmt.Errorf("watermark scale must be between 0 and 1")
+	}
+
+	margin := wm.Margin
+	if margin == 0 {
+		margin = defaultOverlayMargin
+	}
+	x, y, err := positionExpr(wm.Position, margin, "main_w", "main_h", "overlay_w", "overlay_h")
+	if err != nil {
+		return fmt.Errorf("invalid watermark: %w", err)
+	}
+
+	side := g.addInput(wm.Path)
+	if wm.Scale > 0 {
+		// The scale is relative to the width of the video as it reaches the
+		// overlay, after any crop or resize, so the watermark keeps the same
+		// visual weight at any resolution. The height follows the
+		// watermark's own aspect ratio.
+		side = g.prepareWith(side, fmt.Sprintf(
+			"scale2ref=w='max(2,trunc(main_w*%g/2)*2)':h='max(2,trunc(ow*ih/iw/2)*2)'", wm.Scale))
+	}
+
+	filters := []string{"format=rgba"}
+	if wm.Opacity > 0 && wm.Opacity < 1 {
+		filters = append(filters, fmt.Sprintf("colorchannelmixer=aa=%.3f", wm.Opacity))
+	}
+
+	side = g.prepare(side, strings.Join(filters, ","))
+	g.applyWith(side, fmt.Sprintf("overlay=x=%s:y=%s", filterValue(x), filterValue(y)))
+
+	return nil
+}
+
+func addTextOverlay(g *filterGraph, index int, overlay models.TextOverlay) error {
+	if overlay.Text == "" {
+		return fmt.Errorf("text overlay %d has no text", index)
+	}
+	if overlay.End != 0 && overlay.End <= overlay.Start {
+		return fmt.Errorf("text overlay %d ends before it starts", index)
+	}
+
+	margin := overlay.Margin
+	if margin == 0 {
+		margin = defaultOverlayMargin
+	}
+	x, y, err := positionExpr(overlay.Position, margin, "w", "h", "text_w", "text_h")
+	if err != nil {
+		return fmt.Errorf("invalid text overlay %d: %w", index, err)
+	}
+
+	size := overlay.Size
+	if size == 0 {
+		size = defaultTextSize
+	}
+	color := overlay.Color
+	if color == "" {
+		color = defaultTextColor
+	}
+
+	// The text goes through a file so it needs no filtergraph escaping; only
+	// the expansion syntax is handled here.
+	text := strings.ReplaceAll(overlay.Text, "%", "%%")
+	text = strings.ReplaceAll(text, "{timestamp}", "%{pts:hms}")
+	textFile, err := g.writeTempFile(fmt.Sprintf("text_%d.txt", index), text)
+	if err != nil {
+		return err
+	}
+
+	opts := []string{
+		"textfile=" + filterValue(textFile),
+		fmt.Sprintf("fontsize=%d", size),
+		"fontcolor=" + filterValue(color),
+		"x=" + filterValue(x),
+		"y=" + filterValue(y),
+	}
+
+	switch {
+	case overlay.FontPath != "":
+		opts = append(opts, "fontfile="+filterValue(overlay.FontPath))
+	case strings.ContainsAny(overlay.Font, `/\`):
+		return fmt.Errorf("text overlay %d: font must be a family name; store font files as assets", index)
+	case overlay.Font != "":
+		opts = append(opts, "font="+filterValue(overlay.Font))
+	}
+
+	if overlay.Start > 0 || overlay.End > 0 {
+		enable := fmt.Sprintf("gte(t,%g)", overlay.Start)
+		if overlay.End > 0 {
+			enable = fmt.Sprintf("between(t,%g,%g)", overlay.Start, overlay.End)
+		}
+		opts = append(opts, "enable="+filterValue(enable))
+	}
+
+	g.apply("drawtext=" + strings.Join(opts, ":"))
+	return nil
+}
@@ -0,0 +1,177 @@
+package converter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type ProbeResult struct {
+	Format  ProbeFormat   `json:"format"`
+	Streams []ProbeStream `json:"streams"`
+}
+
+type ProbeFormat struct {
+	Filename   string            `json:"filename"`
+	FormatName string            `json:"format_name"`
+	Duration   string            `json:"duration"`
+	Size       string            `json:"size"`
+	BitRate    string            `json:"bit_rate"`
+	Tags       map[string]string `json:"tags"`
+}
+
+type ProbeStream struct {
+	Index       int               `json:"index"`
+	CodecType   string            `json:"codec_type"`
+	CodecName   string            `json:"codec_name"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	Channels    int               `json:"channels"`
+	SampleRate  string            `json:"sample_rate"`
+	FrameRate   string            `json:"avg_frame_rate"`
+	NbFrames    string            `json:"nb_frames"`
+	Duration    string            `json:"duration"`
+	BitRate     string            `json:"bit_rate"`
+	Tags        map[string]string `json:"tags"`
+	Disposition map[string]int    `json:"disposition"`
+	SideData    []ProbeSideData   `json:"side_data_list"`
+}
+
+// ProbeSideData is a side data entry of a stream. Only the display matrix
+// rotation is read.
+type ProbeSideData struct {
+	Type     string  `json:"side_data_type"`
+	Rotation float64 `json:"rotation"`
+}
+
+// Probe runs ffprobe on the input and returns its container and stream data.
+func Probe(ctx context.Context, input string) (*ProbeResult, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error",
+		"-print_format", "json", "-show_format", "-show_streams", input)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var result ProbeResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	return &result, nil
+}
+
+// scanDuration reads the timestamps of every packet of the first video
+// stream, or the first audio stream, and returns where the last one ends.
+// It measures outputs whose container does not record a duration.
+func scanDuration(ctx context.Context, input string, video bool) (float64, error) {
+	stream := "a:0"
+	if video {
+		stream = "v:0"
+	}
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", stream,
+		"-show_entries", "packet=pts_time,duration_time", "-of", "csv=p=0", input)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open ffprobe output: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start ffprobe: %w", err)
+	}
+
+	var end float64
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		pts, duration, _ := strings.Cut(scanner.Text(), ",")
+		end = max(end, parseFloat(pts)+parseFloat(strings.TrimSuffix(duration, ",")))
+	}
+	io.Copy(io.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	return end, nil
+}
+
+// Duration returns the container duration in seconds, or 0 when unknown.
+func (p *ProbeResult) Duration() float64 {
+	return parseFloat(p.Format.Duration)
+}
+
+// BitRate returns the overall bitrate in bits per second, or 0 when unknown.
+func (p *ProbeResult) BitRate() int64 {
+	return int64(parseFloat(p.Format.BitRate))
+}
+
+// StreamsOfType returns the streams whose codec_type matches, e.g. "video",
+// "audio" or "subtitle".
+func (p *ProbeResult) StreamsOfType(codecType string) []ProbeStream {
+	var streams []ProbeStream
+	for _, s := range p.Streams {
+		if s.CodecType == codecType {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+func (p *ProbeResult) HasStream(codecType string) bool {
+	return len(p.StreamsOfType(codecType)) > 0
+}
+
+// FirstVideo returns the first video stream that is not an attached picture
+// such as embedded cover art.
+func (p *ProbeResult) FirstVideo() *ProbeStream {
+	for i, s := range p.Streams {
+		if s.CodecType == "video" && s.Disposition["attached_pic"] == 0 {
+			return &p.Streams[i]
+		}
+	}
+	return nil
+}
+
+// DisplaySize returns the width and height of a video stream as it is shown
+// and as filters see it: ffmpeg rotates frames by the display matrix before
+// filtering, so a stream rotated by 90 or 270 degrees has them swapped.
+func (s *ProbeStream) DisplaySize() (int, int) {
+	rotation := 0.0
+	for _, side := range s.SideData {
+		if side.Type == "Display Matrix" {
+			rotation = side.Rotation
+		}
+	}
+	if rotation == 0 {
+		rotation = parseFloat(s.Tags["rotate"])
+	}
+	if int(math.Abs(math.Round(rotation)))%180 == 90 {
+		return s.Height, s.Width
+	}
+	return s.Width, s.Height
+}
+
+// FPS returns the average frame rate of a video stream, or 0 when unknown.
+func (s *ProbeStream) FPS() float64 {
+	num, den, found := strings.Cut(s.FrameRate, "/")
+	if !found {
+		return parseFloat(s.FrameRate)
+	}
+	if d := parseFloat(den); d > 0 {
+		return parseFloat(num) / d
+	}
+	return 0
+}
+
+func parseFloat(value string) float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
@@ -0,0 +1,180 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/pkg/logger"
+)
+
+// Encoding paths recorded in the task metadata under "encoding".
+const (
+	EncodingRemux     = "remux"
+	EncodingTranscode = "transcode"
+)
+
+// remuxRule lists the codecs a container can take as they are. Text
+// subtitles are either copied or, for MP4 and MOV, converted to mov_text,
+// which is cheap; containers without subtitleCodec leave subtitles out, as
+// a transcode does.
+type remuxRule struct {
+	video         map[string]bool
+	audio         map[string]bool
+	subtitles     map[string]bool
+	subtitleCodec string
+	// hvc1 tags HEVC video, which Apple players require in MP4 and MOV.
+	hvc1 bool
+}
+
+func codecSet(codecs ...string) map[string]bool {
+	set := make(map[string]bool, len(codecs))
+	for _, codec := range codecs {
+		set[codec] = true
+	}
+	return set
+}
+
+var movTextSources = codecSet("mov_text", "subrip", "ass", "ssa", "webvtt", "text")
+
+var mp4Audio = codecSet("aac", "mp3", "ac3", "eac3", "opus", "alac")
+
+// remuxRules follows the codec matrix: "mp4" stands for H.264, so HEVC and
+// AV1 sources only take the fast path to "mp4-hevc" and "mp4-av1".
+var remuxRules = map[string]remuxRule{
+	"mp4": {
+		video:         codecSet("h264"),
+		audio:         mp4Audio,
+		subtitles:     movTextSources,
+		subtitleCodec: "mov_text",
+	},
+	"mp4-hevc": {
+		video:         codecSet("hevc"),
+		audio:         mp4Audio,
+		subtitles:     movTextSources,
+		subtitleCodec: "mov_text",
+		hvc1:          true,
+	},
+	"mp4-av1": {
+		video:         codecSet("av1"),
+		audio:         mp4Audio,
+		subtitles:     movTextSources,
+		subtitleCodec: "mov_text",
+	},
+	"mov": {
+		video:         codecSet("h264", "hevc", "mpeg4", "prores", "mjpeg"),
+		audio:         codecSet("aac", "mp3", "ac3", "alac", "pcm_s16le", "pcm_s24le"),
+		subtitles:     movTextSources,
+		subtitleCodec: "mov_text",
+		hvc1:          true,
+	},
+	"mkv": {
+		video:         codecSet("h264", "hevc", "av1", "vp8", "vp9", "mpeg4", "mpeg2video", "theora"),
+		audio:         codecSet("aac", "mp3", "ac3", "eac3", "opus", "vorbis", "flac", "alac", "dts", "pcm_s16le", "pcm_s24le"),
+		subtitles:     codecSet("subrip", "ass", "ssa", "webvtt", "mov_text", "hdmv_pgs_subtitle", "dvd_subtitle"),
+		subtitleCodec: "copy",
+	},
+	"webm": {
+		video:         codecSet("vp8", "vp9", "av1"),
+		audio:         codecSet("opus", "vorbis"),
+		subtitles:     codecSet("webvtt"),
+		subtitleCodec: "copy",
+	},
+	"ogv": {
+		video: codecSet("theora"),
+		audio: codecSet("vorbis", "opus", "flac"),
+	},
+	"avi": {
+		video: codecSet("h264", "mpeg4", "mjpeg"),
+		audio: codecSet("mp3", "ac3", "pcm_s16le"),
+	},
+	"flv": {
+		video: codecSet("h264"),
+		audio: codecSet("aac", "mp3"),
+	},
+	"wmv": {
+		video: codecSet("wmv1", "wmv2", "wmv3", "vc1"),
+		audio: codecSet("wmav1", "wmav2", "wmapro"),
+	},
+}
+
+// canRemux reports whether the request asks for nothing that needs decoded
+// frames, so that copying the streams could satisfy it.
+func canRemux(req Request) bool {
+	opts := req.Options
+	return opts.Transform == nil && !hasOverlays(opts) && opts.BurnSubtitles == nil && opts.MaxSize == 0
+}
+
+// remuxArgs returns the stream mapping and codec arguments that copy every
+// stream of the input into the target container, or false when a stream
+// has a codec the container cannot take as is.
+func remuxArgs(probe *ProbeResult, format string) ([]string, bool) {
+	rule, ok := remuxRules[format]
+	if !ok {
+		return nil, false
+	}
+
+	video := probe.FirstVideo()
+	if video == nil || !rule.video[video.CodecName] {
+		return nil, false
+	}
+	args := []string{"-map", fmt.Sprintf("0:%d", video.Index), "-map", "0:a?"}
+
+	for _, stream := range probe.StreamsOfType("audio") {
+		if !rule.audio[stream.CodecName] {
+			return nil, false
+		}
+	}
+
+	subtitles := probe.StreamsOfType("subtitle")
+	if len(subtitles) > 0 && rule.subtitleCodec != "" {
+		for _, stream := range subtitles {
+			if !rule.subtitles[stream.CodecName] {
+				return nil, false
+			}
+		}
+		args = append(args, "-map", "0:s?")
+	}
+
+	args = append(args, "-c", "copy")
+	if len(subtitles) > 0 && rule.subtitleCodec != "" {
+		args = append(args, "-c:s", rule.subtitleCodec)
+	}
+	if video.CodecName == "hevc" && rule.hvc1 {
+		args = append(args, "-tag:v", "hvc1")
+	}
+
+	return args, true
+}
+
+// tryRemux copies the input streams into the target container when their
+// codecs allow it. It returns false when the caller has to transcode, either
+// because the codecs don't fit or because the remux itself failed.
+func (c *VideoConverter) tryRemux(ctx context.Context, req Request, container videoContainer, metaArgs []string) bool {
+	probe, err := Probe(ctx, req.Input)
+	if err != nil {
+		return false
+	}
+
+	copyArgs, ok := remuxArgs(probe, req.Format)
+	if !ok {
+		return false
+	}
+
+	args := append([]string{"-y", "-i", req.Input}, copyArgs...)
+	args = append(args, metaArgs...)
+	args = append(args, "-f", container.muxer)
+
+	if err := runFFmpeg(ctx, req, args, req.Output); err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		logger.Warn("Remux to %s failed, falling back to transcoding: %v", req.Format, err)
+		os.Remove(req.Output)
+		req.Metadata.Set("remux_error", err.Error())
+		return false
+	}
+
+	req.Metadata.Set("encoding", EncodingRemux)
+	return true
+}
@@ -0,0 +1,91 @@
+package converter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// probeOf builds a probe result with one stream per "type:codec" pair.
+func probeOf(streams ...string) *ProbeResult {
+	probe := &ProbeResult{}
+	for i, s := range streams {
+		codecType, codec, _ := strings.Cut(s, ":")
+		probe.Streams = append(probe.Streams, ProbeStream{Index: i, CodecType: codecType, CodecName: codec})
+	}
+	return probe
+}
+
+func TestRemuxArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		probe  *ProbeResult
+		format string
+		want   []string
+	}{
+		{
+			name:   "h264 to mp4",
+			probe:  probeOf("video:h264", "audio:aac"),
+			format: "mp4",
+			want:   []string{"-map", "0:0", "-map", "0:a?", "-c", "copy"},
+		},
+		{
+			name:   "subtitles converted to mov_text",
+			probe:  probeOf("video:h264", "audio:aac", "subtitle:subrip"),
+			format: "mp4",
+			want:   []string{"-map", "0:0", "-map", "0:a?", "-map", "0:s?", "-c", "copy", "-c:s", "mov_text"},
+		},
+		{
+			name:   "hevc tagged hvc1",
+			probe:  probeOf("video:hevc", "audio:eac3"),
+			format: "mp4-hevc",
+			want:   []string{"-map", "0:0", "-map", "0:a?", "-c", "copy", "-tag:v", "hvc1"},
+		},
+		{
+			name:   "mkv copies subtitles",
+			probe:  probeOf("audio:flac", "video:hevc", "subtitle:hdmv_pgs_subtitle"),
+			format: "mkv",
+			want:   []string{"-map", "0:1", "-map", "0:a?", "-map", "0:s?", "-c", "copy", "-c:s", "copy"},
+		},
+		{
+			name:   "subtitles dropped without a subtitle codec",
+			probe:  probeOf("video:h264", "audio:mp3", "subtitle:ass"),
+			format: "flv",
+			want:   []string{"-map", "0:0", "-map", "0:a?", "-c", "copy"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := remuxArgs(tt.probe, tt.format)
+			if !ok {
+				t.Fatal("remuxArgs = false, want a remux")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remuxArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemuxArgsTranscode(t *testing.T) {
+	coverArt := probeOf("video:mjpeg", "audio:aac")
+	coverArt.Streams[0].Disposition = map[string]int{"attached_pic": 1}
+
+	tests := []struct {
+		name   string
+		probe  *ProbeResult
+		format string
+	}{
+		{name: "unknown format", probe: probeOf("video:h264"), format: "gif"},
+		{name: "hevc to plain mp4", probe: probeOf("video:hevc", "audio:aac"), format: "mp4"},
+		{name: "audio codec", probe: probeOf("video:vp9", "audio:aac"), format: "webm"},
+		{name: "bitmap subtitles in mp4", probe: probeOf("video:h264", "subtitle:hdmv_pgs_subtitle"), format: "mp4"},
+		{name: "no video", probe: probeOf("audio:aac"), format: "mp4"},
+		{name: "only cover art", probe: coverArt, format: "mov"},
+	}
+	for _, tt := range tests {
+		if args, ok := remuxArgs(tt.probe, tt.format); ok {
+			t.Errorf("%s: remuxArgs = %q, want a transcode", tt.name, args)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strings"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+const (
+	defaultSpriteInterval = 10
+	defaultSpriteWidth    = 160
+	defaultSpriteGrid     = 10
+
+	defaultSheetGrid  = 4
+	defaultSheetWidth = 320
+
+	// maxThumbnailGrid bounds the columns and rows of a sprite or contact
+	// sheet; the tiled image must also fit in maxImageSize.
+	maxThumbnailGrid = 100
+
+	spriteVTTName = "thumbnails.vtt"
+)
+
+type thumbnailGrid struct {
+	width, height int
+	columns, rows int
+}
+
+// convertToSprites samples a thumbnail every interval seconds, tiles them into
+// sprite images and writes them to a ZIP together with a WebVTT thumbnails
+// track that maps each time range to its region of a sprite.
+func (c *VideoConverter) convertToSprites(ctx context.Context, req Request) error {
+	opts := models.SpriteOptions{}
+	if req.Options.Sprites != nil {
+		opts = *req.Options.Sprites
+	}
+	if opts.Interval < 0 || opts.Width < 0 || opts.Columns < 0 || opts.Rows < 0 {
+		return fmt.Errorf("sprite options cannot be negative")
+	}
+
+	interval := opts.Interval
+	if interval == 0 {
+		interval = defaultSpriteInterval
+	}
+
+	probe, err := Probe(ctx, req.Input)
+	if err != nil {
+		return err
+	}
+	duration := probe.Duration()
+	if duration <= 0 {
+		return fmt.Errorf("cannot build sprites: input duration is unknown")
+	}
+
+	grid, err := newThumbnailGrid(probe, opts.Width, opts.Columns, opts.Rows, defaultSpriteWidth, defaultSpriteGrid)
+	if err != nil {
+		return err
+	}
+
+	filter := fmt.Sprintf("fps=1/%g,scale=%d:%d:flags=lanczos,tile=%dx%d",
+		interval, grid.width, grid.height, grid.columns, grid.rows)
+
+	archive, err := createZipArchive(req, req.Output)
+	if err != nil {
+		return err
+	}
+
+	sheets, err := streamSprites(ctx, req.Input, filter, archive)
+	if err == nil {
+		thumbs := min(int(math.Ceil(duration/interval)), sheets*grid.columns*grid.rows)
+		err = archive.add(spriteVTTName, []byte(spriteVTT(grid, interval, duration, thumbs)))
+	}
+
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// streamSprites pipes the tiled sprite images from ffmpeg straight into the
+// archive and returns how many were written.
+func streamSprites(ctx context.Context, input, filter string, archive *zipArchive) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-an", "-sn",
+		"-vf", filter, "-fps_mode", "vfr",
+		"-c:v", "mjpeg", "-q:v", "3", "-f", "image2pipe", "pipe:1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open ffmpeg output: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start sprite generation: %w", err)
+	}
+
+	stream := newImageStream(stdout, "jpg")
+	count := 0
+
+	for {
+		sprite, err := stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			count++
+			err = archive.add(spriteName(count), sprite)
+		}
+		if err != nil {
+			cancel()
+			cmd.Wait()
+			return 0, err
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return 0, fmt.Errorf("failed to generate sprites: %w", err)
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("no sprites were generated")
+	}
+	return count, nil
+}
+
+// spriteVTT builds the WebVTT thumbnails track. Each cue points to a region
+// of a sprite with a media fragment: sprite_001.jpg#xywh=x,y,w,h.
+func spriteVTT(grid thumbnailGrid, interval, duration float64, thumbs int) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	perSprite := grid.columns * grid.rows
+	for i := 0; i < thumbs; i++ {
+		start := float64(i) * interval
+		end := math.Min(start+interval, duration)
+		cell := i % perSprite
+
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), spriteName(i/perSprite+1),
+			(cell%grid.columns)*grid.width, (cell/grid.columns)*grid.height, grid.width, grid.height)
+	}
+
+	return b.String()
+}
+
+// convertToContactSheet renders a single overview image with columns x rows
+// thumbnails evenly spread over the video, each stamped with its timestamp.
+func (c *VideoConverter) convertToContactSheet(ctx context.Context, req Request) error {
+	opts := models.ContactSheetOptions{}
+	if req.Options.ContactSheet != nil {
+		opts = *req.Options.ContactSheet
+	}
+	if opts.Width < 0 || opts.Columns < 0 || opts.Rows < 0 {
+		return fmt.Errorf("contact sheet options cannot be negative")
+	}
+
+	probe, err := Probe(ctx, req.Input)
+	if err != nil {
+		return err
+	}
+	duration := probe.Duration()
+	if duration <= 0 {
+		return fmt.Errorf("cannot build contact sheet: input duration is unknown")
+	}
+
+	grid, err := newThumbnailGrid(probe, opts.Width, opts.Columns, opts.Rows, defaultSheetWidth, defaultSheetGrid)
+	if err != nil {
+		return err
+	}
+
+	format := ContactSheetFormat(req.Options.ContactSheet)
+	if _, ok := frameEncoders[format]; !ok {
+		return fmt.Errorf("unsupported contact sheet format: %s", format)
+	}
+
+	fontSize := max(12, grid.height/10)
+	stamp := strings.Join([]string{
+		"text=" + filterValue("%{pts:hms}"),
+		fmt.Sprintf("fontsize=%d", fontSize),
+		"fontcolor=white",
+		"box=1",
+		"boxcolor=" + filterValue("black@0.6"),
+		"boxborderw=4",
+		"x=6",
+		"y=" + filterValue("h-th-6"),
+	}, ":")
+
+	// Sampling at count/duration fps spreads the thumbnails over the whole
+	// video; the half-interval offset skips the usually black first frame.
+	count := grid.columns * grid.rows
+	step := duration / float64(count)
+	filter := strings.Join([]string{
+		fmt.Sprintf("fps=1/%g:start_time=%g", step, step/2),
+		fmt.Sprintf("scale=%d:%d:flags=lanczos", grid.width, grid.height),
+		"drawtext=" + stamp,
+		fmt.Sprintf("tile=%dx%d:padding=4:margin=4", grid.columns, grid.rows),
+	}, ",")
+
+	args := []string{"-y", "-i", req.Input, "-an", "-sn", "-vf", filter, "-frames:v", "1"}
+	args = append(args, frameEncodeArgs(format, 0)...)
+
+	if err := runFFmpeg(ctx, req, args, req.Output); err != nil {
+		return fmt.Errorf("failed to generate contact sheet: %w", err)
+	}
+
+	return nil
+}
+
+// ContactSheetFormat returns the image format of a "contactsheet" output.
+func ContactSheetFormat(opts *models.ContactSheetOptions) string {
+	if opts == nil || opts.Format == "" {
+		return "jpg"
+	}
+	if format := strings.ToLower(opts.Format); format != "jpeg" {
+		return format
+	}
+	return "jpg"
+}
+
+// newThumbnailGrid sizes thumbnails to the requested width, keeping the
+// aspect ratio of the video, and fills in default grid dimensions. It
+// rejects grids whose tiled image would exceed maxImageSize.
+func newThumbnailGrid(probe *ProbeResult, width, columns, rows, defaultWidth, defaultGrid int) (thumbnailGrid, error) {
+	video := probe.FirstVideo()
+	if video == nil || video.Width == 0 || video.Height == 0 {
+		return thumbnailGrid{}, fmt.Errorf("input has no video stream")
+	}
+
+	if width == 0 {
+		width = defaultWidth
+	}
+	if columns == 0 {
+		columns = defaultGrid
+	}
+	if rows == 0 {
+		rows = defaultGrid
+	}
+
+	if columns > maxThumbnailGrid || rows > maxThumbnailGrid {
+		return thumbnailGrid{}, fmt.Errorf("thumbnail grid cannot exceed %dx%d", maxThumbnailGrid, maxThumbnailGrid)
+	}
+
+	width = width / 2 * 2
+	videoWidth, videoHeight := video.DisplaySize()
+	height := max(int(math.Round(float64(width)*float64(videoHeight)/float64(videoWidth)/2))*2, 2)
+	if width*columns > maxImageSize || height*rows > maxImageSize {
+		return thumbnailGrid{}, fmt.Errorf("%dx%d thumbnails of %dx%d pixels exceed %dx%d pixels",
+			columns, rows, width, height, maxImageSize, maxImageSize)
+	}
+
+	return thumbnailGrid{width: width, height: height, columns: columns, rows: rows}, nil
+}
+
+func spriteName(index int) string {
+	return fmt.Sprintf("sprite_%03d.jpg", index)
+}
+
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, ms%1000)
+}
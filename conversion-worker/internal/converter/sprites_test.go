@@ -0,0 +1,42 @@
+package converter
+
+import "testing"
+
+func TestSpriteVTT(t *testing.T) {
+	grid := thumbnailGrid{width: 160, height: 90, columns: 2, rows: 2}
+	want := `WEBVTT
+
+00:00:00.000 --> 00:00:10.000
+sprite_001.jpg#xywh=0,0,160,90
+
+00:00:10.000 --> 00:00:20.000
+sprite_001.jpg#xywh=160,0,160,90
+
+00:00:20.000 --> 00:00:30.000
+sprite_001.jpg#xywh=0,90,160,90
+
+00:00:30.000 --> 00:00:40.000
+sprite_001.jpg#xywh=160,90,160,90
+
+00:00:40.000 --> 00:00:45.500
+sprite_002.jpg#xywh=0,0,160,90
+`
+	if got := spriteVTT(grid, 10, 45.5, 5); got != want {
+		t.Errorf("spriteVTT =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestVTTTimestamp(t *testing.T) {
+	tests := map[float64]string{
+		0:        "00:00:00.000",
+		1.2345:   "00:00:01.235",
+		59.9996:  "00:01:00.000",
+		3725.5:   "01:02:05.500",
+		36000.25: "10:00:00.250",
+	}
+	for seconds, want := range tests {
+		if got := vttTimestamp(seconds); got != want {
+			t.Errorf("vttTimestamp(%g) = %q, want %q", seconds, got, want)
+		}
+	}
+}
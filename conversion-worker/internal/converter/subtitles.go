@@ -0,0 +1,193 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+const defaultSubtitleFormat = "srt"
+
+// subtitleEncoders maps the "subtitles" output formats to the ffmpeg encoder
+// and muxer that produce them.
+var subtitleEncoders = map[string]struct{ codec, muxer string }{
+	"srt": {"srt", "srt"},
+	"vtt": {"webvtt", "webvtt"},
+	"ass": {"ass", "ass"},
+}
+
+// bitmapSubtitleCodecs are image based and cannot be converted to text
+// formats; they can only be burned in with the overlay filter.
+var bitmapSubtitleCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+// SubtitleConverter converts standalone subtitle files between formats.
+type SubtitleConverter struct {
+	BaseConverter
+}
+
+func (c *SubtitleConverter) SupportedFormats() []string {
+	return []string{"subtitles"}
+}
+
+func (c *SubtitleConverter) Convert(ctx context.Context, req Request) error {
+	if err := c.validatePaths(req.Input, req.Output); err != nil {
+		return err
+	}
+	if err := checkTransformTarget(req); err != nil {
+		return err
+	}
+	if req.Format != "subtitles" {
+		return fmt.Errorf("unsupported subtitle format: %s", req.Format)
+	}
+
+	encoder, err := subtitleEncoder(req.Options.Subtitles)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-y", "-i", req.Input, "-map", "0:s:0", "-c:s", encoder.codec, "-f", encoder.muxer}
+
+	if err := runFFmpeg(ctx, req, args, req.Output); err != nil {
+		return fmt.Errorf("ffmpeg subtitle conversion failed: %w", err)
+	}
+
+	return nil
+}
+
+// extractSubtitles writes one embedded subtitle track of a video to a
+// standalone subtitle file.
+func (c *VideoConverter) extractSubtitles(ctx context.Context, req Request) error {
+	encoder, err := subtitleEncoder(req.Options.Subtitles)
+	if err != nil {
+		return err
+	}
+
+	probe, err := Probe(ctx, req.Input)
+	if err != nil {
+		return err
+	}
+
+	var language string
+	var track *int
+	if opts := req.Options.Subtitles; opts != nil {
+		language, track = opts.Language, opts.Track
+	}
+
+	stream, _, err := selectSubtitleStream(probe, language, track)
+	if err != nil {
+		return err
+	}
+	if bitmapSubtitleCodecs[stream.CodecName] {
+		return fmt.Errorf("subtitle track %d is image based (%s) and cannot be extracted as text", stream.Index, stream.CodecName)
+	}
+
+	args := []string{"-y", "-i", req.Input,
+		"-map", fmt.Sprintf("0:%d", stream.Index), "-c:s", encoder.codec, "-f", encoder.muxer}
+
+	if err := runFFmpeg(ctx, req, args, req.Output); err != nil {
+		return fmt.Errorf("failed to extract subtitles: %w", err)
+	}
+
+	return nil
+}
+
+// addBurnedSubtitles is the burn-in filter stage. Text subtitles go through
+// the subtitles filter, which also renders ASS styling; image based tracks are
+// overlaid directly.
+func addBurnedSubtitles(ctx context.Context, g *filterGraph, req Request) error {
+	opts := req.Options.BurnSubtitles
+	if opts == nil {
+		return nil
+	}
+
+	if opts.Path != "" {
+		if _, err := os.Stat(opts.Path); err != nil {
+			return fmt.Errorf("subtitle file not found: %w", err)
+		}
+		g.apply("subtitles=filename=" + filterValue(opts.Path))
+		return nil
+	}
+
+	probe, err := Probe(ctx, req.Input)
+	if err != nil {
+		return err
+	}
+
+	stream, position, err := selectSubtitleStream(probe, opts.Language, opts.Track)
+	if err != nil {
+		return err
+	}
+
+	if bitmapSubtitleCodecs[stream.CodecName] {
+		g.applyWith(fmt.Sprintf("0:s:%d", position), "overlay")
+		return nil
+	}
+
+	g.apply(fmt.Sprintf("subtitles=filename=%s:si=%d", filterValue(req.Input), position))
+	return nil
+}
+
+// selectSubtitleStream picks a subtitle track by its position among the
+// subtitle streams or by language. Among several tracks in the same language
+// the one flagged as default wins. It returns the stream and its position,
+// which is what ffmpeg's 0:s:N specifiers and the subtitles filter expect.
+func selectSubtitleStream(probe *ProbeResult, language string, track *int) (ProbeStream, int, error) {
+	streams := probe.StreamsOfType("subtitle")
+	if len(streams) == 0 {
+		return ProbeStream{}, 0, fmt.Errorf("input has no subtitle tracks")
+	}
+
+	if track != nil {
+		if *track < 0 || *track >= len(streams) {
+			return ProbeStream{}, 0, fmt.Errorf("subtitle track %d out of range (input has %d)", *track, len(streams))
+		}
+		return streams[*track], *track, nil
+	}
+
+	selected := -1
+	for i, s := range streams {
+		if language != "" && !matchesLanguage(s.Tags["language"], language) {
+			continue
+		}
+		if selected == -1 || (s.Disposition["default"] == 1 && streams[selected].Disposition["default"] == 0) {
+			selected = i
+		}
+	}
+
+	if selected == -1 {
+		return ProbeStream{}, 0, fmt.Errorf("no subtitle track in language %q", language)
+	}
+	return streams[selected], selected, nil
+}
+
+// matchesLanguage compares a stream language tag (usually ISO 639-2, such as
+// "eng") with the requested language, which may also be a two letter prefix.
+func matchesLanguage(tag, language string) bool {
+	tag, language = strings.ToLower(tag), strings.ToLower(language)
+	return tag == language || (len(language) == 2 && strings.HasPrefix(tag, language))
+}
+
+func subtitleEncoder(opts *models.SubtitleOptions) (struct{ codec, muxer string }, error) {
+	format := SubtitleFormat(opts)
+	encoder, ok := subtitleEncoders[format]
+	if !ok {
+		return encoder, fmt.Errorf("unsupported subtitle output format: %s", format)
+	}
+	return encoder, nil
+}
+
+// SubtitleFormat returns the file format of a "subtitles" output.
+func SubtitleFormat(opts *models.SubtitleOptions) string {
+	if opts == nil || opts.Format == "" {
+		return defaultSubtitleFormat
+	}
+	return strings.ToLower(opts.Format)
+}
@@ -0,0 +1,60 @@
+package converter
+
+import "testing"
+
+func TestSelectSubtitleStream(t *testing.T) {
+	probe := &ProbeResult{Streams: []ProbeStream{
+		{Index: 0, CodecType: "video"},
+		{Index: 1, CodecType: "subtitle", Tags: map[string]string{"language": "eng"}},
+		{Index: 2, CodecType: "subtitle", Tags: map[string]string{"language": "por"}},
+		{Index: 3, CodecType: "subtitle", Tags: map[string]string{"language": "eng"}, Disposition: map[string]int{"default": 1}},
+		{Index: 4, CodecType: "audio"},
+		{Index: 5, CodecType: "subtitle", Tags: map[string]string{"language": "spa"}},
+	}}
+	track := func(n int) *int { return &n }
+
+	tests := []struct {
+		name      string
+		language  string
+		track     *int
+		wantIndex int
+		wantPos   int
+	}{
+		{name: "default track", wantIndex: 3, wantPos: 2},
+		{name: "language", language: "por", wantIndex: 2, wantPos: 1},
+		{name: "default among a language", language: "eng", wantIndex: 3, wantPos: 2},
+		{name: "two letter language", language: "EN", wantIndex: 3, wantPos: 2},
+		{name: "first in a language", language: "spa", wantIndex: 5, wantPos: 3},
+		{name: "track", track: track(0), wantIndex: 1, wantPos: 0},
+		{name: "track over language", language: "por", track: track(3), wantIndex: 5, wantPos: 3},
+	}
+	for _, tt := range tests {
+		stream, pos, err := selectSubtitleStream(probe, tt.language, tt.track)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if stream.Index != tt.wantIndex || pos != tt.wantPos {
+			t.Errorf("%s: selected stream %d at %d, want %d at %d", tt.name, stream.Index, pos, tt.wantIndex, tt.wantPos)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		language string
+		track    *int
+	}{
+		{name: "track out of range", track: track(4)},
+		{name: "negative track", track: track(-1)},
+		{name: "missing language", language: "fre"},
+	} {
+		if _, _, err := selectSubtitleStream(probe, tt.language, tt.track); err == nil {
+			t.Errorf("%s: selectSubtitleStream succeeded", tt.name)
+		}
+	}
+
+	noSubtitles := &ProbeResult{Streams: []ProbeStream{{CodecType: "video"}, {CodecType: "audio"}}}
+	if _, _, err := selectSubtitleStream(noSubtitles, "", nil); err == nil {
+		t.Error("selectSubtitleStream succeeded without subtitle tracks")
+	}
+}
@@ -0,0 +1,177 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/pkg/logger"
+)
+
+const (
+	// muxOverhead reserves part of the size budget for container overhead.
+	muxOverhead     = 0.97
+	maxSizeAttempts = 3
+	minVideoBitrate = 64_000
+	minAudioBitrate = 8_000
+	maxAudioBitrate = 320_000
+)
+
+// losslessFormats have no bitrate control, so they cannot target a size.
+var losslessFormats = map[string]bool{
+	"wav":  true,
+	"flac": true,
+}
+
+// sizeBudget returns the total bitrate, in bits per second, that fits the
+// whole output duration into the job's max_size. A speed change shortens or
+// lengthens the output, so the budget follows it.
+func sizeBudget(ctx context.Context, input string, opts models.JobOptions) (int64, *ProbeResult, error) {
+	probe, err := Probe(ctx, input)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	duration := probe.Duration()
+	if duration <= 0 {
+		return 0, nil, fmt.Errorf("cannot target an output size: input duration is unknown")
+	}
+	if t := opts.Transform; t != nil && t.Speed > 0 {
+		duration /= t.Speed
+	}
+
+	return int64(float64(opts.MaxSize) * 8 * muxOverhead / duration), probe, nil
+}
+
+// audioShare is the audio bitrate set aside from a total budget; small
+// budgets get a lower audio bitrate so most of it stays with the video.
+func audioShare(total int64) int64 {
+	switch {
+	case total >= 1_000_000:
+		return 128_000
+	case total >= 400_000:
+		return 96_000
+	default:
+		return 48_000
+	}
+}
+
+// encodeToSize runs a two-pass encode at the bitrate that fits the requested
+// size. Encoders don't hit the target exactly, so when the result is still
+// too large the bitrate is lowered in proportion and the encode retried.
+func (c *VideoConverter) encodeToSize(ctx context.Context, req Request, container videoContainer, inputArgs, mapArgs, metaArgs []string) error {
+	total, probe, err := sizeBudget(ctx, req.Input, req.Options)
+	if err != nil {
+		return err
+	}
+
+	var audioBitrate int64
+	if probe.HasStream("audio") {
+		audioBitrate = audioShare(total)
+	}
+	videoBitrate := total - audioBitrate
+
+	workspace, err := os.MkdirTemp(req.Workspace, "twopass_*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(workspace)
+	passLog := filepath.Join(workspace, "pass")
+
+	for attempt := 1; ; attempt++ {
+		if videoBitrate < minVideoBitrate {
+			return fmt.Errorf("max_size of %d bytes is too small for a %.0fs video", req.Options.MaxSize, probe.Duration())
+		}
+
+		rateArgs := []string{
+			"-c:v", container.videoCodec,
+			"-pix_fmt", "yuv420p",
+			"-b:v", fmt.Sprint(videoBitrate),
+			"-maxrate", fmt.Sprint(videoBitrate * 3 / 2),
+			"-bufsize", fmt.Sprint(videoBitrate * 2),
+			"-passlogfile", passLog,
+		}
+
+		pass1 := append(append([]string{}, inputArgs...), mapArgs...)
+		pass1 = append(pass1, rateArgs...)
+		pass1 = append(pass1, "-pass", "1", "-an", "-f", "null", os.DevNull)
+
+		if err := exec.CommandContext(ctx, "ffmpeg", pass1...).Run(); err != nil {
+			return fmt.Errorf("first encoding pass failed: %w", err)
+		}
+
+		pass2 := append(append([]string{}, inputArgs...), mapArgs...)
+		pass2 = append(pass2, rateArgs...)
+		pass2 = append(pass2, "-pass", "2", "-c:a", container.audioCodec)
+		if audioBitrate > 0 {
+			pass2 = append(pass2, "-b:a", fmt.Sprint(audioBitrate))
+		}
+		pass2 = append(pass2, container.extra...)
+		pass2 = append(pass2, metaArgs...)
+		pass2 = append(pass2, "-f", container.muxer)
+
+		if err := runFFmpeg(ctx, req, pass2, req.Output); err != nil {
+			return fmt.Errorf("second encoding pass failed: %w", err)
+		}
+
+		fits, size, err := fitsSize(req, req.Options.MaxSize)
+		if err != nil || fits {
+			return err
+		}
+		if attempt == maxSizeAttempts {
+			return fmt.Errorf("output is %d bytes after %d attempts, above max_size of %d bytes", size, attempt, req.Options.MaxSize)
+		}
+
+		next := int64(float64(videoBitrate) * float64(req.Options.MaxSize) / float64(size) * 0.95)
+		logger.Debug("Output is %d bytes, above max_size of %d; retrying at %d b/s video", size, req.Options.MaxSize, next)
+		videoBitrate = next
+	}
+}
+
+// encodeAudioToSize encodes audio at the bitrate that fits the requested
+// size, lowering it and retrying while the output is still too large. args
+// holds everything but the bitrate and the output path.
+func encodeAudioToSize(ctx context.Context, req Request, args []string) error {
+	if losslessFormats[req.Format] {
+		return fmt.Errorf("max_size is not supported for lossless %s output", req.Format)
+	}
+
+	total, probe, err := sizeBudget(ctx, req.Input, req.Options)
+	if err != nil {
+		return err
+	}
+	bitrate := min(total, maxAudioBitrate)
+
+	for attempt := 1; ; attempt++ {
+		if bitrate < minAudioBitrate {
+			return fmt.Errorf("max_size of %d bytes is too small for %.0fs of audio", req.Options.MaxSize, probe.Duration())
+		}
+
+		cmdArgs := append(append([]string{}, args...), "-b:a", fmt.Sprint(bitrate))
+		if err := runFFmpeg(ctx, req, cmdArgs, req.Output); err != nil {
+			return fmt.Errorf("ffmpeg conversion failed: %w", err)
+		}
+
+		fits, size, err := fitsSize(req, req.Options.MaxSize)
+		if err != nil || fits {
+			return err
+		}
+		if attempt == maxSizeAttempts {
+			return fmt.Errorf("output is %d bytes after %d attempts, above max_size of %d bytes", size, attempt, req.Options.MaxSize)
+		}
+
+		bitrate = int64(float64(bitrate) * float64(req.Options.MaxSize) / float64(size) * 0.95)
+	}
+}
+
+// fitsSize compares the plaintext size of the output with maxSize.
+func fitsSize(req Request, maxSize models.ByteSize) (bool, int64, error) {
+	size, err := outputSize(req, req.Output)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to stat output: %w", err)
+	}
+	return size <= int64(maxSize), size, nil
+}
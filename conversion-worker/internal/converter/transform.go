@@ -0,0 +1,222 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+const (
+	minSpeed = 0.25
+	maxSpeed = 4.0
+	maxFPS   = 120
+
+	// reverse buffers every frame in memory, so it is limited to short clips.
+	maxReverseDuration = 60
+)
+
+// videoTransform is a validated set of transforms for one input. Geometry
+// (crop, rotation, flip) is applied before overlays and subtitles so they
+// are placed on the final frame; timing (reverse, speed, frame rate) is
+// applied after them so their start and end times refer to the source.
+type videoTransform struct {
+	opts     models.TransformOptions
+	crop     string
+	hasAudio bool
+}
+
+// checkTransformTarget rejects transforms on targets that would ignore them:
+// only video containers are encoded through the transform filters. Checked
+// before anything else, so the duration a transform implies is never
+// assumed for an output that will not have it.
+func checkTransformTarget(req Request) error {
+	if req.Options.Transform == nil || TransformApplies(req.Format) {
+		return nil
+	}
+	return fmt.Errorf("transform is not supported for %s output", req.Format)
+}
+
+// TransformApplies reports whether outputs in format have the job's
+// transforms applied.
+func TransformApplies(format string) bool {
+	_, ok := videoContainers[format]
+	return ok
+}
+
+// newVideoTransform checks the job's transforms against the input up front,
+// so an invalid combination fails before any encoding starts. It returns nil
+// when the job has no transforms.
+func newVideoTransform(ctx context.Context, req Request) (*videoTransform, error) {
+	if req.Options.Transform == nil {
+		return nil, nil
+	}
+	opts := *req.Options.Transform
+
+	probe, err := Probe(ctx, req.Input)
+	if err != nil {
+		return nil, err
+	}
+	video := probe.FirstVideo()
+	if video == nil || video.Width == 0 || video.Height == 0 {
+		return nil, fmt.Errorf("cannot transform: input has no video stream")
+	}
+
+	opts.Rotate = (opts.Rotate%360 + 360) % 360
+	if opts.Rotate%90 != 0 {
+		return nil, fmt.Errorf("rotate must be a multiple of 90 degrees")
+	}
+	if opts.Flip != "" && opts.Flip != "horizontal" && opts.Flip != "vertical" {
+		return nil, fmt.Errorf("flip must be horizontal or vertical")
+	}
+	if opts.Speed != 0 && (opts.Speed < minSpeed || opts.Speed > maxSpeed) {
+		return nil, fmt.Errorf("speed must be between %g and %g", minSpeed, maxSpeed)
+	}
+	if opts.FPS < 0 || opts.FPS > maxFPS {
+		return nil, fmt.Errorf("fps must be between 0 and %d", maxFPS)
+	}
+	if opts.Reverse {
+		if duration := probe.Duration(); duration <= 0 || duration > maxReverseDuration {
+			return nil, fmt.Errorf("reverse is limited to inputs of up to %d seconds", maxReverseDuration)
+		}
+	}
+
+	t := &videoTransform{opts: opts, hasAudio: probe.HasStream("audio") && !opts.Mute}
+
+	if opts.Crop != nil {
+		width, height := video.DisplaySize()
+		if t.crop, err = cropFilter(opts.Crop, width, height); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// addGeometry appends the crop, rotation and flip filters.
+func (t *videoTransform) addGeometry(g *filterGraph) {
+	if t == nil {
+		return
+	}
+
+	if t.crop != "" {
+		g.apply(t.crop)
+	}
+
+	switch t.opts.Rotate {
+	case 90:
+		g.apply("transpose=clock")
+	case 180:
+		g.apply("hflip,vflip")
+	case 270:
+		g.apply("transpose=cclock")
+	}
+
+	switch t.opts.Flip {
+	case "horizontal":
+		g.apply("hflip")
+	case "vertical":
+		g.apply("vflip")
+	}
+}
+
+// addTiming appends reverse, speed and frame rate changes, keeping the audio
+// in sync with the video.
+func (t *videoTransform) addTiming(g *filterGraph) {
+	if t == nil {
+		return
+	}
+
+	if t.opts.Reverse {
+		g.apply("reverse")
+		if t.hasAudio {
+			g.applyAudio("areverse")
+		}
+	}
+
+	if t.opts.Speed != 0 && t.opts.Speed != 1 {
+		g.apply(fmt.Sprintf("setpts=PTS/%g", t.opts.Speed))
+		if t.hasAudio {
+			g.applyAudio(atempoChain(t.opts.Speed))
+		}
+	}
+
+	if t.opts.FPS > 0 {
+		g.apply(fmt.Sprintf("fps=%g", t.opts.FPS))
+	}
+}
+
+func (t *videoTransform) muted() bool {
+	return t != nil && t.opts.Mute
+}
+
+// cropFilter validates a crop against the source frame and returns the crop
+// filter. Sizes are rounded down to even numbers for chroma subsampling.
+func cropFilter(crop *models.CropOptions, frameW, frameH int) (string, error) {
+	if crop.Aspect != "" {
+		if crop.Width != 0 || crop.Height != 0 || crop.X != nil || crop.Y != nil {
+			return "", fmt.Errorf("crop takes either an aspect ratio or a rectangle, not both")
+		}
+
+		num, den, err := parseAspect(crop.Aspect)
+		if err != nil {
+			return "", err
+		}
+		width := min(frameW, int(float64(frameH)*num/den)) / 2 * 2
+		height := min(frameH, int(float64(frameW)*den/num)) / 2 * 2
+		if width < 2 || height < 2 {
+			return "", fmt.Errorf("crop aspect %s leaves an empty frame", crop.Aspect)
+		}
+		return fmt.Sprintf("crop=%d:%d", width, height), nil
+	}
+
+	if crop.Width <= 0 || crop.Height <= 0 {
+		return "", fmt.Errorf("crop needs a positive width and height, or an aspect ratio")
+	}
+	width, height := crop.Width/2*2, crop.Height/2*2
+
+	x, y := (frameW-width)/2, (frameH-height)/2
+	if crop.X != nil {
+		x = *crop.X
+	}
+	if crop.Y != nil {
+		y = *crop.Y
+	}
+	if x < 0 || y < 0 || x+width > frameW || y+height > frameH || width < 2 || height < 2 {
+		return "", fmt.Errorf("crop %dx%d at %d,%d does not fit the %dx%d frame", crop.Width, crop.Height, x, y, frameW, frameH)
+	}
+
+	return fmt.Sprintf("crop=%d:%d:%d:%d", width, height, x, y), nil
+}
+
+func parseAspect(aspect string) (float64, float64, error) {
+	w, h, found := strings.Cut(aspect, ":")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid crop aspect %q, expected W:H", aspect)
+	}
+	num, err1 := strconv.ParseFloat(w, 64)
+	den, err2 := strconv.ParseFloat(h, 64)
+	if err1 != nil || err2 != nil || num <= 0 || den <= 0 {
+		return 0, 0, fmt.Errorf("invalid crop aspect %q, expected W:H", aspect)
+	}
+	return num, den, nil
+}
+
+// atempoChain changes the audio tempo without changing its pitch. Each
+// atempo filter is kept within 0.5 and 2, the range every ffmpeg version
+// accepts, by chaining several for larger factors.
+func atempoChain(speed float64) string {
+	var filters []string
+	for speed > 2 {
+		filters = append(filters, "atempo=2")
+		speed /= 2
+	}
+	for speed < 0.5 {
+		filters = append(filters, "atempo=0.5")
+		speed /= 0.5
+	}
+	filters = append(filters, fmt.Sprintf("atempo=%g", speed))
+	return strings.Join(filters, ",")
+}
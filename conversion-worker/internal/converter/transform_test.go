@@ -0,0 +1,73 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+func intPtr(v int) *int { return &v }
+
+func TestCropFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		crop models.CropOptions
+		want string
+	}{
+		{name: "square aspect", crop: models.CropOptions{Aspect: "1:1"}, want: "crop=1080:1080"},
+		{name: "portrait aspect", crop: models.CropOptions{Aspect: "9:16"}, want: "crop=606:1080"},
+		{name: "same aspect", crop: models.CropOptions{Aspect: "16:9"}, want: "crop=1920:1080"},
+		{name: "centered rectangle", crop: models.CropOptions{Width: 641, Height: 361}, want: "crop=640:360:640:360"},
+		{name: "placed rectangle", crop: models.CropOptions{Width: 640, Height: 360, X: intPtr(0), Y: intPtr(720)}, want: "crop=640:360:0:720"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cropFilter(&tt.crop, 1920, 1080)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("cropFilter = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCropFilterInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		crop models.CropOptions
+	}{
+		{name: "aspect and rectangle", crop: models.CropOptions{Aspect: "1:1", Width: 100}},
+		{name: "malformed aspect", crop: models.CropOptions{Aspect: "16x9"}},
+		{name: "zero aspect", crop: models.CropOptions{Aspect: "0:9"}},
+		{name: "empty aspect frame", crop: models.CropOptions{Aspect: "1:2000"}},
+		{name: "no size", crop: models.CropOptions{Height: 100}},
+		{name: "outside the frame", crop: models.CropOptions{Width: 640, Height: 360, X: intPtr(1500)}},
+		{name: "negative position", crop: models.CropOptions{Width: 640, Height: 360, Y: intPtr(-1)}},
+		{name: "too small", crop: models.CropOptions{Width: 1, Height: 100}},
+	}
+	for _, tt := range tests {
+		if _, err := cropFilter(&tt.crop, 1920, 1080); err == nil {
+			t.Errorf("%s: cropFilter succeeded", tt.name)
+		}
+	}
+}
+
+func TestAtempoChain(t *testing.T) {
+	tests := map[float64]string{
+		1:    "atempo=1",
+		1.5:  "atempo=1.5",
+		2:    "atempo=2",
+		0.5:  "atempo=0.5",
+		3:    "atempo=2,atempo=1.5",
+		8:    "atempo=2,atempo=2,atempo=2",
+		0.25: "atempo=0.5,atempo=0.5",
+		0.3:  "atempo=0.5,atempo=0.6",
+	}
+	for speed, want := range tests {
+		if got := atempoChain(speed); got != want {
+			t.Errorf("atempoChain(%g) = %q, want %q", speed, got, want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package converter
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// Outputs may differ from the input duration by this fraction, or by
+	// minDurationSlack seconds for short media, before verification fails.
+	durationTolerance = 0.05
+	minDurationSlack  = 1.0
+)
+
+var imageExtensions = map[string]bool{
+	"png": true, "jpg": true, "jpeg": true, "webp": true, "gif": true,
+	"bmp": true, "avif": true, "tiff": true, "tif": true, "ico": true,
+}
+
+var textExtensions = map[string]bool{
+	"srt": true, "vtt": true, "ass": true, "json": true, "svg": true,
+}
+
+// VerificationError reports an output that ffmpeg wrote without an error
+// but that failed a post-conversion check, such as an empty or truncated
+// file.
+type VerificationError struct {
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return "output verification failed: " + e.Reason
+}
+
+func verificationErrorf(format string, args ...any) error {
+	return &VerificationError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// OutputInfo describes a verified output.
+type OutputInfo struct {
+	Size     int64
+	Checksum string
+}
+
+// VerifyOutput checks that the output of req exists, is not empty and is
+// readable for its kind: media files are probed for the expected streams and
+// duration, archives must list their entries and JSON must parse. It
+// returns the size and SHA-256 checksum of the output; for encrypted
+// outputs both are of the plaintext.
+func VerifyOutput(ctx context.Context, req Request) (*OutputInfo, error) {
+	file, err := openOutput(req, req.Output)
+	if err != nil {
+		return nil, verificationErrorf("output is missing: %v", err)
+	}
+	defer file.Close()
+	if file.Size() == 0 {
+		return nil, verificationErrorf("output is empty")
+	}
+
+	switch ext := OutputExtension(req.Format, req.Options); {
+	case ext == "zip":
+		err = verifyArchive(file)
+	case ext == "json":
+		err = verifyJSON(file)
+	case textExtensions[ext]:
+	case imageExtensions[ext]:
+		err = verifyImage(ctx, req)
+	default:
+		err = verifyMedia(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutputInfo{Size: file.Size(), Checksum: checksum}, nil
+}
+
+func verifyArchive(file SealedFile) error {
+	reader, err := zip.NewReader(file, file.Size())
+	if err != nil {
+		return verificationErrorf("output is not a valid ZIP: %v", err)
+	}
+
+	if len(reader.File) == 0 {
+		return verificationErrorf("output ZIP is empty")
+	}
+	return nil
+}
+
+func verifyJSON(file SealedFile) error {
+	content, err := io.ReadAll(outputReader(file))
+	if err != nil {
+		return fmt.Errorf("failed to read output: %w", err)
+	}
+	if !json.Valid(content) {
+		return verificationErrorf("output is not valid JSON")
+	}
+	return nil
+}
+
+func verifyImage(ctx context.Context, req Request) error {
+	output, err := readableOutput(req, req.Output)
+	if err != nil {
+		return err
+	}
+	probe, err := Probe(ctx, output)
+	if err != nil {
+		return verificationErrorf("output cannot be read: %v", err)
+	}
+	if probe.FirstVideo() == nil {
+		return verificationErrorf("output has no image stream")
+	}
+	return nil
+}
+
+// verifyMedia probes an audio or video output for the streams its target
+// must carry and, for single-input jobs, compares its duration with the
+// duration the input should have produced.
+func verifyMedia(ctx context.Context, req Request) error {
+	path, err := readableOutput(req, req.Output)
+	if err != nil {
+		return err
+	}
+	output, err := Probe(ctx, path)
+	if err != nil {
+		return verificationErrorf("output cannot be read: %v", err)
+	}
+
+	_, video := videoContainers[req.Format]
+	if video && output.FirstVideo() == nil {
+		return verificationErrorf("output has no video stream")
+	}
+
+	if req.Options.Compose != nil {
+		if !video && !output.HasStream("audio") {
+			return verificationErrorf("output has no audio stream")
+		}
+		return nil
+	}
+
+	input, err := Probe(ctx, req.Input)
+	if err != nil {
+		return err
+	}
+
+	muted := req.Options.Transform != nil && req.Options.Transform.Mute
+	wantAudio := !video || (input.HasStream("audio") && !muted)
+	if wantAudio && !output.HasStream("audio") {
+		return verificationErrorf("output has no audio stream")
+	}
+
+	expected := input.Duration()
+	if t := req.Options.Transform; t != nil && t.Speed > 0 {
+		expected /= t.Speed
+	}
+	actual := output.Duration()
+	if actual <= 0 && expected > 0 {
+		// Muxers that wrote to a pipe could not go back to record the
+		// duration, so it is measured from the packets instead.
+		if actual, err = scanDuration(ctx, path, video); err != nil {
+			return verificationErrorf("output cannot be read: %v", err)
+		}
+	}
+	if expected > 0 && actual > 0 {
+		slack := math.Max(minDurationSlack, expected*durationTolerance)
+		if math.Abs(actual-expected) > slack {
+			return verificationErrorf("output lasts %.2fs, expected about %.2fs", actual, expected)
+		}
+	} else if expected > 0 {
+		return verificationErrorf("output duration is unknown")
+	}
+
+	return nil
+}
+
+func fileChecksum(file SealedFile) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, outputReader(file)); err != nil {
+		return "", fmt.Errorf("failed to hash output: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
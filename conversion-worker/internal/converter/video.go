@@ -1,13 +1,11 @@
 package converter
 
 import (
-	"archive/zip"
 	"context"
 	"fmt"
-	"io"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"strconv"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
 )
 
 type VideoConverter struct {
@@ -15,118 +13,246 @@ type VideoConverter struct {
 }
 
 func (c *VideoConverter) SupportedFormats() []string {
-	return []string{"mp4", "avi", "mkv", "mp3", "wav", "mov", "flv", "wmv", "gif", "images"}
+	return []string{"mp4", "avi", "mkv", "mov", "flv", "wmv", "webm", "ogv", "mp4-hevc", "mp4-av1",
+		"mp3", "wav", "m4a", "opus", "gif", "webp", "images", "subtitles", "sprites", "contactsheet", "waveform", "spectrogram"}
+}
+
+// videoContainer is the codec matrix entry of a video target. quality holds
+// the constant-quality settings, which are left out when encoding to a
+// target size; extra is always passed.
+type videoContainer struct {
+	videoCodec string
+	audioCodec string
+	muxer      string
+	quality    []string
+	extra      []string
+}
+
+// av1Codec stands for the best AV1 encoder of the local ffmpeg build.
+const av1Codec = "av1"
+
+// Defaults of animated WebP outputs of video inputs.
+const (
+	defaultAnimatedWebPWidth   = 480
+	defaultAnimatedWebPFPS     = 15
+	defaultAnimatedWebPQuality = 70
+)
+
+var videoContainers = map[string]videoContainer{
+	"mp4":      {videoCodec: "libx264", audioCodec: "aac", muxer: "mp4"},
+	"avi":      {videoCodec: "libx264", audioCodec: "libmp3lame", muxer: "avi"},
+	"mkv":      {videoCodec: "libx264", audioCodec: "aac", muxer: "matroska"},
+	"mov":      {videoCodec: "libx264", audioCodec: "aac", muxer: "mov"},
+	"flv":      {videoCodec: "libx264", audioCodec: "aac", muxer: "flv"},
+	"wmv":      {videoCodec: "wmv2", audioCodec: "wmav2", muxer: "asf", quality: []string{"-q:v", "4"}},
+	"webm":     {videoCodec: "libvpx-vp9", audioCodec: "libopus", muxer: "webm", quality: []string{"-b:v", "0", "-crf", "32"}},
+	"ogv":      {videoCodec: "libtheora", audioCodec: "libvorbis", muxer: "ogg", quality: []string{"-q:v", "7"}},
+	"mp4-hevc": {videoCodec: "libx265", audioCodec: "aac", muxer: "mp4", quality: []string{"-crf", "28"}, extra: []string{"-tag:v", "hvc1"}},
+	"mp4-av1":  {videoCodec: av1Codec, audioCodec: "aac", muxer: "mp4"},
+}
+
+// resolveContainer returns the codec matrix entry of a video target, with
+// the AV1 encoder resolved, after checking the ffmpeg build supports it.
+func resolveContainer(ctx context.Context, format string) (videoContainer, error) {
+	container, ok := videoContainers[format]
+	if !ok {
+		return videoContainer{}, fmt.Errorf("unsupported video format: %s", format)
+	}
+
+	caps, err := requireTarget(ctx, format)
+	if err != nil {
+		return videoContainer{}, err
+	}
+	if container.videoCodec == av1Codec {
+		container.videoCodec = caps.AV1Encoder()
+	}
+
+	return container, nil
+}
+
+// codecArgs returns the encoder and muxer arguments. yuv420p is forced
+// because most players cannot decode anything else.
+func (v videoContainer) codecArgs(withQuality bool) []string {
+	args := []string{"-c:v", v.videoCodec}
+	if withQuality {
+		args = append(args, v.quality...)
+	}
+	args = append(args, "-pix_fmt", "yuv420p", "-c:a", v.audioCodec)
+	args = append(args, v.extra...)
+	return append(args, "-f", v.muxer)
 }
 
-func (c *VideoConverter) Convert(ctx context.Context, input, format, output string) error {
-	if err := c.validatePaths(input, output); err != nil {
+func (c *VideoConverter) Convert(ctx context.Context, req Request) error {
+	if err := c.validatePaths(req.Input, req.Output); err != nil {
+		return err
+	}
+	if err := checkTransformTarget(req); err != nil {
 		return err
 	}
 
-	var cmd *exec.Cmd
-
-	switch format {
-	case "mp4":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-c:v", "libx264", "-f", "mp4", output)
-	case "avi":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-c:v", "libx264", "-f", "avi", output)
-	case "mkv":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-c:v", "libx264", "-f", "matroska", output)
-	case "mp3":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-vn", "-acodec", "libmp3lame", output)
-	case "wav":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, output)
-	case "mov":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-c:v", "libx264", "-f", "mov", output)
-	case "flv":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-c:v", "libx264", "-f", "flv", output)
-	case "wmv":
-		cmd = exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-c:v", "libx264", "-f", "wmv", output)
+	if _, ok := videoContainers[req.Format]; ok {
+		return c.convertContainer(ctx, req)
+	}
+
+	switch req.Format {
+	case "mp3", "wav", "m4a", "opus":
+		return c.extractAudio(ctx, req)
 	case "gif":
-		return c.convertToGIF(ctx, input, output)
+		return c.convertToGIF(ctx, req)
+	case "webp":
+		return c.convertToAnimatedWebP(ctx, req)
 	case "images":
-		return c.convertToFrames(ctx, input, output)
+		return c.convertToFrames(ctx, req)
+	case "subtitles":
+		return c.extractSubtitles(ctx, req)
+	case "sprites":
+		return c.convertToSprites(ctx, req)
+	case "contactsheet":
+		return c.convertToContactSheet(ctx, req)
+	case "waveform":
+		return renderWaveform(ctx, req)
+	case "spectrogram":
+		return renderSpectrogram(ctx, req)
 	default:
-		return fmt.Errorf("unsupported video format: %s", format)
+		return fmt.Errorf("unsupported video format: %s", req.Format)
 	}
+}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg conversion failed: %w", err)
+func (c *VideoConverter) convertContainer(ctx context.Context, req Request) error {
+	container, err := resolveContainer(ctx, req.Format)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	metaArgs, err := metadataArgs(ctx, req)
+	if err != nil {
+		return err
+	}
 
-func (c *VideoConverter) convertToGIF(ctx context.Context, input, output string) error {
-	paletteCmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input,
-		"-vf", "scale=480:-1:flags=lanczos,fps=15,palettegen=stats_mode=diff",
-		"/tmp/palette.png")
+	if canRemux(req) && c.tryRemux(ctx, req, container, metaArgs) {
+		return nil
+	}
+	req.Metadata.Set("encoding", EncodingTranscode)
 
-	if err := paletteCmd.Run(); err != nil {
-		return fmt.Errorf("failed to generate palette: %w", err)
+	transform, err := newVideoTransform(ctx, req)
+	if err != nil {
+		return err
 	}
 
-	gifCmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input, "-i", "/tmp/palette.png",
-		"-lavfi", "scale=480:-1:flags=lanczos,fps=15,paletteuse=dither=floyd_steinberg",
-		"-loop", "0", output)
+	graph := newFilterGraph(req.Workspace)
+	defer graph.cleanup()
 
-	if err := gifCmd.Run(); err != nil {
-		return fmt.Errorf("failed to generate GIF: %w", err)
+	transform.addGeometry(graph)
+	if err := addOverlays(graph, req); err != nil {
+		return err
+	}
+	if err := addBurnedSubtitles(ctx, graph, req); err != nil {
+		return err
+	}
+	transform.addTiming(graph)
+
+	inputArgs := append([]string{"-y", "-i", req.Input}, graph.inputArgs()...)
+	mapArgs := graph.outputArgs(!transform.muted())
+	if transform.muted() {
+		mapArgs = append(mapArgs, "-an")
+	}
+
+	if req.Options.MaxSize > 0 {
+		return c.encodeToSize(ctx, req, container, inputArgs, mapArgs, metaArgs)
+	}
+
+	ffmpegArgs := append(inputArgs, mapArgs...)
+	ffmpegArgs = append(ffmpegArgs, container.codecArgs(true)...)
+	ffmpegArgs = append(ffmpegArgs, metaArgs...)
+
+	if err := runFFmpeg(ctx, req, ffmpegArgs, req.Output); err != nil {
+		return fmt.Errorf("ffmpeg conversion failed: %w", err)
 	}
 
 	return nil
 }
 
-func (c *VideoConverter) convertToFrames(ctx context.Context, input, output string) error {
-	tempDir, err := os.MkdirTemp("", "frames_*")
+func (c *VideoConverter) extractAudio(ctx context.Context, req Request) error {
+	if _, err := requireTarget(ctx, req.Format); err != nil {
+		return err
+	}
+	codecArgs, err := audioCodecArgs(req.Format)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+		return err
+	}
+
+	metaArgs, err := metadataArgs(ctx, req)
+	if err != nil {
+		return err
 	}
-	defer os.RemoveAll(tempDir)
 
-	outputPattern := filepath.Join(tempDir, "frame_%04d.png")
-	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", input,
-		"-pix_fmt", "rgb24", "-q:v", "1", outputPattern)
+	args := append([]string{"-y", "-i", req.Input, "-vn"}, codecArgs...)
+	args = append(args, metaArgs...)
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to extract frames: %w", err)
+	if req.Options.MaxSize > 0 {
+		return encodeAudioToSize(ctx, req, args)
 	}
 
-	return c.createZIP(tempDir, output)
+	if err := runFFmpeg(ctx, req, args, req.Output); err != nil {
+		return fmt.Errorf("ffmpeg conversion failed: %w", err)
+	}
+
+	return nil
 }
 
-func (c *VideoConverter) createZIP(sourceDir, zipPath string) error {
-	zipFile, err := os.Create(zipPath)
-	if err != nil {
-		return fmt.Errorf("failed to create ZIP file: %w", err)
+func (c *VideoConverter) convertToAnimatedWebP(ctx context.Context, req Request) error {
+	if err := requireFeature(ctx, FeatureAnimatedWebP); err != nil {
+		return err
 	}
-	defer zipFile.Close()
 
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+	opts := models.AnimatedWebPOptions{}
+	if req.Options.AnimatedWebP != nil {
+		opts = *req.Options.AnimatedWebP
+	}
+	if err := validateAnimatedWebPOptions(&opts); err != nil {
+		return err
+	}
 
-	return filepath.Walk(sourceDir, func(filePath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	args := []string{"-y"}
+	if opts.Start > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.3f", opts.Start))
+	}
+	if opts.Duration > 0 {
+		args = append(args, "-t", fmt.Sprintf("%.3f", opts.Duration))
+	}
+	args = append(args, "-i", req.Input,
+		"-vf", fmt.Sprintf("scale=%d:-1:flags=lanczos,fps=%g", opts.Width, opts.FPS),
+		"-c:v", "libwebp_anim", "-loop", strconv.Itoa(opts.Loop), "-q:v", strconv.Itoa(opts.Quality),
+		"-an", "-f", "webp")
 
-		if info.IsDir() {
-			return nil
-		}
+	if err := runFFmpeg(ctx, req, args, req.Output); err != nil {
+		return fmt.Errorf("failed to generate animated WebP: %w", err)
+	}
 
-		file, err := os.Open(filePath)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
+	return nil
+}
 
-		fileName := filepath.Base(filePath)
-		zipEntry, err := zipWriter.Create(fileName)
-		if err != nil {
-			return err
-		}
+// validateAnimatedWebPOptions checks the options and fills in the defaults.
+func validateAnimatedWebPOptions(opts *models.AnimatedWebPOptions) error {
+	if opts.Width < 0 || opts.FPS < 0 || opts.Start < 0 || opts.Duration < 0 || opts.Loop < 0 || opts.Quality < 0 {
+		return fmt.Errorf("animated WebP options cannot be negative")
+	}
+	if opts.FPS > 50 {
+		return fmt.Errorf("animated WebP fps cannot exceed 50")
+	}
+	if opts.Quality > 100 {
+		return fmt.Errorf("animated WebP quality cannot exceed 100")
+	}
 
-		_, err = io.Copy(zipEntry, file)
-		return err
-	})
+	if opts.Width == 0 {
+		opts.Width = defaultAnimatedWebPWidth
+	}
+	opts.Width = max(opts.Width/2*2, 2)
+	if opts.FPS == 0 {
+		opts.FPS = defaultAnimatedWebPFPS
+	}
+	if opts.Quality == 0 {
+		opts.Quality = defaultAnimatedWebPQuality
+	}
+	return nil
 }
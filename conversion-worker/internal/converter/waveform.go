@@ -0,0 +1,397 @@
+package converter
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+const (
+	defaultSamplesPerPixel = 256
+	defaultWaveformBits    = 8
+	defaultWaveformWidth   = 1800
+	defaultWaveformHeight  = 280
+	defaultWaveformColor   = "#3b82f6"
+	defaultWaveformBg      = "#ffffff"
+	defaultSampleRate      = 44100
+
+	defaultSpectrogramWidth  = 1024
+	defaultSpectrogramHeight = 512
+
+	// maxImageSize bounds the width and height of generated images, which
+	// are drawn in memory.
+	maxImageSize = 16384
+	// maxImagePixels bounds the area of waveform and spectrogram images.
+	maxImagePixels = 16 << 20
+
+	// minSamplesPerPixel and maxWaveformPeaks bound the peak data, which is
+	// held in memory: a long input at a small samples_per_pixel would
+	// otherwise produce millions of peaks.
+	minSamplesPerPixel = 32
+	maxWaveformPeaks   = 1 << 21
+)
+
+// waveformData is the JSON peak format of BBC's audiowaveform (version 2):
+// data holds a min/max pair per pixel, each samples_per_pixel samples wide.
+type waveformData struct {
+	Version         int   `json:"version"`
+	Channels        int   `json:"channels"`
+	SampleRate      int   `json:"sample_rate"`
+	SamplesPerPixel int   `json:"samples_per_pixel"`
+	Bits            int   `json:"bits"`
+	Length          int   `json:"length"`
+	Data            []int `json:"data"`
+}
+
+// renderWaveform computes the peaks of the first audio track and writes them
+// as JSON or renders them as a PNG or SVG image.
+func renderWaveform(ctx context.Context, req Request) error {
+	opts := models.WaveformOptions{}
+	if req.Options.Waveform != nil {
+		opts = *req.Options.Waveform
+	}
+
+	format := WaveformFormat(req.Options.Waveform)
+	if format != "json" && format != "png" && format != "svg" {
+		return fmt.Errorf("unsupported waveform format: %s", format)
+	}
+	if opts.SamplesPerPixel < 0 || opts.Width < 0 || opts.Height < 0 {
+		return fmt.Errorf("waveform options cannot be negative")
+	}
+	if opts.Width > maxImageSize || opts.Height > maxImageSize {
+		return fmt.Errorf("waveform size cannot exceed %dx%d", maxImageSize, maxImageSize)
+	}
+	if width, height := waveformSize(opts); format != "json" && width*height > maxImagePixels {
+		return fmt.Errorf("waveform image cannot exceed %d pixels, got %dx%d", maxImagePixels, width, height)
+	}
+	if opts.SamplesPerPixel != 0 && opts.SamplesPerPixel < minSamplesPerPixel {
+		return fmt.Errorf("waveform samples_per_pixel must be at least %d", minSamplesPerPixel)
+	}
+	if opts.Bits != 0 && opts.Bits != 8 && opts.Bits != 16 {
+		return fmt.Errorf("waveform bits must be 8 or 16")
+	}
+
+	samplesPerPixel := opts.SamplesPerPixel
+	if samplesPerPixel == 0 {
+		samplesPerPixel = defaultSamplesPerPixel
+	}
+	bits := opts.Bits
+	if bits == 0 {
+		bits = defaultWaveformBits
+	}
+
+	probe, err := Probe(ctx, req.Input)
+	if err != nil {
+		return err
+	}
+	audio := probe.StreamsOfType("audio")
+	if len(audio) == 0 {
+		return fmt.Errorf("input has no audio stream")
+	}
+	sampleRate, _ := strconv.Atoi(audio[0].SampleRate)
+	if sampleRate == 0 {
+		sampleRate = defaultSampleRate
+	}
+	if pairs := probe.Duration() * float64(sampleRate) / float64(samplesPerPixel); pairs > maxWaveformPeaks {
+		return fmt.Errorf("waveform would have %.0f peaks, more than %d; raise samples_per_pixel to at least %.0f",
+			pairs, maxWaveformPeaks, math.Ceil(float64(samplesPerPixel)*pairs/maxWaveformPeaks))
+	}
+
+	peaks, err := computePeaks(ctx, req.Input, sampleRate, samplesPerPixel)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		return writeWaveformJSON(req, peaks, sampleRate, samplesPerPixel, bits)
+	case "png":
+		return writeWaveformPNG(req, peaks, opts)
+	default:
+		return writeWaveformSVG(req, peaks, opts)
+	}
+}
+
+// computePeaks decodes the first audio track to mono 16 bit PCM and keeps the
+// minimum and maximum sample of every samplesPerPixel-sized block, streaming
+// so memory use depends on the number of blocks only. It fails past
+// maxWaveformPeaks blocks, for inputs whose duration was misreported.
+func computePeaks(ctx context.Context, input string, sampleRate, samplesPerPixel int) ([]int16, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", input, "-map", "0:a:0",
+		"-ac", "1", "-ar", fmt.Sprint(sampleRate), "-c:a", "pcm_s16le", "-f", "s16le", "pipe:1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ffmpeg output: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start audio decoding: %w", err)
+	}
+
+	buf := make([]byte, 1<<16)
+	var peaks []int16
+	var low, high int16
+	count := 0
+
+	for {
+		n, err := io.ReadFull(stdout, buf)
+		for i := 0; i+1 < n; i += 2 {
+			sample := int16(binary.LittleEndian.Uint16(buf[i:]))
+			if count == 0 || sample < low {
+				low = sample
+			}
+			if count == 0 || sample > high {
+				high = sample
+			}
+			count++
+
+			if count == samplesPerPixel {
+				peaks = append(peaks, low, high)
+				count = 0
+			}
+		}
+		if len(peaks)/2 > maxWaveformPeaks {
+			cancel()
+			cmd.Wait()
+			return nil, fmt.Errorf("waveform has more than %d peaks; raise samples_per_pixel", maxWaveformPeaks)
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			cancel()
+			cmd.Wait()
+			return nil, fmt.Errorf("failed to read decoded audio: %w", err)
+		}
+	}
+	if count > 0 {
+		peaks = append(peaks, low, high)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+	if len(peaks) == 0 {
+		return nil, fmt.Errorf("audio track has no samples")
+	}
+	return peaks, nil
+}
+
+func writeWaveformJSON(req Request, peaks []int16, sampleRate, samplesPerPixel, bits int) error {
+	data := make([]int, len(peaks))
+	for i, peak := range peaks {
+		if bits == 8 {
+			data[i] = int(peak >> 8)
+		} else {
+			data[i] = int(peak)
+		}
+	}
+
+	content, err := json.Marshal(waveformData{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      sampleRate,
+		SamplesPerPixel: samplesPerPixel,
+		Bits:            bits,
+		Length:          len(peaks) / 2,
+		Data:            data,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeOutput(req, req.Output, content); err != nil {
+		return fmt.Errorf("failed to write waveform data: %w", err)
+	}
+	return nil
+}
+
+// waveformColumns resamples the peaks to one min/max pair per image column,
+// normalised to [-1, 1].
+func waveformColumns(peaks []int16, width int) [][2]float64 {
+	pairs := len(peaks) / 2
+	columns := make([][2]float64, width)
+
+	for x := 0; x < width; x++ {
+		from := x * pairs / width
+		to := max((x+1)*pairs/width, from+1)
+
+		low, high := int16(0), int16(0)
+		for i := from; i < to && i < pairs; i++ {
+			low = min(low, peaks[2*i])
+			high = max(high, peaks[2*i+1])
+		}
+		columns[x] = [2]float64{float64(low) / 32768, float64(high) / 32768}
+	}
+
+	return columns
+}
+
+func writeWaveformPNG(req Request, peaks []int16, opts models.WaveformOptions) error {
+	width, height := waveformSize(opts)
+	fg, err := parseHexColor(defaultString(opts.Color, defaultWaveformColor))
+	if err != nil {
+		return err
+	}
+	bg, err := parseHexColor(defaultString(opts.Background, defaultWaveformBg))
+	if err != nil {
+		return err
+	}
+
+	// A two-colour palette keeps the image at a byte per pixel; every pixel
+	// starts as index 0, the background, and columns are written directly.
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{bg, fg})
+	mid := float64(height) / 2
+	for x, column := range waveformColumns(peaks, width) {
+		top := int(mid - column[1]*mid)
+		bottom := int(mid - column[0]*mid)
+		for y := max(top, 0); y <= min(bottom, height-1); y++ {
+			img.Pix[y*img.Stride+x] = 1
+		}
+	}
+
+	file, err := createOutput(req, req.Output)
+	if err != nil {
+		return fmt.Errorf("failed to create waveform image: %w", err)
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to encode waveform image: %w", err)
+	}
+	return file.Close()
+}
+
+func writeWaveformSVG(req Request, peaks []int16, opts models.WaveformOptions) error {
+	width, height := waveformSize(opts)
+	fg, err := parseHexColor(defaultString(opts.Color, defaultWaveformColor))
+	if err != nil {
+		return err
+	}
+	bg, err := parseHexColor(defaultString(opts.Background, defaultWaveformBg))
+	if err != nil {
+		return err
+	}
+
+	var path strings.Builder
+	mid := float64(height) / 2
+	for x, column := range waveformColumns(peaks, width) {
+		top := mid - column[1]*mid
+		bottom := mid - column[0]*mid
+		fmt.Fprintf(&path, "M%d.5 %.1fV%.1f", x, top, max(bottom, top+1))
+	}
+
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+
+		`<rect width="100%%" height="100%%" fill="%s"/>`+
+		`<path d="%s" stroke="%s" stroke-width="1" fill="none"/></svg>`,
+		width, height, width, height, hexColor(bg), path.String(), hexColor(fg))
+
+	if err := writeOutput(req, req.Output, []byte(svg)); err != nil {
+		return fmt.Errorf("failed to write waveform image: %w", err)
+	}
+	return nil
+}
+
+// renderSpectrogram draws the spectrogram of the first audio track.
+func renderSpectrogram(ctx context.Context, req Request) error {
+	opts := models.SpectrogramOptions{}
+	if req.Options.Spectrogram != nil {
+		opts = *req.Options.Spectrogram
+	}
+	if opts.Width < 0 || opts.Height < 0 {
+		return fmt.Errorf("spectrogram size cannot be negative")
+	}
+	if opts.Width > maxImageSize || opts.Height > maxImageSize {
+		return fmt.Errorf("spectrogram size cannot exceed %dx%d", maxImageSize, maxImageSize)
+	}
+
+	width := opts.Width
+	if width == 0 {
+		width = defaultSpectrogramWidth
+	}
+	height := opts.Height
+	if height == 0 {
+		height = defaultSpectrogramHeight
+	}
+	if width*height > maxImagePixels {
+		return fmt.Errorf("spectrogram cannot exceed %d pixels, got %dx%d", maxImagePixels, width, height)
+	}
+	legend := 1
+	if opts.Legend != nil && !*opts.Legend {
+		legend = 0
+	}
+
+	params := []string{fmt.Sprintf("s=%dx%d", width, height), fmt.Sprintf("legend=%d", legend)}
+	if opts.Color != "" {
+		params = append(params, "color="+filterValue(opts.Color))
+	}
+	if opts.Scale != "" {
+		params = append(params, "scale="+filterValue(opts.Scale))
+	}
+
+	args := []string{"-y", "-i", req.Input, "-map", "0:a:0",
+		"-lavfi", "showspectrumpic=" + strings.Join(params, ":"),
+		"-frames:v", "1", "-c:v", "png", "-f", "image2"}
+
+	if err := runFFmpeg(ctx, req, args, req.Output); err != nil {
+		return fmt.Errorf("failed to generate spectrogram: %w", err)
+	}
+
+	return nil
+}
+
+// WaveformFormat returns the file format of a "waveform" output.
+func WaveformFormat(opts *models.WaveformOptions) string {
+	if opts == nil || opts.Format == "" {
+		return "json"
+	}
+	return strings.ToLower(opts.Format)
+}
+
+func waveformSize(opts models.WaveformOptions) (int, int) {
+	width, height := opts.Width, opts.Height
+	if width == 0 {
+		width = defaultWaveformWidth
+	}
+	if height == 0 {
+		height = defaultWaveformHeight
+	}
+	return width, height
+}
+
+func parseHexColor(value string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, expected #rrggbb", value)
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, expected #rrggbb", value)
+	}
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}, nil
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+// AcquireCachedOutput returns the live cache entry for key and takes a
+// reference to it, extending its expiry to at least ttl from now. It returns
+// nil without an error on a miss.
+func (r *PostgresRepository) AcquireCachedOutput(ctx context.Context, key string, ttl time.Duration) (*models.CachedOutput, error) {
+	query := `SELECT output_path, output_size, output_checksum, metadata
+		FROM public.acquire_cached_conversion($1::varchar, $2::integer)`
+
+	var size sql.NullInt64
+	var checksum sql.NullString
+	var metadata []byte
+	entry := models.CachedOutput{Key: key}
+	err := r.db.QueryRowContext(ctx, query, key, int(ttl.Seconds())).Scan(
+		&entry.Output,
+		&size,
+		&checksum,
+		&metadata,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up cache entry %s: %w", key, err)
+	}
+
+	entry.Size = size.Int64
+	entry.Checksum = checksum.String
+	if err := json.Unmarshal(metadata, &entry.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode cache entry %s: %w", key, err)
+	}
+	return &entry, nil
+}
+
+// StoreCachedOutput adds an entry holding one reference, for the task that
+// produced it. It returns false when another task stored the key first.
+func (r *PostgresRepository) StoreCachedOutput(ctx context.Context, entry models.CachedOutput, ttl time.Duration) (bool, error) {
+	metadata, err := json.Marshal(entry.Metadata)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode cache metadata for %s: %w", entry.Key, err)
+	}
+
+	query := `SELECT public.store_cached_conversion(
+		$1::varchar, $2::text, $3::bigint, $4::varchar, $5::jsonb, $6::integer)`
+
+	var stored bool
+	err = r.db.QueryRowContext(ctx, query, entry.Key, entry.Output, entry.Size, entry.Checksum,
+		string(metadata), int(ttl.Seconds())).Scan(&stored)
+	if err != nil {
+		return false, fmt.Errorf("failed to store cache entry %s: %w", entry.Key, err)
+	}
+	return stored, nil
+}
+
+// ReleaseCachedOutput drops a reference taken by AcquireCachedOutput or
+// StoreCachedOutput.
+func (r *PostgresRepository) ReleaseCachedOutput(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, `SELECT public.release_cached_conversion($1::varchar)`, key)
+	if err != nil {
+		return fmt.Errorf("failed to release cache entry %s: %w", key, err)
+	}
+	return nil
+}
+
+// CollectExpiredCache deletes up to limit expired entries that no task refers
+// to and returns them, so the caller can delete their outputs.
+func (r *PostgresRepository) CollectExpiredCache(ctx context.Context, limit int) ([]models.CachedOutput, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT cache_key, output_path, output_size FROM public.collect_expired_cache_entries($1::integer)`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect expired cache entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.CachedOutput
+	for rows.Next() {
+		var entry models.CachedOutput
+		var size sql.NullInt64
+		if err := rows.Scan(&entry.Key, &entry.Output, &size); err != nil {
+			return nil, fmt.Errorf("failed to read expired cache entry: %w", err)
+		}
+		entry.Size = size.Int64
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -13,6 +14,13 @@ import (
 type Repository interface {
 	UpdateJobStatus(ctx context.Context, update models.JobUpdate) error
 	GetJobByID(ctx context.Context, id string) (*models.JobData, error)
+	AcquireCachedOutput(ctx context.Context, key string, ttl time.Duration) (*models.CachedOutput, error)
+	StoreCachedOutput(ctx context.Context, entry models.CachedOutput, ttl time.Duration) (bool, error)
+	ReleaseCachedOutput(ctx context.Context, key string) error
+	CollectExpiredCache(ctx context.Context, limit int) ([]models.CachedOutput, error)
+	ExpiredOutputs(ctx context.Context, ttl time.Duration, limit, offset int) ([]models.ExpiredOutput, error)
+	CleanupOutboxEvents(ctx context.Context, days int) (int, error)
+	WithAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error)
 	Close() error
 	Ping(ctx context.Context) error
 }
@@ -46,8 +54,36 @@ func (r *PostgresRepository) UpdateJobStatus(ctx context.Context, update models.
 	if update.Output != "" {
 		outputParam = sql.NullString{String: update.Output, Valid: true}
 	}
-	query := "SELECT public.update_task_status_with_outbox($1::uuid, $2::varchar, $3::text)"
-	_, err := r.db.ExecContext(ctx, query, update.ID, update.Status, outputParam)
+	var metadataParam sql.NullString
+	if len(update.Metadata) > 0 {
+		metadata, err := json.Marshal(update.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata for ID %s: %w", update.ID, err)
+		}
+		metadataParam = sql.NullString{String: string(metadata), Valid: true}
+	}
+	var sizeParam sql.NullInt64
+	if update.Size > 0 {
+		sizeParam = sql.NullInt64{Int64: update.Size, Valid: true}
+	}
+	var checksumParam, errorParam, errorTypeParam, cacheKeyParam sql.NullString
+	if update.Checksum != "" {
+		checksumParam = sql.NullString{String: update.Checksum, Valid: true}
+	}
+	if update.Error != nil {
+		errorParam = sql.NullString{String: update.Error.Error(), Valid: true}
+	}
+	if update.ErrorType != "" {
+		errorTypeParam = sql.NullString{String: string(update.ErrorType), Valid: true}
+	}
+	if update.CacheKey != "" {
+		cacheKeyParam = sql.NullString{String: update.CacheKey, Valid: true}
+	}
+
+	query := `SELECT public.update_task_status_with_outbox(
+		$1::uuid, $2::varchar, $3::text, $4::jsonb, $5::bigint, $6::varchar, $7::text, $8::varchar, $9::varchar)`
+	_, err := r.db.ExecContext(ctx, query, update.ID, update.Status, outputParam, metadataParam,
+		sizeParam, checksumParam, errorParam, errorTypeParam, cacheKeyParam)
 	if err != nil {
 		return fmt.Errorf("failed to update job status for ID %s: %w", update.ID, err)
 	}
@@ -64,7 +100,7 @@ func (r *PostgresRepository) GetJobByID(ctx context.Context, id string) (*models
 	var job models.JobData
 	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&job.ID,
-		&job.InputPath,
+		&job.InputURI,
 		&job.Mimetype,
 		&job.Format,
 	)
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+// ExpiredOutputs lists completed tasks that finished more than ttl ago, oldest
+// first.
+func (r *PostgresRepository) ExpiredOutputs(ctx context.Context, ttl time.Duration, limit, offset int) ([]models.ExpiredOutput, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT task_id, output_path, output_size, cache_key
+		FROM public.get_expired_task_outputs($1::integer, $2::integer, $3::integer)`,
+		int(ttl.Seconds()), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired outputs: %w", err)
+	}
+	defer rows.Close()
+
+	var outputs []models.ExpiredOutput
+	for rows.Next() {
+		var output models.ExpiredOutput
+		var path, cacheKey sql.NullString
+		var size sql.NullInt64
+		if err := rows.Scan(&output.TaskID, &path, &size, &cacheKey); err != nil {
+			return nil, fmt.Errorf("failed to read expired output: %w", err)
+		}
+		output.Output = path.String
+		output.Size = size.Int64
+		output.CacheKey = cacheKey.String
+		outputs = append(outputs, output)
+	}
+	return outputs, rows.Err()
+}
+
+// CleanupOutboxEvents deletes outbox events processed more than days ago and
+// returns how many were deleted.
+func (r *PostgresRepository) CleanupOutboxEvents(ctx context.Context, days int) (int, error) {
+	var deleted int
+	err := r.db.QueryRowContext(ctx, `SELECT public.cleanup_processed_outbox_events($1::integer)`, days).Scan(&deleted)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean up outbox events: %w", err)
+	}
+	return deleted, nil
+}
+
+// WithAdvisoryLock runs fn while holding the session advisory lock key, so
+// that only one worker process at a time does the work. It returns false
+// without running fn when another session holds the lock.
+func (r *PostgresRepository) WithAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error) {
+	// Session locks belong to a connection, so the lock and unlock must not
+	// be spread over the pool.
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get a connection for advisory lock: %w", err)
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked); err != nil {
+		return false, fmt.Errorf("failed to take advisory lock %d: %w", key, err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+
+	return true, fn(ctx)
+}
@@ -2,11 +2,273 @@ package models
 
 import "time"
 
+// JobData is a queued conversion. Inputs are storage URIs such as
+// "file:///tmp/input/<id>.mp4", "s3://bucket/key" or "https://host/a.mp4",
+// not paths; the worker fetches them before converting.
 type JobData struct {
-	ID        string `json:"id"`
-	InputPath string `json:"input_path"`
-	Mimetype  string `json:"mimetype"`
-	Format    string `json:"format"`
+	ID           string      `json:"id"`
+	InputURI     string      `json:"input_uri"`
+	Inputs       []InputFile `json:"inputs,omitempty"`
+	OriginalName string      `json:"original_name,omitempty"`
+	Mimetype     string      `json:"mimetype"`
+	Format       string      `json:"format"`
+	Options      JobOptions  `json:"options"`
+	// QueueJobID is the ID the queue knows the job by, which differs from
+	// the task ID.
+	QueueJobID string `json:"-"`
+	// EnqueuedAt is when the job was added to the queue, if known.
+	EnqueuedAt time.Time `json:"-"`
+	// Deferrals counts how often admission control put the job back.
+	Deferrals int `json:"-"`
+}
+
+// InputFile is one input of a multi-input job. Inputs are listed in order,
+// starting with the one in InputURI. Duration is how long a slideshow shows
+// an image, in seconds. Checksum, "sha256:<hex>", is verified once the input
+// is fetched. An input with an EncryptionKeyID must be stored encrypted, as
+// JobOptions.InputEncryptionKeyID. Path is the local copy the worker
+// resolved URI to.
+type InputFile struct {
+	URI             string  `json:"uri"`
+	Path            string  `json:"-"`
+	Mimetype        string  `json:"mimetype,omitempty"`
+	Duration        float64 `json:"duration,omitempty"`
+	Checksum        string  `json:"checksum,omitempty"`
+	EncryptionKeyID string  `json:"encryption_key_id,omitempty"`
+}
+
+// JobOptions carries the optional, per-job conversion settings. Every field is
+// optional; a zero value keeps the converter defaults.
+type JobOptions struct {
+	Metadata      *MetadataOptions     `json:"metadata,omitempty"`
+	Watermark     *WatermarkOptions    `json:"watermark,omitempty"`
+	TextOverlays  []TextOverlay        `json:"text_overlays,omitempty"`
+	Subtitles     *SubtitleOptions     `json:"subtitles,omitempty"`
+	BurnSubtitles *BurnSubtitleOptions `json:"burn_subtitles,omitempty"`
+	Frames        *FrameOptions        `json:"frames,omitempty"`
+	GIF           *GIFOptions          `json:"gif,omitempty"`
+	AnimatedWebP  *AnimatedWebPOptions `json:"animated_webp,omitempty"`
+	MaxSize       ByteSize             `json:"max_size,omitempty"`
+	Sprites       *SpriteOptions       `json:"sprites,omitempty"`
+	ContactSheet  *ContactSheetOptions `json:"contact_sheet,omitempty"`
+	Waveform      *WaveformOptions     `json:"waveform,omitempty"`
+	Spectrogram   *SpectrogramOptions  `json:"spectrogram,omitempty"`
+	Compose       *ComposeOptions      `json:"compose,omitempty"`
+	Transform     *TransformOptions    `json:"transform,omitempty"`
+	Chunks        *ChunkOptions        `json:"chunks,omitempty"`
+	// InputChecksum, "sha256:<hex>", is verified against the main input
+	// once it is fetched.
+	InputChecksum string `json:"input_checksum,omitempty"`
+	// InputEncryptionKeyID is the encryption_key_id recorded for the main
+	// input when it is the output of an earlier task. The input is then
+	// rejected if it is stored in plaintext.
+	InputEncryptionKeyID string `json:"input_encryption_key_id,omitempty"`
+}
+
+type MetadataMode string
+
+const (
+	MetadataStrip MetadataMode = "strip"
+	MetadataKeep  MetadataMode = "keep"
+	MetadataAllow MetadataMode = "allow"
+)
+
+// MetadataOptions sets the tag policy of the output. The cover art is the
+// asset stored in the worker's assets directory under CoverArtAssetID;
+// CoverArtPath is where the worker found it.
+type MetadataOptions struct {
+	Mode            MetadataMode `json:"mode,omitempty"`
+	Allow           []string     `json:"allow,omitempty"`
+	Title           string       `json:"title,omitempty"`
+	Artist          string       `json:"artist,omitempty"`
+	Album           string       `json:"album,omitempty"`
+	CoverArtAssetID string       `json:"cover_art_asset_id,omitempty"`
+	CoverArtPath    string       `json:"-"`
+}
+
+// WatermarkOptions places an image over every frame. The image is the asset
+// stored in the worker's assets directory under AssetID; Path is where the
+// worker found it.
+type WatermarkOptions struct {
+	Path     string  `json:"-"`
+	AssetID  string  `json:"asset_id,omitempty"`
+	Position string  `json:"position,omitempty"`
+	Margin   int     `json:"margin,omitempty"`
+	Opacity  float64 `json:"opacity,omitempty"`
+	Scale    float64 `json:"scale,omitempty"`
+}
+
+// TextOverlay draws text over the frames between Start and End (in seconds).
+// The placeholder {timestamp} in Text is replaced by the running timestamp.
+// Font is a font family name; a font file is given as the ID of an asset,
+// FontAssetID, and FontPath is where the worker found it.
+type TextOverlay struct {
+	Text        string  `json:"text"`
+	Font        string  `json:"font,omitempty"`
+	FontAssetID string  `json:"font_asset_id,omitempty"`
+	FontPath    string  `json:"-"`
+	Size        int     `json:"size,omitempty"`
+	Color       string  `json:"color,omitempty"`
+	Position    string  `json:"position,omitempty"`
+	Margin      int     `json:"margin,omitempty"`
+	Start       float64 `json:"start,omitempty"`
+	End         float64 `json:"end,omitempty"`
+}
+
+// SubtitleOptions configures the "subtitles" target. Format is "srt" or
+// "vtt"; Language selects the embedded track by its language tag.
+type SubtitleOptions struct {
+	Format   string `json:"format,omitempty"`
+	Language string `json:"language,omitempty"`
+	Track    *int   `json:"track,omitempty"`
+}
+
+// BurnSubtitleOptions renders subtitles into the video frames, either from a
+// subtitle file stored as an asset (AssetID, found by the worker at Path) or
+// from an embedded track chosen by Language or by its position among the
+// subtitle tracks (Track).
+type BurnSubtitleOptions struct {
+	AssetID  string `json:"asset_id,omitempty"`
+	Path     string `json:"-"`
+	Language string `json:"language,omitempty"`
+	Track    *int   `json:"track,omitempty"`
+}
+
+// FrameOptions configures the "images" target. Frames are sampled either at a
+// fixed rate (FPS), at explicit Timestamps (in seconds) or on scene changes
+// (SceneThreshold, between 0 and 1); without any of them every frame is kept.
+type FrameOptions struct {
+	FPS            float64   `json:"fps,omitempty"`
+	Timestamps     []float64 `json:"timestamps,omitempty"`
+	SceneThreshold float64   `json:"scene_threshold,omitempty"`
+	MaxFrames      int       `json:"max_frames,omitempty"`
+	Format         string    `json:"format,omitempty"`
+	Quality        int       `json:"quality,omitempty"`
+	Width          int       `json:"width,omitempty"`
+	Height         int       `json:"height,omitempty"`
+}
+
+// GIFOptions configures the "gif" target. Loop follows ffmpeg's convention:
+// 0 loops forever, -1 plays once and N repeats N times. When the result is
+// larger than MaxSize the worker retries with a lower frame rate and then a
+// smaller width.
+type GIFOptions struct {
+	Width    int      `json:"width,omitempty"`
+	FPS      float64  `json:"fps,omitempty"`
+	Start    float64  `json:"start,omitempty"`
+	Duration float64  `json:"duration,omitempty"`
+	Loop     *int     `json:"loop,omitempty"`
+	Dither   string   `json:"dither,omitempty"`
+	MaxSize  ByteSize `json:"max_size,omitempty"`
+}
+
+// AnimatedWebPOptions configures the "webp" target for video inputs. Loop is
+// how many times the animation plays, 0 for forever, and Quality goes from 0
+// to 100.
+type AnimatedWebPOptions struct {
+	Width    int     `json:"width,omitempty"`
+	FPS      float64 `json:"fps,omitempty"`
+	Start    float64 `json:"start,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	Loop     int     `json:"loop,omitempty"`
+	Quality  int     `json:"quality,omitempty"`
+}
+
+// SpriteOptions configures the "sprites" target: one thumbnail every Interval
+// seconds, Width pixels wide, tiled Columns x Rows per sprite image.
+type SpriteOptions struct {
+	Interval float64 `json:"interval,omitempty"`
+	Width    int     `json:"width,omitempty"`
+	Columns  int     `json:"columns,omitempty"`
+	Rows     int     `json:"rows,omitempty"`
+}
+
+// ContactSheetOptions configures the "contactsheet" target: Columns x Rows
+// thumbnails evenly spread over the video, each stamped with its timestamp.
+type ContactSheetOptions struct {
+	Columns int    `json:"columns,omitempty"`
+	Rows    int    `json:"rows,omitempty"`
+	Width   int    `json:"width,omitempty"`
+	Format  string `json:"format,omitempty"`
+}
+
+// WaveformOptions configures the "waveform" target. Format "json" produces
+// audiowaveform compatible peak data; "png" and "svg" render an image of
+// Width x Height pixels.
+type WaveformOptions struct {
+	Format          string `json:"format,omitempty"`
+	SamplesPerPixel int    `json:"samples_per_pixel,omitempty"`
+	Bits            int    `json:"bits,omitempty"`
+	Width           int    `json:"width,omitempty"`
+	Height          int    `json:"height,omitempty"`
+	Color           string `json:"color,omitempty"`
+	Background      string `json:"background,omitempty"`
+}
+
+// SpectrogramOptions configures the "spectrogram" target. Color and Scale
+// are passed to ffmpeg's showspectrumpic filter.
+type SpectrogramOptions struct {
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Color  string `json:"color,omitempty"`
+	Scale  string `json:"scale,omitempty"`
+	Legend *bool  `json:"legend,omitempty"`
+}
+
+type ComposeMode string
+
+const (
+	ComposeConcat       ComposeMode = "concat"
+	ComposeMergeAudio   ComposeMode = "merge_audio"
+	ComposeReplaceAudio ComposeMode = "replace_audio"
+	ComposeSlideshow    ComposeMode = "slideshow"
+)
+
+// ComposeOptions combines the inputs of a multi-input job. Width, Height and
+// FPS set the output video; by default concat uses those of the first input
+// and slideshow renders 1280x720 at 25 fps.
+type ComposeOptions struct {
+	Mode   ComposeMode `json:"mode"`
+	Width  int         `json:"width,omitempty"`
+	Height int         `json:"height,omitempty"`
+	FPS    float64     `json:"fps,omitempty"`
+}
+
+// TransformOptions edits a video before it is encoded. Rotate is clockwise,
+// in multiples of 90 degrees, and Flip is "horizontal" or "vertical". Speed
+// is a playback factor between 0.25 and 4; audio keeps its pitch.
+type TransformOptions struct {
+	Rotate  int          `json:"rotate,omitempty"`
+	Flip    string       `json:"flip,omitempty"`
+	Crop    *CropOptions `json:"crop,omitempty"`
+	Speed   float64      `json:"speed,omitempty"`
+	Reverse bool         `json:"reverse,omitempty"`
+	Mute    bool         `json:"mute,omitempty"`
+	FPS     float64      `json:"fps,omitempty"`
+}
+
+// CropOptions crops either to a Width x Height rectangle at X, Y (centered
+// when omitted) or to the largest centered area of an Aspect ratio such as
+// "16:9".
+type CropOptions struct {
+	X      *int   `json:"x,omitempty"`
+	Y      *int   `json:"y,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Aspect string `json:"aspect,omitempty"`
+}
+
+// ChunkOptions configures the "chunks" target. Mode "fixed" cuts every
+// Duration seconds; "silence" cuts at the last silence in the second half of
+// each Duration-long window, falling back to a fixed cut when there is none.
+// Consecutive chunks share Overlap seconds. SilenceThreshold is in dB.
+type ChunkOptions struct {
+	Mode             string  `json:"mode,omitempty"`
+	Duration         float64 `json:"duration,omitempty"`
+	Overlap          float64 `json:"overlap,omitempty"`
+	Format           string  `json:"format,omitempty"`
+	SilenceThreshold float64 `json:"silence_threshold,omitempty"`
+	SilenceDuration  float64 `json:"silence_duration,omitempty"`
 }
 
 type JobStatus string
@@ -16,22 +278,67 @@ const (
 	JobStatusProcessing JobStatus = "processing"
 	JobStatusCompleted  JobStatus = "completed"
 	JobStatusFailed     JobStatus = "failed"
+	// JobStatusExpired marks a completed task whose output retention deleted.
+	JobStatusExpired JobStatus = "expired"
+)
+
+// ErrorType tells a failed conversion apart from an output that was written
+// but failed verification.
+type ErrorType string
+
+const (
+	ErrorTypeConversion   ErrorType = "conversion"
+	ErrorTypeVerification ErrorType = "verification"
 )
 
 type JobUpdate struct {
-	ID       string
-	Status   JobStatus
+	ID        string
+	Status    JobStatus
+	Output    string
+	Filename  string
+	Size      int64
+	Checksum  string
+	Error     error
+	ErrorType ErrorType
+	Metadata  map[string]any
+	CacheKey  string
+}
+
+// ExpiredOutput is the output of a completed task that outlived the output
+// TTL.
+type ExpiredOutput struct {
+	TaskID   string
+	Output   string
+	Size     int64
+	CacheKey string
+}
+
+// CachedOutput is a conversion cache entry: an output stored once and shared
+// by every task whose inputs, format and options hash to Key.
+type CachedOutput struct {
+	Key      string
 	Output   string
-	Filename string
-	Error    error
+	Size     int64
+	Checksum string
+	Metadata map[string]any
 }
 
+// WorkerInfo describes a worker. CurrentJob is the ID of the job it is
+// running, empty while it waits for one.
 type WorkerInfo struct {
-	ID        int
-	Type      string
-	QueueName string
-	StartTime time.Time
-	JobsCount int64
+	ID           int
+	Type         string
+	QueueName    string
+	StartTime    time.Time
+	JobsCount    int64
+	CurrentJob   string
+	JobStartedAt time.Time
+	// JobExpected is how long the current job should take.
+	JobExpected time.Duration
+	// LoopAt is when the worker last went looking for a job, and Stopped
+	// whether its loop has returned.
+	LoopAt  time.Time
+	Stopped bool
 }
 
 type QueueType string
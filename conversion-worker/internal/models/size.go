@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes. In JSON it is either a number of bytes or a
+// string with a unit, such as "25MB" or "512 KiB".
+type ByteSize int64
+
+var byteUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var number int64
+	if err := json.Unmarshal(data, &number); err == nil {
+		*b = ByteSize(number)
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("size must be a number of bytes or a string such as \"25MB\"")
+	}
+
+	size, err := ParseByteSize(text)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+func ParseByteSize(text string) (ByteSize, error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	split := strings.IndexFunc(text, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split == -1 {
+		split = len(text)
+	}
+
+	value, err := strconv.ParseFloat(text[:split], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", text)
+	}
+	unit, ok := byteUnits[strings.TrimSpace(text[split:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", text)
+	}
+
+	return ByteSize(value * float64(unit)), nil
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]ByteSize{
+		"0":       0,
+		"1024":    1024,
+		"512B":    512,
+		"25MB":    25_000_000,
+		"25mb":    25_000_000,
+		"1.5 GB":  1_500_000_000,
+		"512 KiB": 512 << 10,
+		" 2MiB ":  2 << 20,
+		"1GiB":    1 << 30,
+		"0.5kb":   500,
+		"100.9":   100,
+	}
+	for text, want := range tests {
+		got, err := ParseByteSize(text)
+		if err != nil {
+			t.Errorf("ParseByteSize(%q) = %v", text, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseByteSize(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestParseByteSizeInvalid(t *testing.T) {
+	for _, text := range []string{"", "MB", "-5MB", "1.5.2MB", "10TB", "10 megabytes"} {
+		if size, err := ParseByteSize(text); err == nil {
+			t.Errorf("ParseByteSize(%q) = %d, want an error", text, size)
+		}
+	}
+}
+
+func TestByteSizeUnmarshalJSON(t *testing.T) {
+	tests := map[string]ByteSize{
+		`1048576`:  1 << 20,
+		`"1MiB"`:   1 << 20,
+		`"2.5 MB"`: 2_500_000,
+	}
+	for data, want := range tests {
+		var got ByteSize
+		if err := json.Unmarshal([]byte(data), &got); err != nil {
+			t.Errorf("Unmarshal(%s) = %v", data, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Unmarshal(%s) = %d, want %d", data, got, want)
+		}
+	}
+
+	for _, data := range []string{`"ten"`, `true`, `1.5`} {
+		var size ByteSize
+		if err := json.Unmarshal([]byte(data), &size); err == nil {
+			t.Errorf("Unmarshal(%s) = %d, want an error", data, size)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
@@ -12,6 +13,7 @@ import (
 
 type Queue interface {
 	PopJob(ctx context.Context, queueName string) (*models.JobData, error)
+	RequeueJob(ctx context.Context, queueName string, job *models.JobData) error
 	Close() error
 	Ping(ctx context.Context) error
 }
@@ -45,19 +47,56 @@ func (q *RedisQueue) PopJob(ctx context.Context, queueName string) (*models.JobD
 
 	jobKey := fmt.Sprintf("bull:%s:%s", queueName, jobID)
 
-	dataJSON, err := q.client.HGet(ctx, jobKey, "data").Result()
+	fields, err := q.client.HMGet(ctx, jobKey, "data", "timestamp", deferralsField).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get job data from queue %s: %w", queueName, err)
 	}
+	dataJSON, ok := fields[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to get job data from queue %s: job %s has no data", queueName, jobID)
+	}
 
 	var job models.JobData
 	if err := json.Unmarshal([]byte(dataJSON), &job); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal job data: %w", err)
 	}
+	job.QueueJobID = jobID
+
+	// Bull records when the job was added, in milliseconds.
+	if timestamp, ok := fields[1].(string); ok {
+		if ms, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+			job.EnqueuedAt = time.UnixMilli(ms)
+		}
+	}
+	if deferrals, ok := fields[2].(string); ok {
+		job.Deferrals, _ = strconv.Atoi(deferrals)
+	}
 
 	return &job, nil
 }
 
+// deferralsField is the field of the job hash that counts how often the job
+// was requeued. Bull ignores fields it does not know.
+const deferralsField = "deferrals"
+
+// RequeueJob puts a popped job back at the end of the wait list, for jobs the
+// worker cannot take yet, and counts the deferral. Its data is still in the
+// job hash.
+func (q *RedisQueue) RequeueJob(ctx context.Context, queueName string, job *models.JobData) error {
+	waitQueue := fmt.Sprintf("bull:%s:wait", queueName)
+	jobKey := fmt.Sprintf("bull:%s:%s", queueName, job.QueueJobID)
+
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, jobKey, deferralsField, 1)
+		pipe.RPush(ctx, waitQueue, job.QueueJobID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to requeue job %s on queue %s: %w", job.ID, queueName, err)
+	}
+	return nil
+}
+
 func (q *RedisQueue) Ping(ctx context.Context) error {
 	return q.client.Ping(ctx).Err()
 }
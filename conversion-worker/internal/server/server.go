@@ -0,0 +1,64 @@
+// Package server runs the worker's own HTTP endpoints: metrics and health
+// checks.
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout bounds how long in-flight requests may take once the
+// server is asked to stop.
+const shutdownTimeout = 5 * time.Second
+
+type Server struct {
+	mux    *http.ServeMux
+	server *http.Server
+}
+
+func New(addr string) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		mux: mux,
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Handle registers handler for pattern, in the syntax of http.ServeMux.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Run serves until ctx is done, then shuts down gracefully.
+func (s *Server) Run(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Addr() string {
+	return s.server.Addr
+}
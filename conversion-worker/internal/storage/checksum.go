@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when content does not hash to the expected
+// checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ParseChecksum reads an expected checksum, "sha256:<hex>" or a bare SHA-256
+// hex digest, and returns the digest.
+func ParseChecksum(checksum string) ([]byte, error) {
+	value := strings.ToLower(strings.TrimSpace(checksum))
+	if algorithm, digest, ok := strings.Cut(value, ":"); ok {
+		if algorithm != "sha256" {
+			return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+		}
+		value = digest
+	}
+
+	sum, err := hex.DecodeString(value)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 checksum %q", checksum)
+	}
+	return sum, nil
+}
+
+// verifier hashes content as it is written and compares the result with the
+// expected digest. Without an expected digest it accepts anything.
+type verifier struct {
+	hash     hash.Hash
+	expected []byte
+}
+
+func newVerifier(checksum string) (*verifier, error) {
+	if checksum == "" {
+		return &verifier{}, nil
+	}
+	expected, err := ParseChecksum(checksum)
+	if err != nil {
+		return nil, err
+	}
+	return &verifier{hash: sha256.New(), expected: expected}, nil
+}
+
+func (v *verifier) Write(p []byte) (int, error) {
+	if v.hash == nil {
+		return len(p), nil
+	}
+	return v.hash.Write(p)
+}
+
+func (v *verifier) verify() error {
+	if v.hash == nil {
+		return nil
+	}
+	if sum := v.hash.Sum(nil); !bytes.Equal(sum, v.expected) {
+		return fmt.Errorf("%w: got sha256:%x, want sha256:%x", ErrChecksumMismatch, sum, v.expected)
+	}
+	return nil
+}
+
+// VerifyFile checks that the local file at path matches checksum.
+func VerifyFile(path, checksum string) error {
+	verifier, err := newVerifier(checksum)
+	if err != nil || verifier.hash == nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(verifier, file); err != nil {
+		return err
+	}
+	return verifier.verify()
+}
@@ -0,0 +1,683 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Encrypted objects start with a header naming the master key and holding
+// the object's data key, wrapped by that master key:
+//
+//	magic "CVENC1\x00\x00" | chunk size (uint32) | key ID length (uint8) |
+//	key ID | wrapped key length (uint16) | nonce + sealed data key
+//
+// The content follows in chunks of up to chunk size bytes, each sealed with
+// AES-256-GCM under the data key. A chunk's nonce is its index, and its
+// additional data says whether it is the last one, so chunks cannot be
+// reordered, dropped or truncated away unnoticed. Only the last chunk may
+// be short, and there is always at least one.
+const (
+	encryptionMagic     = "CVENC1\x00\x00"
+	encryptionChunkSize = 64 << 10
+	dataKeySize         = 32
+	gcmTagSize          = 16
+)
+
+var (
+	// ErrNotEncrypted is returned when a file that should be decrypted does
+	// not start with an encryption header.
+	ErrNotEncrypted = errors.New("object is not encrypted")
+	// ErrUnknownKey is returned for objects wrapped by a master key the
+	// keyring does not hold.
+	ErrUnknownKey = errors.New("unknown encryption key")
+)
+
+// ParseMasterKey decodes a 256-bit master key given in base64 or hex.
+func ParseMasterKey(encoded string) ([]byte, error) {
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == dataKeySize {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == dataKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("encryption key must be %d bytes, in base64 or hex", dataKeySize)
+}
+
+// Keyring holds the master keys data keys are wrapped with. New objects use
+// the current key; the others only decrypt objects written before a key
+// rotation.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring returns a keyring that encrypts with keys[current].
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, current)
+	}
+
+	k := &Keyring{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("encryption key ID %q must be 1 to 255 bytes", id)
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// ParseKeyring builds a keyring from the encoded current key and previous
+// keys given as "id:key" pairs.
+func ParseKeyring(currentID, current string, previous []string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	for _, entry := range previous {
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("previous encryption keys must be given as id:key")
+		}
+		key, err := ParseMasterKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+
+	key, err := ParseMasterKey(current)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key %q: %w", currentID, err)
+	}
+	keys[currentID] = key
+	return NewKeyring(currentID, keys)
+}
+
+// KeyID returns the ID of the key new objects are encrypted with.
+func (k *Keyring) KeyID() string {
+	return k.current
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// envelope is the header of an encrypted object.
+type envelope struct {
+	keyID      string
+	wrappedKey []byte
+	chunkSize  int
+}
+
+// seal creates a data key for a new object and returns the object header
+// together with the cipher for its content.
+func (k *Keyring) seal() (envelope, cipher.AEAD, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return envelope{}, nil, err
+	}
+
+	master := k.keys[k.current]
+	nonce := make([]byte, master.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return envelope{}, nil, err
+	}
+	// The key ID is authenticated so a wrapped key cannot be relabelled.
+	wrapped := master.Seal(nonce, nonce, dataKey, []byte(k.current))
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return envelope{}, nil, err
+	}
+	return envelope{keyID: k.current, wrappedKey: wrapped, chunkSize: encryptionChunkSize}, aead, nil
+}
+
+// open unwraps the data key of an object.
+func (k *Keyring) open(env envelope) (cipher.AEAD, error) {
+	master, ok := k.keys[env.keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, env.keyID)
+	}
+	if len(env.wrappedKey) < master.NonceSize() {
+		return nil, fmt.Errorf("invalid encryption header: wrapped key too short")
+	}
+
+	nonce, sealed := env.wrappedKey[:master.NonceSize()], env.wrappedKey[master.NonceSize():]
+	dataKey, err := master.Open(nil, nonce, sealed, []byte(env.keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key %q: %w", env.keyID, err)
+	}
+	return newGCM(dataKey)
+}
+
+func (e envelope) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(encryptionMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(e.chunkSize))
+	buf.WriteByte(byte(len(e.keyID)))
+	buf.WriteString(e.keyID)
+	binary.Write(&buf, binary.BigEndian, uint16(len(e.wrappedKey)))
+	buf.Write(e.wrappedKey)
+	return buf.Bytes()
+}
+
+func (e envelope) size() int64 {
+	return int64(len(encryptionMagic) + 4 + 1 + len(e.keyID) + 2 + len(e.wrappedKey))
+}
+
+// readEnvelope reads an object header, returning ErrNotEncrypted when r does
+// not start with one.
+func readEnvelope(r io.Reader) (envelope, error) {
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != encryptionMagic {
+		return envelope{}, ErrNotEncrypted
+	}
+
+	var env envelope
+	var chunkSize uint32
+	if err := binary.Read(r, binary.BigEndian, &chunkSize); err != nil {
+		return envelope{}, invalidHeader(err)
+	}
+	if chunkSize == 0 || chunkSize > 16<<20 {
+		return envelope{}, fmt.Errorf("invalid encryption header: chunk size %d", chunkSize)
+	}
+	env.chunkSize = int(chunkSize)
+
+	var idLen uint8
+	if err := binary.Read(r, binary.BigEndian, &idLen); err != nil {
+		return envelope{}, invalidHeader(err)
+	}
+	id := make([]byte, idLen)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return envelope{}, invalidHeader(err)
+	}
+	env.keyID = string(id)
+
+	var keyLen uint16
+	if err := binary.Read(r, binary.BigEndian, &keyLen); err != nil {
+		return envelope{}, invalidHeader(err)
+	}
+	env.wrappedKey = make([]byte, keyLen)
+	if _, err := io.ReadFull(r, env.wrappedKey); err != nil {
+		return envelope{}, invalidHeader(err)
+	}
+	return env, nil
+}
+
+func invalidHeader(err error) error {
+	return fmt.Errorf("invalid encryption header: %w", err)
+}
+
+func chunkNonce(aead cipher.AEAD, index int64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(index))
+	return nonce
+}
+
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptedSize returns the stored size of an object with plain bytes of
+// content.
+func encryptedSize(env envelope, plain int64) int64 {
+	chunks := max((plain+int64(env.chunkSize)-1)/int64(env.chunkSize), 1)
+	return env.size() + plain + chunks*gcmTagSize
+}
+
+// plainSize is the inverse of encryptedSize.
+func plainSize(env envelope, stored int64) (int64, error) {
+	body := stored - env.size()
+	sealedChunk := int64(env.chunkSize + gcmTagSize)
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	if body < gcmTagSize || body-(chunks-1)*sealedChunk < gcmTagSize {
+		return 0, fmt.Errorf("encrypted object is truncated")
+	}
+	return body - chunks*gcmTagSize, nil
+}
+
+// encryptReader encrypts src as it is read.
+type encryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	chunk   []byte
+	sealed  []byte
+	pending []byte
+	index   int64
+	done    bool
+}
+
+func newEncryptReader(src io.Reader, env envelope, aead cipher.AEAD) *encryptReader {
+	return &encryptReader{
+		src:     bufio.NewReaderSize(src, env.chunkSize),
+		aead:    aead,
+		chunk:   make([]byte, env.chunkSize),
+		pending: env.marshal(),
+	}
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.pending) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.sealNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+func (e *encryptReader) sealNext() error {
+	n, err := io.ReadFull(e.src, e.chunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	final := err != nil
+	if !final {
+		// A full chunk is the last one when nothing follows it.
+		if _, err := e.src.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	e.sealed = e.aead.Seal(e.sealed[:0], chunkNonce(e.aead, e.index), e.chunk[:n], chunkAAD(final))
+	e.pending = e.sealed
+	e.index++
+	e.done = final
+	return nil
+}
+
+// encryptWriter encrypts what is written to it into w. A full chunk is
+// held back until more data arrives, because only Close knows which chunk
+// is the last.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	chunk  []byte
+	sealed []byte
+	index  int64
+	err    error
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && e.err == nil {
+		if len(e.chunk) == cap(e.chunk) {
+			e.sealChunk(false)
+			continue
+		}
+		n := copy(e.chunk[len(e.chunk):cap(e.chunk)], p)
+		e.chunk = e.chunk[:len(e.chunk)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, e.err
+}
+
+func (e *encryptWriter) sealChunk(final bool) {
+	e.sealed = e.aead.Seal(e.sealed[:0], chunkNonce(e.aead, e.index), e.chunk, chunkAAD(final))
+	e.index++
+	e.chunk = e.chunk[:0]
+	_, e.err = e.w.Write(e.sealed)
+}
+
+// finish seals the last chunk.
+func (e *encryptWriter) finish() error {
+	if e.err == nil {
+		e.sealChunk(true)
+	}
+	return e.err
+}
+
+// EncryptedFile is a local file whose content is encrypted as it is
+// written. Close seals the last chunk; a file that is not closed cannot be
+// decrypted.
+type EncryptedFile struct {
+	file *os.File
+	*encryptWriter
+}
+
+// CreateEncrypted creates the file at path, encrypting everything written to
+// it with a new data key wrapped by the current key of keys. Files written
+// this way are stored as they are by Manager.Upload.
+func CreateEncrypted(path string, keys *Keyring) (*EncryptedFile, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("cannot encrypt %s: no encryption key is configured", path)
+	}
+	env, aead, err := keys.seal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data key: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(env.marshal()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &EncryptedFile{
+		file: file,
+		encryptWriter: &encryptWriter{
+			w:     file,
+			aead:  aead,
+			chunk: make([]byte, 0, env.chunkSize),
+		},
+	}, nil
+}
+
+func (f *EncryptedFile) Close() error {
+	err := f.finish()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// IsEncryptedFile reports whether the file at path starts with an
+// encryption header.
+func IsEncryptedFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false, nil
+	}
+	return string(magic) == encryptionMagic, nil
+}
+
+// decryptReader decrypts an encrypted stream as it is read.
+type decryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	sealed  []byte
+	plain   []byte
+	pending []byte
+	index   int64
+	done    bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.openNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+func (d *decryptReader) openNext() error {
+	n, err := io.ReadFull(d.src, d.sealed)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	final := err != nil
+	if !final {
+		if _, err := d.src.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if n < gcmTagSize {
+		return fmt.Errorf("encrypted object is truncated")
+	}
+
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(d.aead, d.index), d.sealed[:n], chunkAAD(final))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %w", d.index, err)
+	}
+	d.plain = plain
+	d.pending = plain
+	d.index++
+	d.done = final
+	return nil
+}
+
+// Encrypted encrypts everything written to the wrapped backend and decrypts
+// what is read from it. Objects without an encryption header are read as
+// they are, so plaintext inputs can sit next to encrypted outputs, unless
+// encryption is required. Sizes reported by Stat are of the stored,
+// encrypted objects.
+type Encrypted struct {
+	Storage
+	keys *Keyring
+	// require rejects objects without an encryption header.
+	require bool
+}
+
+func NewEncrypted(store Storage, keys *Keyring) *Encrypted {
+	return &Encrypted{Storage: store, keys: keys}
+}
+
+func (e *Encrypted) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := e.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(body)
+	if magic, _ := buffered.Peek(len(encryptionMagic)); string(magic) != encryptionMagic {
+		if e.require {
+			body.Close()
+			return nil, fmt.Errorf("%s: %w", key, ErrNotEncrypted)
+		}
+		return readCloser{buffered, body}, nil
+	}
+
+	env, err := readEnvelope(buffered)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	aead, err := e.keys.open(env)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return readCloser{&decryptReader{
+		src:    buffered,
+		aead:   aead,
+		sealed: make([]byte, env.chunkSize+gcmTagSize),
+	}, body}, nil
+}
+
+// Put encrypts r with a new data key as it is stored. A size that is not
+// negative is taken as the plaintext size.
+func (e *Encrypted) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	sealed, size, err := e.encrypt(r, size)
+	if err != nil {
+		return err
+	}
+	return e.Storage.Put(ctx, key, sealed, size)
+}
+
+// Create is Put for a key that must be free, when the backend supports it.
+func (e *Encrypted) Create(ctx context.Context, key string, r io.Reader, size int64) error {
+	creator, ok := e.Storage.(Creator)
+	if !ok {
+		return ErrNotSupported
+	}
+	sealed, size, err := e.encrypt(r, size)
+	if err != nil {
+		return err
+	}
+	return creator.Create(ctx, key, sealed, size)
+}
+
+func (e *Encrypted) encrypt(r io.Reader, size int64) (io.Reader, int64, error) {
+	env, aead, err := e.keys.seal()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create data key: %w", err)
+	}
+	if size >= 0 {
+		size = encryptedSize(env, size)
+	}
+	return newEncryptReader(r, env, aead), size, nil
+}
+
+// Presign is not supported: the URL would hand out the encrypted object.
+func (e *Encrypted) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// DecryptedFile gives random access to the plaintext of an encrypted local
+// file, decrypting only the chunks that are read.
+type DecryptedFile struct {
+	file      *os.File
+	aead      cipher.AEAD
+	offset    int64
+	chunkSize int64
+	chunks    int64
+	size      int64
+
+	mu     sync.Mutex
+	cached int64
+	plain  []byte
+	sealed []byte
+}
+
+// OpenDecrypted opens an encrypted file for reading. It returns
+// ErrNotEncrypted when the file has no encryption header.
+func OpenDecrypted(path string, keys *Keyring) (*DecryptedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	env, err := readEnvelope(bufio.NewReader(file))
+	if err == nil && keys == nil {
+		err = fmt.Errorf("%s is encrypted but no encryption key is configured", path)
+	}
+	var aead cipher.AEAD
+	if err == nil {
+		aead, err = keys.open(env)
+	}
+	var size int64
+	if err == nil {
+		size, err = plainSize(env, info.Size())
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	chunkSize := int64(env.chunkSize)
+	return &DecryptedFile{
+		file:      file,
+		aead:      aead,
+		offset:    env.size(),
+		chunkSize: chunkSize,
+		chunks:    max((size+chunkSize-1)/chunkSize, 1),
+		size:      size,
+		cached:    -1,
+		sealed:    make([]byte, chunkSize+gcmTagSize),
+	}, nil
+}
+
+// Size returns the length of the plaintext.
+func (d *DecryptedFile) Size() int64 {
+	return d.size
+}
+
+func (d *DecryptedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	read := 0
+	for read < len(p) {
+		if off >= d.size {
+			return read, io.EOF
+		}
+		index := off / d.chunkSize
+		if err := d.load(index); err != nil {
+			return read, err
+		}
+		n := copy(p[read:], d.plain[off-index*d.chunkSize:])
+		read += n
+		off += int64(n)
+	}
+	return read, nil
+}
+
+// load decrypts chunk index into the cache, which holds one chunk: ffmpeg
+// mostly reads sequentially, in pieces smaller than a chunk.
+func (d *DecryptedFile) load(index int64) error {
+	if d.cached == index {
+		return nil
+	}
+
+	start := d.offset + index*(d.chunkSize+gcmTagSize)
+	sealed := d.sealed
+	if index == d.chunks-1 {
+		sealed = sealed[:d.size-index*d.chunkSize+gcmTagSize]
+	}
+	if _, err := d.file.ReadAt(sealed, start); err != nil {
+		return fmt.Errorf("failed to read chunk %d: %w", index, err)
+	}
+
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(d.aead, index), sealed, chunkAAD(index == d.chunks-1))
+	if err != nil {
+		d.cached = -1
+		return fmt.Errorf("failed to decrypt chunk %d: %w", index, err)
+	}
+	d.plain = plain
+	d.cached = index
+	return nil
+}
+
+func (d *DecryptedFile) Close() error {
+	return d.file.Close()
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	httpDialTimeout   = 10 * time.Second
+	httpHeaderTimeout = 30 * time.Second
+	httpMaxRedirects  = 5
+)
+
+var (
+	// ErrForbiddenAddress is returned for URLs that resolve to loopback,
+	// private or otherwise internal addresses that are not allow-listed.
+	ErrForbiddenAddress = errors.New("address is not allowed")
+	// ErrTooLarge is returned when a download exceeds the size limit.
+	ErrTooLarge = errors.New("object exceeds the size limit")
+	// ErrContentType is returned when a download has a content type that is
+	// not accepted.
+	ErrContentType = errors.New("content type not accepted")
+)
+
+// HTTPConfig configures reading inputs from http:// and https:// URLs.
+type HTTPConfig struct {
+	// Timeout bounds a whole download, body included. Zero means no limit.
+	Timeout time.Duration
+	// MaxSize is the largest download accepted, in bytes. Zero means no
+	// limit.
+	MaxSize int64
+	// ContentTypes lists the accepted media types; "video/*" accepts every
+	// subtype. Empty accepts anything.
+	ContentTypes []string
+	// Allow lists host names, IP addresses and CIDR ranges that may be
+	// reached even though they are loopback or private.
+	Allow []string
+	// HTTPClient replaces the guarded client built from the settings above.
+	// Tests can point it at an in-process server.
+	HTTPClient *http.Client
+}
+
+// HTTP reads objects from one web server. Keys are the path and query of the
+// URL. It is read-only.
+type HTTP struct {
+	scheme string
+	host   string
+	client *http.Client
+	cfg    *HTTPConfig
+}
+
+// NewHTTPClient returns a client that refuses to connect to internal
+// addresses outside cfg.Allow, whether named in the URL, resolved from DNS or
+// reached through a redirect.
+func NewHTTPClient(cfg HTTPConfig) (*http.Client, error) {
+	guard, err := newAddressGuard(cfg.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		// Proxies from the environment would connect on our behalf and
+		// bypass the guard.
+		Proxy:                 nil,
+		DialContext:           guard.dialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   httpDialTimeout,
+		ResponseHeaderTimeout: httpHeaderTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          10,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= httpMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", httpMaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}, nil
+}
+
+func (h *HTTP) url(key string) string {
+	return h.scheme + "://" + h.host + "/" + key
+}
+
+func (h *HTTP) do(ctx context.Context, method, key string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.url(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Client errors already name the method and URL.
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: unexpected status %s", method, h.url(key), resp.Status)
+	}
+	return resp, nil
+}
+
+// Get streams the object, failing with ErrTooLarge once it passes the size
+// limit and with ErrContentType when the server reports an unaccepted type.
+func (h *HTTP) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := h.do(ctx, http.MethodGet, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if h.cfg.MaxSize <= 0 {
+		return resp.Body, nil
+	}
+	if resp.ContentLength > h.cfg.MaxSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes, limit is %d", ErrTooLarge, resp.ContentLength, h.cfg.MaxSize)
+	}
+	return &limitedBody{body: resp.Body, remaining: h.cfg.MaxSize, limit: h.cfg.MaxSize}, nil
+}
+
+func (h *HTTP) checkContentType(header string) error {
+	if len(h.cfg.ContentTypes) == 0 {
+		return nil
+	}
+
+	mediaType := "application/octet-stream"
+	if header != "" {
+		parsed, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrContentType, header)
+		}
+		mediaType = parsed
+	}
+
+	for _, accepted := range h.cfg.ContentTypes {
+		if prefix, ok := strings.CutSuffix(accepted, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return nil
+			}
+		} else if mediaType == accepted {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrContentType, mediaType)
+}
+
+// Stat asks for the headers only. Servers that do not report a length give a
+// size of zero.
+func (h *HTTP) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	resp, err := h.do(ctx, http.MethodHead, key)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	info := &ObjectInfo{
+		Size: max(resp.ContentLength, 0),
+		ETag: strings.Trim(resp.Header.Get("ETag"), `"`),
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return info, nil
+}
+
+func (h *HTTP) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	return ErrNotSupported
+}
+
+func (h *HTTP) Delete(ctx context.Context, key string) error {
+	return ErrNotSupported
+}
+
+func (h *HTTP) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+// limitedBody fails the read that takes a body past its limit, so servers
+// that send no or a wrong Content-Length cannot fill the disk.
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, l.limit)
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.body.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, l.limit)
+	}
+	return n, err
+}
+
+func (l *limitedBody) Close() error {
+	return l.body.Close()
+}
+
+// addressGuard checks every address a download connects to, after DNS
+// resolution, so a public name pointing at an internal address is caught.
+type addressGuard struct {
+	hosts    map[string]bool
+	prefixes []netip.Prefix
+}
+
+func newAddressGuard(allow []string) (*addressGuard, error) {
+	guard := &addressGuard{hosts: make(map[string]bool)}
+	for _, entry := range allow {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			guard.prefixes = append(guard.prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			guard.prefixes = append(guard.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else if strings.ContainsAny(entry, "/:") {
+			return nil, fmt.Errorf("invalid allow-list entry %q", entry)
+		} else {
+			guard.hosts[entry] = true
+		}
+	}
+	return guard, nil
+}
+
+func (g *addressGuard) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: httpDialTimeout, KeepAlive: 30 * time.Second}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if !g.hosts[strings.ToLower(host)] {
+		dialer.Control = g.control
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// control runs once the address is resolved, right before connecting.
+func (g *addressGuard) control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+	}
+
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range g.prefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if internalAddress(addr) {
+		return fmt.Errorf("%w: %s is an internal address", ErrForbiddenAddress, addr)
+	}
+	return nil
+}
+
+// internalRanges are the special-purpose ranges the netip predicates do not
+// cover.
+var internalRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// nat64Prefix embeds IPv4 addresses in IPv6 ones.
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+func internalAddress(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return true
+	}
+	for _, prefix := range internalRanges {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	if nat64Prefix.Contains(addr) {
+		raw := addr.As16()
+		return internalAddress(netip.AddrFrom4([4]byte(raw[12:])))
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testHTTP returns an HTTP backend for srv, which is reached directly: the
+// address guard is tested on its own below.
+func testHTTP(t *testing.T, srv *httptest.Server, cfg HTTPConfig) *HTTP {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &HTTP{scheme: u.Scheme, host: u.Host, client: srv.Client(), cfg: &cfg}
+}
+
+func TestHTTPGetSizeLimit(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("chunked") {
+			// Flushing before the end drops Content-Length.
+			w.Write([]byte(body[:10]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body[10:]))
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		key     string
+		maxSize int64
+		// getErr is whether Get itself fails, readErr whether reading the
+		// body does.
+		getErr  bool
+		readErr bool
+	}{
+		{name: "no limit", key: "file", maxSize: 0},
+		{name: "at the limit", key: "file", maxSize: 100},
+		{name: "over the limit", key: "file", maxSize: 99, getErr: true},
+		{name: "chunked at the limit", key: "file?chunked", maxSize: 100},
+		{name: "chunked over the limit", key: "file?chunked", maxSize: 99, readErr: true},
+		{name: "chunked far over the limit", key: "file?chunked", maxSize: 5, readErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testHTTP(t, srv, HTTPConfig{MaxSize: tt.maxSize})
+
+			r, err := store.Get(context.Background(), tt.key)
+			if tt.getErr {
+				if !errors.Is(err, ErrTooLarge) {
+					t.Fatalf("Get error = %v, want ErrTooLarge", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			defer r.Close()
+
+			data, err := io.ReadAll(r)
+			if tt.readErr {
+				if !errors.Is(err, ErrTooLarge) {
+					t.Fatalf("read error = %v, want ErrTooLarge", err)
+				}
+				if int64(len(data)) > tt.maxSize+1 {
+					t.Errorf("read %d bytes past a limit of %d", len(data), tt.maxSize)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(data) != body {
+				t.Errorf("read %q, want %q", data, body)
+			}
+		})
+	}
+}
+
+func TestHTTPGetContentType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// An empty value is sent as it is instead of being sniffed.
+		w.Header()["Content-Type"] = []string{r.URL.Query().Get("type")}
+		w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+
+	accepted := []string{"video/*", "application/octet-stream"}
+	tests := []struct {
+		contentType string
+		accepted    []string
+		ok          bool
+	}{
+		{contentType: "video/mp4", accepted: accepted, ok: true},
+		{contentType: "video/webm; codecs=vp9", accepted: accepted, ok: true},
+		{contentType: "application/octet-stream", accepted: accepted, ok: true},
+		{contentType: "", accepted: accepted, ok: true},
+		{contentType: "text/html; charset=utf-8", accepted: accepted},
+		{contentType: "videos/mp4", accepted: accepted},
+		{contentType: "not a type", accepted: accepted},
+		{contentType: "text/html", accepted: nil, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			store := testHTTP(t, srv, HTTPConfig{ContentTypes: tt.accepted})
+
+			r, err := store.Get(context.Background(), "file?type="+url.QueryEscape(tt.contentType))
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("Get: %v", err)
+				}
+				r.Close()
+				return
+			}
+			if !errors.Is(err, ErrContentType) {
+				t.Fatalf("Get error = %v, want ErrContentType", err)
+			}
+		})
+	}
+}
+
+func TestHTTPClientAddressGuard(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	// Both servers listen on 127.0.0.1. Reaching the redirecting one as
+	// "localhost" lets a host entry of the allow-list admit it alone.
+	_, redirectPort, _ := strings.Cut(strings.TrimPrefix(redirect.URL, "http://"), ":")
+	viaLocalhost := "http://localhost:" + redirectPort
+
+	tests := []struct {
+		name  string
+		allow []string
+		url   string
+		ok    bool
+	}{
+		{name: "loopback", url: target.URL},
+		{name: "redirect to loopback", allow: []string{"localhost"}, url: viaLocalhost},
+		{name: "allowed address", allow: []string{"127.0.0.1"}, url: target.URL, ok: true},
+		{name: "allowed range", allow: []string{"127.0.0.0/8"}, url: target.URL, ok: true},
+		{name: "allowed redirect target", allow: []string{"localhost", "127.0.0.1"}, url: viaLocalhost, ok: true},
+		{name: "other range allowed", allow: []string{"10.0.0.0/8"}, url: target.URL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewHTTPClient(HTTPConfig{Allow: tt.allow})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := client.Get(tt.url)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("Get: %v", err)
+				}
+				resp.Body.Close()
+				return
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+			if !errors.Is(err, ErrForbiddenAddress) {
+				t.Fatalf("Get error = %v, want ErrForbiddenAddress", err)
+			}
+		})
+	}
+}
+
+func TestAddressGuardControl(t *testing.T) {
+	guard, err := newAddressGuard([]string{"203.0.113.0/24", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		address string
+		ok      bool
+	}{
+		{address: "93.184.216.34:80", ok: true},
+		{address: "[2606:4700::1111]:443", ok: true},
+		{address: "127.0.0.1:80"},
+		{address: "10.1.2.3:80"},
+		{address: "172.16.0.1:80"},
+		{address: "192.168.1.1:80"},
+		{address: "169.254.169.254:80"},
+		{address: "100.64.0.1:80"},
+		{address: "0.0.0.0:80"},
+		{address: "[::1]:80"},
+		{address: "[fe80::1]:80"},
+		{address: "[fd00::1]:80"},
+		{address: "[::ffff:127.0.0.1]:80"},
+		{address: "[64:ff9b::a9fe:a9fe]:80"},
+		{address: "203.0.113.7:80", ok: true},
+		{address: "[2001:db8::1]:80", ok: true},
+		{address: "[2001:db8::2]:80", ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := guard.control("tcp", tt.address, nil)
+			if tt.ok && err != nil {
+				t.Fatalf("control: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrForbiddenAddress) {
+				t.Fatalf("control error = %v, want ErrForbiddenAddress", err)
+			}
+		})
+	}
+
+	if _, err := newAddressGuard([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("newAddressGuard accepted an invalid range")
+	}
+}
+
+func TestManagerDownloadChecksum(t *testing.T) {
+	content := []byte("input content")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	m, err := NewManager(nil, nil, &HTTPConfig{HTTPClient: srv.Client()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, err := ParseURI(srv.URL + "/input.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(content)
+	other := sha256.Sum256([]byte("other content"))
+	tests := []struct {
+		name     string
+		checksum string
+		err      error
+	}{
+		{name: "no checksum"},
+		{name: "matching", checksum: "sha256:" + hex.EncodeToString(sum[:])},
+		{name: "bare digest", checksum: hex.EncodeToString(sum[:])},
+		{name: "mismatch", checksum: "sha256:" + hex.EncodeToString(other[:]), err: ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "input.mp4")
+
+			err := m.Download(context.Background(), uri, dst, tt.checksum)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Download error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			data, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != string(content) {
+				t.Errorf("downloaded %q, want %q", data, content)
+			}
+		})
+	}
+
+	if err := m.Download(context.Background(), uri, filepath.Join(t.TempDir(), "x"), "md5:abc"); err == nil {
+		t.Error("Download accepted an unsupported checksum algorithm")
+	}
+}
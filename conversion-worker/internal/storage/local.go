@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Local stores objects as files below Root. When Dirs is set, objects must
+// also be below one of those directories.
+type Local struct {
+	Root string
+	Dirs []string
+}
+
+func NewLocal(root string, dirs ...string) *Local {
+	local := &Local{Root: filepath.Clean(root)}
+	for _, dir := range dirs {
+		local.Dirs = append(local.Dirs, filepath.Clean(dir))
+	}
+	return local
+}
+
+// path maps a key to a file below Root and Dirs, refusing keys that would
+// escape them.
+func (l *Local) path(key string) (string, error) {
+	full := filepath.Join(l.Root, filepath.FromSlash(key))
+	if !within(full, l.Root) {
+		return "", fmt.Errorf("key %q is outside the storage root", key)
+	}
+	if len(l.Dirs) > 0 && !slices.ContainsFunc(l.Dirs, func(dir string) bool { return within(full, dir) }) {
+		return "", fmt.Errorf("key %q is outside the storage directories", key)
+	}
+	return full, nil
+}
+
+// within reports whether path is dir or lies below it. Both must be clean.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Put writes to a temporary file next to the destination, flushes it to disk
+// and renames it, so readers never see a partial object.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// PutFile flushes a local file to disk and moves it into place, copying only
+// when it sits on another filesystem.
+func (l *Local) PutFile(ctx context.Context, key, src string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := syncFile(src); err != nil {
+		return err
+	}
+	if err := os.Rename(src, path); err == nil {
+		return syncDir(filepath.Dir(path))
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := l.Put(ctx, key, file, -1); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Create is Put for a key that must be free. The file is written next to
+// the destination and hard-linked into place, which fails if the name is
+// taken, so the object appears complete or not at all.
+func (l *Local) Create(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return link(tmp.Name(), path)
+}
+
+// CreateFile is PutFile for a key that must be free. The file is linked
+// into place and then removed, copying only when it sits on another
+// filesystem. It is left in place when the key is taken.
+func (l *Local) CreateFile(ctx context.Context, key, src string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := syncFile(src); err != nil {
+		return err
+	}
+
+	err = link(src, path)
+	if err == nil {
+		return os.Remove(src)
+	}
+	if errors.Is(err, ErrExists) {
+		return err
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := l.Create(ctx, key, file, -1); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// link creates path as a new name of the file at src, durably. It fails with
+// ErrExists when path exists.
+func link(src, path string) error {
+	if err := os.Link(src, path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return ErrExists
+		}
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Presign is not supported: local files have no URL of their own.
+func (l *Local) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func syncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	return syncFile(dir)
+}
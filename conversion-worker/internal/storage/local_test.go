@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalCreate(t *testing.T) {
+	store := NewLocal(t.TempDir())
+	ctx := context.Background()
+
+	if err := store.Create(ctx, "a/out.mp4", strings.NewReader("first"), -1); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := store.Create(ctx, "a/out.mp4", strings.NewReader("second"), -1); !errors.Is(err, ErrExists) {
+		t.Errorf("Create of a taken key error = %v, want ErrExists", err)
+	}
+
+	src := filepath.Join(t.TempDir(), "converted.mp4")
+	if err := os.WriteFile(src, []byte("third"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateFile(ctx, "a/out.mp4", src); !errors.Is(err, ErrExists) {
+		t.Errorf("CreateFile of a taken key error = %v, want ErrExists", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("CreateFile removed the source of a failed create: %v", err)
+	}
+	if err := store.CreateFile(ctx, "b/out.mp4", src); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+	if _, err := os.Stat(src); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("CreateFile left the source in place: %v", err)
+	}
+
+	for key, want := range map[string]string{"a/out.mp4": "first", "b/out.mp4": "third"} {
+		data, err := os.ReadFile(filepath.Join(store.Root, key))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v; want %q", key, data, err, want)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Join(store.Root, "a")); len(entries) != 1 {
+		t.Errorf("Create left temporary files: %v", entries)
+	}
+}
+
+func TestLocalDirs(t *testing.T) {
+	store := NewLocal("/", "/tmp/input", "/tmp/output/")
+
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{key: "/tmp/input/a.mp4", ok: true},
+		{key: "/tmp/output/cache/b.mp4", ok: true},
+		{key: "/tmp/output", ok: true},
+		{key: "/tmp/inputs/a.mp4"},
+		{key: "/tmp/input/../secret"},
+		{key: "/etc/passwd"},
+	}
+	for _, tt := range tests {
+		_, err := store.path(tt.key)
+		if (err == nil) != tt.ok {
+			t.Errorf("path(%q) error = %v, want ok %v", tt.key, err, tt.ok)
+		}
+	}
+
+	if _, err := (&Manager{local: NewLocal("/")}).LocalPath(URI{Scheme: "file", Key: "/tmp/input/a.mp4"}); err == nil {
+		t.Error("LocalPath without directories succeeded")
+	}
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultS3Region = "us-east-1"
+
+// S3Config configures access to AWS S3 or a compatible store such as MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. "http://minio:9000".
+	// Empty means AWS in Region.
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle puts the bucket in the path instead of the host name, which
+	// MinIO and most self-hosted stores require.
+	PathStyle bool
+	// HTTPClient is used for every request; nil means http.DefaultClient.
+	// Tests can point it at an in-process fake.
+	HTTPClient *http.Client
+}
+
+// S3 stores objects in one bucket of an S3-compatible service.
+type S3 struct {
+	bucket    string
+	endpoint  *url.URL
+	pathStyle bool
+	client    *http.Client
+	signer    signer
+	now       func() time.Time
+}
+
+func NewS3(cfg S3Config, bucket string) (*S3, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultS3Region
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &S3{
+		bucket:    bucket,
+		endpoint:  endpoint,
+		pathStyle: cfg.PathStyle,
+		client:    client,
+		signer: signer{
+			region:    cfg.Region,
+			accessKey: cfg.AccessKeyID,
+			secretKey: cfg.SecretAccessKey,
+		},
+		now: time.Now,
+	}, nil
+}
+
+// objectURL addresses key either as endpoint/bucket/key or, virtual-host
+// style, as bucket.endpoint/key.
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	if s.pathStyle {
+		u.Path = base + "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = canonicalPath(u.Path)
+	u.RawQuery = ""
+	return &u
+}
+
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := hashHex(nil)
+	if body != nil {
+		// Streamed uploads are not hashed up front; TLS or the endpoint's
+		// own checks guard the payload instead.
+		payloadHash = unsignedPayload
+		req.ContentLength = size
+	}
+	s.signer.signRequest(req, payloadHash, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s: %w", method, key, err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, s.responseError(method, key, resp)
+	}
+	return resp, nil
+}
+
+// s3Error is the XML body S3 sends with a failed request.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (s *S3) responseError(method, key string, resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusPreconditionFailed:
+		return ErrExists
+	}
+
+	var body s3Error
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	// Conditional writes racing for one key can fail with this instead of
+	// 412; either way the key is taken.
+	if xml.Unmarshal(content, &body) == nil && body.Code == "ConditionalRequestConflict" {
+		return ErrExists
+	}
+	if body.Code != "" {
+		return fmt.Errorf("S3 %s %s: %s: %s", method, key, body.Code, body.Message)
+	}
+	return fmt.Errorf("S3 %s %s: unexpected status %s", method, key, resp.Status)
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put uploads r in a single request, so size must be known; S3 rejects
+// uploads without a length.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	return s.put(ctx, key, r, size, nil)
+}
+
+// Create is Put with "If-None-Match: *", which S3 fails when key exists.
+func (s *S3) Create(ctx context.Context, key string, r io.Reader, size int64) error {
+	return s.put(ctx, key, r, size, http.Header{"If-None-Match": {"*"}})
+}
+
+func (s *S3) put(ctx context.Context, key string, r io.Reader, size int64, header http.Header) error {
+	if size < 0 {
+		return fmt.Errorf("S3 PUT %s: object size is required", key)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, r, size, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	info := &ObjectInfo{ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}
+	info.Size, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// Delete removes key. S3 reports success for keys that don't exist, so
+// unlike Local it never returns ErrNotFound.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", fmt.Errorf("presigned URL expiry must be between 1s and 7 days")
+	}
+	return s.signer.presign(s.objectURL(key), expires, s.now()), nil
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as far as S3 needs it: header signing for
+// requests and query signing for presigned URLs.
+
+const (
+	sigv4Algorithm  = "AWS4-HMAC-SHA256"
+	sigv4Service    = "s3"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateFormat   = "20060102T150405Z"
+	amzDayFormat    = "20060102"
+)
+
+type signer struct {
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// signRequest adds the date, payload hash and Authorization headers.
+func (s *signer) signRequest(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "content-md5" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := s.scope(now)
+	signature := s.signature(now, scope, canonical)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigv4Algorithm, s.accessKey, scope, signedHeaders, signature))
+}
+
+// presign returns u with the query parameters that authorise a GET until
+// now+expires. Only the host header is signed, so any client can use it.
+func (s *signer) presign(u *url.URL, expires time.Duration, now time.Time) string {
+	now = now.UTC()
+	scope := s.scope(now)
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", sigv4Algorithm)
+	query.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	query.Set("X-Amz-Date", now.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		canonicalPath(u.Path),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	query.Set("X-Amz-Signature", s.signature(now, scope, canonical))
+
+	signed := *u
+	signed.RawQuery = canonicalQuery(query)
+	return signed.String()
+}
+
+func (s *signer) scope(now time.Time) string {
+	return fmt.Sprintf("%s/%s/%s/aws4_request", now.Format(amzDayFormat), s.region, sigv4Service)
+}
+
+func (s *signer) signature(now time.Time, scope, canonical string) string {
+	stringToSign := strings.Join([]string{
+		sigv4Algorithm,
+		now.Format(amzDateFormat),
+		scope,
+		hashHex([]byte(canonical)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format(amzDayFormat))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, sigv4Service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalPath encodes every path segment as SigV4 expects, keeping the
+// slashes between them.
+func canonicalPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but the RFC 3986 unreserved
+// characters, which is stricter than url.QueryEscape.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("object not found")
+	ErrExists       = errors.New("object already exists")
+	ErrNotSupported = errors.New("operation not supported by this storage backend")
+)
+
+// Storage is a place inputs are read from and outputs written to. Keys are
+// slash-separated object names within the backend.
+type Storage interface {
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	// Presign returns a URL that grants read access to the object until it
+	// expires, without credentials.
+	Presign(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// FilePutter is implemented by backends that can take a local file more
+// cheaply than by streaming it, such as by renaming it.
+type FilePutter interface {
+	PutFile(ctx context.Context, key, path string) error
+}
+
+// Creator is implemented by backends that can store an object only if its
+// key is free, atomically, failing with ErrExists otherwise.
+type Creator interface {
+	Create(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// FileCreator is the FilePutter counterpart of Creator.
+type FileCreator interface {
+	CreateFile(ctx context.Context, key, path string) error
+}
+
+type ObjectInfo struct {
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// URI locates an object: "file:///tmp/input/a.mp4", "s3://bucket/a.mp4" or
+// "https://example.com/a.mp4". A plain absolute path is read as a file URI.
+// For web URLs Bucket is the host and Key the escaped path and query.
+type URI struct {
+	Scheme string
+	Bucket string
+	Key    string
+}
+
+func ParseURI(raw string) (URI, error) {
+	if strings.HasPrefix(raw, "/") {
+		return URI{Scheme: "file", Key: path.Clean(raw)}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return URI{}, fmt.Errorf("invalid storage URI %q: %w", raw, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return URI{}, fmt.Errorf("invalid storage URI %q: remote file hosts are not supported", raw)
+		}
+		return URI{Scheme: "file", Key: path.Clean("/" + u.Path)}, nil
+	case "s3":
+		if u.Host == "" {
+			return URI{}, fmt.Errorf("invalid storage URI %q: missing bucket", raw)
+		}
+		return URI{Scheme: "s3", Bucket: u.Host, Key: strings.TrimPrefix(u.Path, "/")}, nil
+	case "http", "https":
+		if u.Host == "" {
+			return URI{}, fmt.Errorf("invalid storage URI %q: missing host", raw)
+		}
+		if u.User != nil {
+			return URI{}, fmt.Errorf("invalid storage URI %q: credentials in URLs are not supported", raw)
+		}
+		key := strings.TrimPrefix(u.EscapedPath(), "/")
+		if u.RawQuery != "" {
+			key += "?" + u.RawQuery
+		}
+		return URI{Scheme: u.Scheme, Bucket: u.Host, Key: key}, nil
+	}
+	return URI{}, fmt.Errorf("unsupported storage URI scheme %q", u.Scheme)
+}
+
+func (u URI) String() string {
+	if u.Scheme == "file" {
+		return "file://" + u.Key
+	}
+	return fmt.Sprintf("%s://%s/%s", u.Scheme, u.Bucket, u.Key)
+}
+
+// Join returns the URI of name inside the "directory" u.
+func (u URI) Join(name string) URI {
+	u.Key = path.Join(u.Key, name)
+	if u.Scheme != "file" {
+		u.Key = strings.TrimPrefix(u.Key, "/")
+	}
+	return u
+}
+
+// Base returns the last element of the key, without the query of a web URL.
+func (u URI) Base() string {
+	key := u.Key
+	if u.IsWeb() {
+		key, _, _ = strings.Cut(key, "?")
+		if unescaped, err := url.PathUnescape(key); err == nil {
+			key = unescaped
+		}
+	}
+	return path.Base(key)
+}
+
+// IsWeb reports whether the object is read from a web server over http(s).
+func (u URI) IsWeb() bool {
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// IsLocal reports whether the object lives on the local filesystem, where
+// ffmpeg can read it directly.
+func (u URI) IsLocal() bool {
+	return u.Scheme == "file"
+}
+
+// Manager opens the backend a URI belongs to. S3 backends are created per
+// bucket on first use and share one configuration; web servers share one
+// guarded HTTP client. With a keyring every backend is wrapped in Encrypted.
+type Manager struct {
+	local *Local
+	s3    *S3Config
+	web   *HTTPConfig
+	http  *http.Client
+	keys  *Keyring
+	// requireEncryption rejects objects stored in plaintext.
+	requireEncryption bool
+
+	mu      sync.Mutex
+	buckets map[string]*S3
+}
+
+var errNoLocalStorage = errors.New("local storage is not configured")
+
+// NewManager returns a Manager for local files below localDirs and, when s3
+// and web are not nil, S3-compatible buckets and http(s) URLs. Without
+// localDirs, file:// URIs are refused. When keys is not nil objects are
+// encrypted at rest.
+func NewManager(localDirs []string, s3 *S3Config, web *HTTPConfig, keys *Keyring) (*Manager, error) {
+	dirs := make([]string, 0, len(localDirs))
+	for _, dir := range localDirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage directory %q: %w", dir, err)
+		}
+		dirs = append(dirs, abs)
+	}
+
+	m := &Manager{
+		local:   NewLocal("/", dirs...),
+		s3:      s3,
+		web:     web,
+		keys:    keys,
+		buckets: make(map[string]*S3),
+	}
+
+	if web != nil {
+		m.http = web.HTTPClient
+		if m.http == nil {
+			client, err := NewHTTPClient(*web)
+			if err != nil {
+				return nil, err
+			}
+			m.http = client
+		}
+	}
+	return m, nil
+}
+
+// Open returns the backend for uri, encrypting and decrypting objects when
+// encryption is enabled.
+func (m *Manager) Open(uri URI) (Storage, error) {
+	store, err := m.open(uri)
+	if err != nil || m.keys == nil {
+		return store, err
+	}
+	encrypted := NewEncrypted(store, m.keys)
+	encrypted.require = m.requireEncryption
+	return encrypted, nil
+}
+
+// RequireEncryption makes the Manager reject objects without an encryption
+// header, instead of reading them as plaintext. It has no effect when
+// encryption is off.
+func (m *Manager) RequireEncryption() {
+	m.requireEncryption = m.keys != nil
+}
+
+// EncryptionRequired reports whether objects stored in plaintext are
+// rejected.
+func (m *Manager) EncryptionRequired() bool {
+	return m.requireEncryption
+}
+
+// EncryptionKeyID returns the master key new objects are encrypted with, or
+// "" when encryption is off.
+func (m *Manager) EncryptionKeyID() string {
+	if m.keys == nil {
+		return ""
+	}
+	return m.keys.KeyID()
+}
+
+// OpenDecrypted opens the local file at path for random access to its
+// plaintext. It returns ErrNotEncrypted for files stored in plaintext.
+func (m *Manager) OpenDecrypted(path string) (*DecryptedFile, error) {
+	return OpenDecrypted(path, m.keys)
+}
+
+// CreateEncrypted creates a local file that is encrypted as it is written.
+// It fails when encryption is off.
+func (m *Manager) CreateEncrypted(path string) (*EncryptedFile, error) {
+	return CreateEncrypted(path, m.keys)
+}
+
+// LocalPath returns the path of a file:// URI, for files read in place. Like
+// every local object, it must be below one of the Manager's directories.
+func (m *Manager) LocalPath(uri URI) (string, error) {
+	if len(m.local.Dirs) == 0 {
+		return "", errNoLocalStorage
+	}
+	return m.local.path(uri.Key)
+}
+
+// PresignStored returns a URL to the object at uri as it is stored, which
+// for encrypted objects is the ciphertext. Holders of the master key, such
+// as the API, decrypt what they fetch from it.
+func (m *Manager) PresignStored(ctx context.Context, uri URI, expires time.Duration) (string, error) {
+	store, err := m.open(uri)
+	if err != nil {
+		return "", err
+	}
+	return store.Presign(ctx, uri.Key, expires)
+}
+
+func (m *Manager) open(uri URI) (Storage, error) {
+	switch uri.Scheme {
+	case "file":
+		if len(m.local.Dirs) == 0 {
+			return nil, errNoLocalStorage
+		}
+		return m.local, nil
+	case "s3":
+		if m.s3 == nil {
+			return nil, fmt.Errorf("S3 storage is not configured")
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		store, ok := m.buckets[uri.Bucket]
+		if !ok {
+			var err error
+			if store, err = NewS3(*m.s3, uri.Bucket); err != nil {
+				return nil, err
+			}
+			m.buckets[uri.Bucket] = store
+		}
+		return store, nil
+	case "http", "https":
+		if m.web == nil {
+			return nil, fmt.Errorf("HTTP inputs are not enabled")
+		}
+		return &HTTP{scheme: uri.Scheme, host: uri.Bucket, client: m.http, cfg: m.web}, nil
+	}
+	return nil, fmt.Errorf("unsupported storage URI scheme %q", uri.Scheme)
+}
+
+// Download copies the object at uri to the local file dst as it is stored:
+// encrypted objects stay encrypted, to be read through OpenDecrypted. When
+// checksum is not empty the stored content must match it; see
+// ParseChecksum.
+func (m *Manager) Download(ctx context.Context, uri URI, dst, checksum string) error {
+	verifier, err := newVerifier(checksum)
+	if err != nil {
+		return err
+	}
+	store, err := m.open(uri)
+	if err != nil {
+		return err
+	}
+
+	body, err := store.Get(ctx, uri.Key)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", uri, err)
+	}
+	defer body.Close()
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	if _, err := io.Copy(io.MultiWriter(file, verifier), body); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to download %s: %w", uri, err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := verifier.verify(); err != nil {
+		return fmt.Errorf("download of %s failed verification: %w", uri, err)
+	}
+	return nil
+}
+
+// Upload stores the local file src at uri. Files written by CreateEncrypted
+// are stored as they are; other files are encrypted on the way when
+// encryption is on, and then removed, so the plaintext does not outlive the
+// upload.
+func (m *Manager) Upload(ctx context.Context, src string, uri URI) error {
+	return m.upload(ctx, src, uri, false)
+}
+
+// UploadNew is Upload for a uri that must be free. When an object is already
+// stored there it fails with ErrExists and leaves src in place, so the
+// caller can try another name.
+func (m *Manager) UploadNew(ctx context.Context, src string, uri URI) error {
+	return m.upload(ctx, src, uri, true)
+}
+
+func (m *Manager) upload(ctx context.Context, src string, uri URI, exclusive bool) (err error) {
+	sealed, err := IsEncryptedFile(src)
+	if err != nil {
+		return err
+	}
+
+	var store Storage
+	if sealed {
+		store, err = m.open(uri)
+	} else {
+		store, err = m.Open(uri)
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := store.(*Encrypted); ok {
+		defer func() {
+			if !errors.Is(err, ErrExists) {
+				os.Remove(src)
+			}
+		}()
+	}
+
+	if exclusive {
+		err = createObject(ctx, store, uri.Key, src)
+	} else {
+		err = putObject(ctx, store, uri.Key, src)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", uri, err)
+	}
+	return nil
+}
+
+func putObject(ctx context.Context, store Storage, key, src string) error {
+	if putter, ok := store.(FilePutter); ok {
+		return putter.PutFile(ctx, key, src)
+	}
+
+	file, size, err := openSized(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return store.Put(ctx, key, file, size)
+}
+
+func createObject(ctx context.Context, store Storage, key, src string) error {
+	if creator, ok := store.(FileCreator); ok {
+		return creator.CreateFile(ctx, key, src)
+	}
+	creator, ok := store.(Creator)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	file, size, err := openSized(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return creator.Create(ctx, key, file, size)
+}
+
+func openSized(path string) (*os.File, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
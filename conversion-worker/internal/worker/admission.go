@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/converter"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/storage"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/pkg/logger"
+)
+
+// outputSizeMargin covers the error of estimating the output from the input
+// bitrate: encoders overshoot, and intermediate files need room too.
+const outputSizeMargin = 1.5
+
+var errResourceUnknown = errors.New("resource usage cannot be measured on this system")
+
+// AdmissionSettings sets the resources that must stay free while a job runs.
+type AdmissionSettings struct {
+	// MinFreeDisk is kept free on the work and output filesystems on top of
+	// what the job is estimated to need.
+	MinFreeDisk int64
+	// MinFreeMemory must be available before a job starts.
+	MinFreeMemory int64
+	// RetryDelay is how long a worker waits after deferring a job.
+	RetryDelay time.Duration
+	// MaxDeferrals is how often a job may be deferred before it fails
+	// instead; 0 defers it for as long as it does not fit.
+	MaxDeferrals int
+}
+
+// resourceShortage means the job does not fit right now but may once other
+// jobs finish, so it is deferred rather than failed.
+type resourceShortage struct {
+	reason string
+}
+
+func (e *resourceShortage) Error() string {
+	return "insufficient resources: " + e.reason
+}
+
+// admit checks that the job's inputs, its estimated output and the
+// configured reserves fit in the free disk space and memory. It returns a
+// *resourceShortage when the job should wait, and another error when it can
+// never fit on this worker.
+func (w *Worker) admit(ctx context.Context, job *models.JobData) error {
+	downloads, output := w.estimateJobSize(ctx, job)
+	reserve := w.settings.Admission.MinFreeDisk
+
+	if err := checkDisk(w.settings.WorkDir, downloads+output+reserve); err != nil {
+		return err
+	}
+	if w.settings.OutputURI.IsLocal() {
+		if err := checkDisk(w.settings.OutputURI.Key, output+reserve); err != nil {
+			return err
+		}
+	}
+
+	if required := w.settings.Admission.MinFreeMemory; required > 0 {
+		available, err := availableMemory()
+		if err == nil && available < required {
+			return &resourceShortage{fmt.Sprintf("%d bytes of memory available, %d required", available, required)}
+		}
+		if err != nil && !errors.Is(err, errResourceUnknown) {
+			logger.Warn("Worker %d - Could not read available memory: %v", w.info.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// checkDisk reports a shortage when the filesystem holding dir has less than
+// needed bytes free, and a permanent error when it is not even that large.
+func checkDisk(dir string, needed int64) error {
+	free, total, err := diskSpace(nearestDir(dir))
+	if errors.Is(err, errResourceUnknown) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if needed > total {
+		return fmt.Errorf("job needs about %d bytes in %s, more than the filesystem holds (%d bytes)", needed, dir, total)
+	}
+	if needed > free {
+		return &resourceShortage{fmt.Sprintf("%d bytes free in %s, about %d required", free, dir, needed)}
+	}
+	return nil
+}
+
+// nearestDir returns dir, or its closest existing parent for directories that
+// are only created when the first job runs.
+func nearestDir(dir string) string {
+	for {
+		parent := filepath.Dir(dir)
+		if _, _, err := diskSpace(dir); err == nil || parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// estimateJobSize returns the bytes remote inputs take once downloaded and
+// the expected size of the output. The output is estimated from the probed
+// bitrate and duration of the main input, or from its size when it cannot
+// be probed before download.
+func (w *Worker) estimateJobSize(ctx context.Context, job *models.JobData) (downloads, output int64) {
+	uris := []string{job.InputURI}
+	for _, input := range job.Inputs {
+		if input.URI != job.InputURI {
+			uris = append(uris, input.URI)
+		}
+	}
+
+	var mainSize int64
+	for i, raw := range uris {
+		size, remote := w.inputSize(ctx, raw)
+		if remote {
+			downloads += size
+		}
+		if i == 0 {
+			mainSize = size
+		}
+	}
+
+	output = mainSize
+	if uri, err := storage.ParseURI(job.InputURI); err == nil && uri.IsLocal() {
+		if estimate := estimateOutputSize(ctx, uri.Key, job.Format, job.Options); estimate > 0 {
+			output = estimate
+		}
+	}
+	if job.Options.MaxSize > 0 {
+		output = int64(job.Options.MaxSize)
+	}
+
+	return downloads, int64(math.Ceil(float64(output) * outputSizeMargin))
+}
+
+// inputSize returns the size of the object at raw and whether it has to be
+// downloaded. Unknown sizes count as zero.
+func (w *Worker) inputSize(ctx context.Context, raw string) (int64, bool) {
+	uri, err := storage.ParseURI(raw)
+	if err != nil {
+		return 0, false
+	}
+	store, err := w.settings.Storage.Open(uri)
+	if err != nil {
+		return 0, !uri.IsLocal()
+	}
+	info, err := store.Stat(ctx, uri.Key)
+	if err != nil {
+		return 0, !uri.IsLocal()
+	}
+	return info.Size, !uri.IsLocal()
+}
+
+// pcmTargets are the audio targets whose size follows the sample rate and
+// channels of the audio rather than the bitrate of the input, as the share
+// of 16-bit PCM they take. A compressed input converted to them grows
+// several times over.
+var pcmTargets = map[string]float64{
+	"wav":  1,
+	"flac": 0.6,
+}
+
+// estimateOutputSize multiplies the bitrate of the output by the duration it
+// will have. The bitrate is the input's, except for PCM and lossless
+// targets, where it comes from the first audio stream.
+func estimateOutputSize(ctx context.Context, input, format string, opts models.JobOptions) int64 {
+	probe, err := converter.Probe(ctx, input)
+	if err != nil {
+		return 0
+	}
+
+	duration := probe.Duration()
+	if t := opts.Transform; t != nil && t.Speed > 0 && converter.TransformApplies(format) {
+		duration /= t.Speed
+	}
+
+	bitRate := float64(probe.BitRate())
+	if share, ok := pcmTargets[strings.ToLower(format)]; ok {
+		if audio := probe.StreamsOfType("audio"); len(audio) > 0 {
+			sampleRate, _ := strconv.ParseFloat(audio[0].SampleRate, 64)
+			if pcm := sampleRate * float64(max(audio[0].Channels, 1)) * 16 * share; pcm > 0 {
+				bitRate = pcm
+			}
+		}
+	}
+	return int64(bitRate / 8 * duration)
+}
+
+// deferJob puts a job the worker cannot take yet back on its queue and waits
+// before taking the next one, so a short worker does not spin on it. It
+// returns an error when the job could not be requeued; the caller then fails
+// the job, since running it without the resources it needs is what admission
+// control is there to prevent.
+func (w *Worker) deferJob(ctx context.Context, job *models.JobData, shortage *resourceShortage) error {
+	if err := w.queue.RequeueJob(ctx, w.info.QueueName, job); err != nil {
+		return fmt.Errorf("%v; could not defer the job: %w", shortage, err)
+	}
+	logger.Warn("Worker %d [%s] - Deferred job %s: %v", w.info.ID, w.info.Type, job.ID, shortage)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(w.settings.Admission.RetryDelay):
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+var assetIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// resolveAssets replaces references to stored assets in the job options with
+// their paths inside the assets directory. Options never name files
+// directly, so a job cannot make ffmpeg read arbitrary files of the worker.
+func (w *Worker) resolveAssets(job *models.JobData) error {
+	if wm := job.Options.Watermark; wm != nil && wm.AssetID != "" {
+		path, err := w.assetPath(wm.AssetID)
+		if err != nil {
+			return fmt.Errorf("invalid watermark asset: %w", err)
+		}
+		wm.Path = path
+	}
+
+	if subs := job.Options.BurnSubtitles; subs != nil && subs.AssetID != "" {
+		path, err := w.assetPath(subs.AssetID)
+		if err != nil {
+			return fmt.Errorf("invalid subtitle asset: %w", err)
+		}
+		subs.Path = path
+	}
+
+	if md := job.Options.Metadata; md != nil && md.CoverArtAssetID != "" {
+		path, err := w.assetPath(md.CoverArtAssetID)
+		if err != nil {
+			return fmt.Errorf("invalid cover art asset: %w", err)
+		}
+		md.CoverArtPath = path
+	}
+
+	for i := range job.Options.TextOverlays {
+		overlay := &job.Options.TextOverlays[i]
+		if overlay.FontAssetID == "" {
+			continue
+		}
+		path, err := w.assetPath(overlay.FontAssetID)
+		if err != nil {
+			return fmt.Errorf("invalid font asset of text overlay %d: %w", i, err)
+		}
+		overlay.FontPath = path
+	}
+
+	return nil
+}
+
+// assetPath finds the stored asset with the given ID. Assets are saved as
+// "<id>.<ext>", so the ID is matched regardless of the file extension.
+func (w *Worker) assetPath(id string) (string, error) {
+	if !assetIDPattern.MatchString(id) {
+		return "", fmt.Errorf("malformed asset ID %q", id)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(w.settings.AssetsDir, id+".*"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("asset %s not found", id)
+	}
+
+	return matches[0], nil
+}
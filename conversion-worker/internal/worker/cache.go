@@ -0,0 +1,238 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/storage"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/pkg/logger"
+)
+
+// cacheKeyVersion changes whenever the key layout or the meaning of a cached
+// output changes, so stale entries are never reused.
+const cacheKeyVersion = "v1"
+
+// cacheDir is where outputs offered to the cache are stored, under
+// STORAGE_OUTPUT_URI, named by their cache key.
+const cacheDir = "cache"
+
+// Cache outcomes recorded in the task metadata under "cache".
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// CacheSettings configures the conversion cache. A zero TTL disables it.
+// Expired entries are collected by Retention.
+type CacheSettings struct {
+	TTL time.Duration
+}
+
+func (c CacheSettings) enabled() bool {
+	return c.TTL > 0
+}
+
+// cacheKey hashes everything that determines the output: the content of every
+// input and of every file the options point at, the target format and the
+// options themselves. Options are encoded as JSON, whose field order is fixed
+// by the struct definitions.
+func cacheKey(job *models.JobData, input string, inputs []models.InputFile) (string, error) {
+	options, err := json.Marshal(job.Options)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\nformat=%s\noptions=%s\n", cacheKeyVersion, strings.ToLower(strings.TrimSpace(job.Format)), options)
+
+	files := []string{input}
+	for _, file := range inputs {
+		files = append(files, file.Path)
+		fmt.Fprintf(hash, "duration=%g\n", file.Duration)
+	}
+	files = append(files, optionFiles(job.Options)...)
+
+	for _, path := range files {
+		sum, err := contentHash(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "file=%s\n", sum)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// optionFiles lists the local files the options read, whose content must be
+// part of the key as much as their path.
+func optionFiles(opts models.JobOptions) []string {
+	var files []string
+	if opts.Watermark != nil && opts.Watermark.Path != "" {
+		files = append(files, opts.Watermark.Path)
+	}
+	for _, overlay := range opts.TextOverlays {
+		if overlay.FontPath != "" {
+			files = append(files, overlay.FontPath)
+		}
+	}
+	if opts.BurnSubtitles != nil && opts.BurnSubtitles.Path != "" {
+		files = append(files, opts.BurnSubtitles.Path)
+	}
+	if opts.Metadata != nil && opts.Metadata.CoverArtPath != "" {
+		files = append(files, opts.Metadata.CoverArtPath)
+	}
+	return files
+}
+
+// openContent opens a local file or, for encrypted inputs, the loopback URL
+// that serves its plaintext.
+func openContent(path string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(path, "http://") {
+		return os.Open(path)
+	}
+	resp, err := http.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func contentHash(path string) (string, error) {
+	file, err := openContent(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// completeFromCache completes the job with a cached output when there is
+// one. It returns false when the job still has to be converted. The output
+// object is shared, but the task gets the file name its own template gives.
+func (w *Worker) completeFromCache(ctx context.Context, job *models.JobData, key, ext string) (bool, error) {
+	entry, err := w.cachedOutput(ctx, key)
+	if err != nil {
+		logger.Warn("Worker %d - Conversion cache lookup failed for job %s: %v", w.info.ID, job.ID, err)
+		return false, nil
+	}
+	if entry == nil {
+		return false, nil
+	}
+
+	metadata := map[string]any{}
+	for name, value := range entry.Metadata {
+		metadata[name] = value
+	}
+	metadata["cache"] = cacheHit
+	fileName := path.Base(w.settings.OutputName.Render(job, ext, 1, time.Now()))
+	metadata["filename"] = fileName
+
+	update := models.JobUpdate{
+		ID:       job.ID,
+		Status:   models.JobStatusCompleted,
+		Output:   entry.Output,
+		Filename: fileName,
+		Size:     entry.Size,
+		Checksum: entry.Checksum,
+		Metadata: metadata,
+		CacheKey: key,
+	}
+	err = w.presignOutput(ctx, entry.Output, metadata)
+	if err == nil {
+		err = w.db.UpdateJobStatus(ctx, update)
+	}
+	if err != nil {
+		if releaseErr := w.db.ReleaseCachedOutput(ctx, key); releaseErr != nil {
+			logger.Warn("Worker %d - %v", w.info.ID, releaseErr)
+		}
+		return true, err
+	}
+
+	logger.Info("Worker %d - Job %s served from the conversion cache", w.info.ID, job.ID)
+	return true, nil
+}
+
+// storeCachedOutput offers a fresh output to the cache. The entry is stored
+// first, as a claim on the cache path, and the output is then created there.
+// It returns the output's URI and the key the task now holds a reference
+// to, or two empty strings when the output was not cached and is still to
+// be stored under the task's own name: another task stored the key first,
+// or an expired entry's output has not been collected yet.
+func (w *Worker) storeCachedOutput(ctx context.Context, key, ext, src string, update models.JobUpdate) (string, string, error) {
+	uri := w.settings.OutputURI.Join(path.Join(cacheDir, key+"."+ext))
+	entry := models.CachedOutput{
+		Key:      key,
+		Output:   uri.String(),
+		Size:     update.Size,
+		Checksum: update.Checksum,
+		Metadata: update.Metadata,
+	}
+	stored, err := w.db.StoreCachedOutput(ctx, entry, w.settings.Cache.TTL)
+	if err != nil {
+		logger.Warn("Worker %d - Failed to cache output of job %s: %v", w.info.ID, update.ID, err)
+		return "", "", nil
+	}
+	if !stored {
+		return "", "", nil
+	}
+
+	err = w.settings.Storage.UploadNew(ctx, src, uri)
+	if err == nil {
+		return entry.Output, key, nil
+	}
+	if releaseErr := w.db.ReleaseCachedOutput(ctx, key); releaseErr != nil {
+		logger.Warn("Worker %d - %v", w.info.ID, releaseErr)
+	}
+	if errors.Is(err, storage.ErrExists) {
+		logger.Warn("Worker %d - Cached output %s already exists, not caching job %s", w.info.ID, entry.Output, update.ID)
+		return "", "", nil
+	}
+	return "", "", err
+}
+
+// cachedOutput returns the cached output for key, or nil on a miss. An entry
+// whose output has gone missing from storage is released and treated as a
+// miss.
+func (w *Worker) cachedOutput(ctx context.Context, key string) (*models.CachedOutput, error) {
+	entry, err := w.db.AcquireCachedOutput(ctx, key, w.settings.Cache.TTL)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	uri, err := storage.ParseURI(entry.Output)
+	if err == nil {
+		var store storage.Storage
+		if store, err = w.settings.Storage.Open(uri); err == nil {
+			_, err = store.Stat(ctx, uri.Key)
+		}
+	}
+	if err != nil {
+		logger.Warn("Worker %d - Cached output %s is unusable, converting again: %v", w.info.ID, entry.Output, err)
+		if err := w.db.ReleaseCachedOutput(ctx, key); err != nil {
+			logger.Warn("Worker %d - %v", w.info.ID, err)
+		}
+		return nil, nil
+	}
+
+	return entry, nil
+}
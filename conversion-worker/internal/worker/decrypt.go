@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/converter"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/storage"
+)
+
+// plaintextServer hands encrypted inputs to ffmpeg and ffprobe as plaintext
+// over loopback HTTP, so the decrypted content is never written to disk.
+// Range requests let ffmpeg seek; only the chunks a request covers are
+// decrypted. Each job has its own server, started on the first encrypted
+// input and closed when the job ends.
+type plaintextServer struct {
+	mu       sync.Mutex
+	listener net.Listener
+	server   *http.Server
+	files    map[string]*storage.DecryptedFile
+}
+
+// add serves file under an unguessable URL ending in name, whose extension
+// helps ffmpeg pick the demuxer. The server closes file when it closes.
+func (s *plaintextServer) add(file *storage.DecryptedFile, name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			file.Close()
+			return "", fmt.Errorf("failed to start decryption server: %w", err)
+		}
+		s.listener = listener
+		s.files = make(map[string]*storage.DecryptedFile)
+		s.server = &http.Server{Handler: s}
+		go s.server.Serve(listener)
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		file.Close()
+		return "", err
+	}
+	id := hex.EncodeToString(token)
+	s.files[id] = file
+
+	return fmt.Sprintf("http://%s/%s/%s", s.listener.Addr(), id, path.Base(name)), nil
+}
+
+func (s *plaintextServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	s.mu.Lock()
+	file := s.files[id]
+	s.mu.Unlock()
+
+	if file == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		http.NotFound(w, r)
+		return
+	}
+	// ServeContent answers range requests and sets Accept-Ranges, which is
+	// what tells ffmpeg the input is seekable.
+	http.ServeContent(w, r, name, time.Time{}, io.NewSectionReader(file, 0, file.Size()))
+}
+
+// Close stops the server and closes every file it served.
+func (s *plaintextServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.server == nil {
+		return nil
+	}
+	errs := []error{s.server.Close()}
+	for _, file := range s.files {
+		errs = append(errs, file.Close())
+	}
+	s.files = nil
+	s.server = nil
+	s.listener = nil
+	return errors.Join(errs...)
+}
+
+// outputSealer encrypts the output of a job as the converter writes it, and
+// serves it back through plain for verification, so the plaintext of the
+// output never reaches the disk either.
+type outputSealer struct {
+	storage *storage.Manager
+	plain   *plaintextServer
+}
+
+func (s outputSealer) Create(path string) (io.WriteCloser, error) {
+	file, err := s.storage.CreateEncrypted(path)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s outputSealer) Open(path string) (converter.SealedFile, error) {
+	file, err := s.storage.OpenDecrypted(path)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s outputSealer) Serve(path string) (string, error) {
+	file, err := s.storage.OpenDecrypted(path)
+	if err != nil {
+		return "", err
+	}
+	return s.plain.add(file, path)
+}
@@ -0,0 +1,208 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/converter"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/database"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/queue"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/pkg/logger"
+)
+
+// readinessTimeout bounds each dependency check of a readiness probe.
+const readinessTimeout = 3 * time.Second
+
+// overdueFactor is how many times its expected time a job may run before
+// the worker running it is considered stuck.
+const overdueFactor = 2
+
+// LivenessSettings sets when a worker is considered stuck.
+type LivenessSettings struct {
+	// JobBase is the time any job is expected to take, and JobFactor the
+	// seconds added per second of media in its inputs.
+	JobBase   time.Duration
+	JobFactor int
+	// LoopTimeout is how long an idle worker may go without looking for
+	// a job. It must exceed the admission retry delay.
+	LoopTimeout time.Duration
+}
+
+// Health answers liveness, readiness and status probes for the worker.
+type Health struct {
+	queue    queue.Queue
+	db       database.Repository
+	pool     *Pool
+	liveness LivenessSettings
+	// dirs are the directories jobs write to, which must stay writable.
+	dirs []string
+}
+
+func NewHealth(q queue.Queue, db database.Repository, pool *Pool, liveness LivenessSettings, dirs ...string) *Health {
+	return &Health{queue: q, db: db, pool: pool, liveness: liveness, dirs: dirs}
+}
+
+// Live reports whether every worker is making progress. It answers 503,
+// naming the stuck workers, when one has stopped, has not looked for a job
+// in LoopTimeout while idle, or runs a job well past its expected time.
+func (h *Health) Live(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	stuck := map[string]string{}
+	for _, info := range h.pool.GetWorkerInfo() {
+		if err := h.liveness.check(info, now); err != nil {
+			stuck[fmt.Sprintf("worker:%d", info.ID)] = err.Error()
+		}
+	}
+
+	if len(stuck) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "stuck", "workers": stuck})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s LivenessSettings) check(info models.WorkerInfo, now time.Time) error {
+	switch {
+	case info.Stopped:
+		return fmt.Errorf("stopped")
+	case info.CurrentJob != "":
+		if elapsed := now.Sub(info.JobStartedAt); info.JobExpected > 0 && elapsed > overdueFactor*info.JobExpected {
+			return fmt.Errorf("job %s has run %v, expected %v", info.CurrentJob, elapsed.Round(time.Second), info.JobExpected.Round(time.Second))
+		}
+	case s.LoopTimeout > 0 && !info.LoopAt.IsZero():
+		if idle := now.Sub(info.LoopAt); idle > s.LoopTimeout {
+			return fmt.Errorf("has not looked for a job in %v", idle.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// expectedJobTime is how long a job on these inputs should take: JobBase
+// plus JobFactor seconds for each second of media.
+func (w *Worker) expectedJobTime(ctx context.Context, input string, inputs []models.InputFile) time.Duration {
+	paths := []string{input}
+	for _, file := range inputs {
+		if file.Path != input {
+			paths = append(paths, file.Path)
+		}
+	}
+
+	var media float64
+	for _, path := range paths {
+		if probe, err := converter.Probe(ctx, path); err == nil {
+			media += probe.Duration()
+		}
+	}
+
+	s := w.settings.Liveness
+	return s.JobBase + time.Duration(media*float64(s.JobFactor)*float64(time.Second))
+}
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Ready reports whether the worker can take jobs: Redis and PostgreSQL
+// answer, ffmpeg and ffprobe are installed and the directories jobs write to
+// are writable. It answers 503 with the failed checks otherwise.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]error{
+		"redis":    h.queue.Ping(ctx),
+		"postgres": h.db.Ping(ctx),
+	}
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		_, err := exec.LookPath(tool)
+		checks[tool] = err
+	}
+	for _, dir := range h.dirs {
+		checks["dir:"+dir] = checkWritable(dir)
+	}
+
+	resp := readinessResponse{Status: "ok", Checks: make(map[string]string, len(checks))}
+	status := http.StatusOK
+	for name, err := range checks {
+		resp.Checks[name] = "ok"
+		if err != nil {
+			resp.Checks[name] = err.Error()
+			resp.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, resp)
+}
+
+// checkWritable creates and removes a file in dir, creating dir first the
+// way job workspaces do.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, ".readyz_*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+type workerStatus struct {
+	ID            int        `json:"id"`
+	Type          string     `json:"type"`
+	Queue         string     `json:"queue"`
+	StartedAt     time.Time  `json:"started_at"`
+	UptimeSeconds float64    `json:"uptime_seconds"`
+	JobsCount     int64      `json:"jobs_count"`
+	CurrentJob    *jobStatus `json:"current_job"`
+}
+
+type jobStatus struct {
+	ID              string    `json:"id"`
+	StartedAt       time.Time `json:"started_at"`
+	ElapsedSeconds  float64   `json:"elapsed_seconds"`
+	ExpectedSeconds float64   `json:"expected_seconds"`
+}
+
+// Status lists every worker of the pool with the job it is running, if any.
+func (h *Health) Status(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	infos := h.pool.GetWorkerInfo()
+
+	workers := make([]workerStatus, len(infos))
+	for i, info := range infos {
+		workers[i] = workerStatus{
+			ID:            info.ID,
+			Type:          info.Type,
+			Queue:         info.QueueName,
+			StartedAt:     info.StartTime,
+			UptimeSeconds: now.Sub(info.StartTime).Seconds(),
+			JobsCount:     info.JobsCount,
+		}
+		if info.CurrentJob != "" {
+			workers[i].CurrentJob = &jobStatus{
+				ID:              info.CurrentJob,
+				StartedAt:       info.JobStartedAt,
+				ElapsedSeconds:  now.Sub(info.JobStartedAt).Seconds(),
+				ExpectedSeconds: info.JobExpected.Seconds(),
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"workers": workers})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Warn("Failed to write health response: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+func TestLivenessCheck(t *testing.T) {
+	now := time.Date(2024, 3, 7, 12, 0, 0, 0, time.UTC)
+	settings := LivenessSettings{LoopTimeout: time.Minute}
+
+	tests := []struct {
+		name     string
+		settings LivenessSettings
+		info     models.WorkerInfo
+		stuck    bool
+	}{
+		{name: "stopped", settings: settings, info: models.WorkerInfo{Stopped: true, LoopAt: now}, stuck: true},
+		{name: "idle and looping", settings: settings, info: models.WorkerInfo{LoopAt: now.Add(-30 * time.Second)}},
+		{name: "idle too long", settings: settings, info: models.WorkerInfo{LoopAt: now.Add(-2 * time.Minute)}, stuck: true},
+		{name: "no loop timeout", info: models.WorkerInfo{LoopAt: now.Add(-time.Hour)}},
+		{name: "not started", settings: settings},
+		{
+			name:     "job within its time",
+			settings: settings,
+			info: models.WorkerInfo{
+				CurrentJob: "task-1", JobStartedAt: now.Add(-15 * time.Minute), JobExpected: 10 * time.Minute,
+				LoopAt: now.Add(-15 * time.Minute),
+			},
+		},
+		{
+			name:     "job overdue",
+			settings: settings,
+			info: models.WorkerInfo{
+				CurrentJob: "task-1", JobStartedAt: now.Add(-21 * time.Minute), JobExpected: 10 * time.Minute,
+				LoopAt: now.Add(-21 * time.Minute),
+			},
+			stuck: true,
+		},
+		{
+			name:     "job without an expected time",
+			settings: settings,
+			info:     models.WorkerInfo{CurrentJob: "task-1", JobStartedAt: now.Add(-24 * time.Hour)},
+		},
+	}
+	for _, tt := range tests {
+		err := tt.settings.check(tt.info, now)
+		if stuck := err != nil; stuck != tt.stuck {
+			t.Errorf("%s: check = %v, want stuck %v", tt.name, err, tt.stuck)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/converter"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/database"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/queue"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics records what the workers do. A nil *Metrics records nothing, so
+// callers never need to check whether metrics are on.
+type Metrics struct {
+	registerer     prometheus.Registerer
+	formats        map[string]bool
+	processed      *prometheus.CounterVec
+	failed         *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	inputBytes     *prometheus.CounterVec
+	outputBytes    *prometheus.CounterVec
+	queueWait      *prometheus.HistogramVec
+	ffmpegExits    *prometheus.CounterVec
+	redisErrors    *prometheus.CounterVec
+	postgresErrors *prometheus.CounterVec
+}
+
+// jobLabels are the labels of the per-job metrics.
+var jobLabels = []string{"queue", "media_type", "format"}
+
+// durationBuckets suit durations in seconds from sub-second to an hour.
+var durationBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// otherLabel replaces label values taken from a job that are not known, so
+// a client cannot create a series per made-up format.
+const otherLabel = "other"
+
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	converters := converter.NewRegistry()
+	formats := make(map[string]bool)
+	for _, format := range converters.SupportedFormats() {
+		formats[format] = true
+	}
+
+	factory := promauto.With(registerer)
+	return &Metrics{
+		registerer: registerer,
+		formats:    formats,
+		processed: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "conversion_jobs_processed_total",
+			Help: "Jobs that finished, successfully or not.",
+		}, jobLabels),
+		failed: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "conversion_jobs_failed_total",
+			Help: "Jobs that failed.",
+		}, jobLabels),
+		duration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "conversion_job_duration_seconds",
+			Help:    "Time from taking a job to finishing it.",
+			Buckets: durationBuckets,
+		}, jobLabels),
+		inputBytes: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "conversion_input_bytes_total",
+			Help: "Size of the inputs of the jobs taken, as stored.",
+		}, jobLabels),
+		outputBytes: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "conversion_output_bytes_total",
+			Help: "Size of the outputs produced.",
+		}, jobLabels),
+		queueWait: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "conversion_queue_wait_seconds",
+			Help:    "Time from a job being queued to a worker starting it.",
+			Buckets: durationBuckets,
+		}, []string{"queue"}),
+		ffmpegExits: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "conversion_ffmpeg_exits_total",
+			Help: "Conversions by the exit code they ended with; failures report the ffmpeg or ffprobe run that failed.",
+		}, []string{"code"}),
+		redisErrors: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "conversion_redis_errors_total",
+			Help: "Failed Redis operations.",
+		}, []string{"operation"}),
+		postgresErrors: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "conversion_postgres_errors_total",
+			Help: "Failed PostgreSQL operations.",
+		}, []string{"operation"}),
+	}
+}
+
+// jobLabelValues returns the per-job labels. The media type and format come
+// from the job as submitted, so values outside the known set are folded into
+// otherLabel.
+func (m *Metrics) jobLabelValues(queueName string, job *models.JobData) []string {
+	mediaType, _, _ := strings.Cut(job.Mimetype, "/")
+	switch mediaType {
+	case "image", "audio", "video", "text", "application":
+	default:
+		mediaType = otherLabel
+	}
+	format := strings.ToLower(job.Format)
+	if !m.formats[format] {
+		format = otherLabel
+	}
+	return []string{queueName, mediaType, format}
+}
+
+// jobStarted records how long the job waited in the queue.
+func (m *Metrics) jobStarted(queueName string, job *models.JobData, now time.Time) {
+	if m == nil || job.EnqueuedAt.IsZero() {
+		return
+	}
+	m.queueWait.WithLabelValues(queueName).Observe(max(now.Sub(job.EnqueuedAt).Seconds(), 0))
+}
+
+func (m *Metrics) jobFinished(queueName string, job *models.JobData, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	labels := m.jobLabelValues(queueName, job)
+	m.processed.WithLabelValues(labels...).Inc()
+	if err != nil {
+		m.failed.WithLabelValues(labels...).Inc()
+	}
+	m.duration.WithLabelValues(labels...).Observe(duration.Seconds())
+}
+
+func (m *Metrics) inputRead(queueName string, job *models.JobData, size int64) {
+	if m == nil || size < 0 {
+		return
+	}
+	m.inputBytes.WithLabelValues(m.jobLabelValues(queueName, job)...).Add(float64(size))
+}
+
+func (m *Metrics) outputWritten(queueName string, job *models.JobData, size int64) {
+	if m == nil || size < 0 {
+		return
+	}
+	m.outputBytes.WithLabelValues(m.jobLabelValues(queueName, job)...).Add(float64(size))
+}
+
+// conversionExited records the exit code of a conversion: 0 when it
+// succeeded, the code of the failed process otherwise. Failures that are not
+// a process exiting, such as a missing input, are not counted.
+func (m *Metrics) conversionExited(err error) {
+	if m == nil {
+		return
+	}
+	if err == nil {
+		m.ffmpegExits.WithLabelValues("0").Inc()
+		return
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return
+	}
+	code := "killed"
+	if exitErr.ExitCode() >= 0 {
+		code = strconv.Itoa(exitErr.ExitCode())
+	}
+	m.ffmpegExits.WithLabelValues(code).Inc()
+}
+
+func (m *Metrics) redisFailed(operation string, err error) {
+	if m != nil && countable(err) {
+		m.redisErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (m *Metrics) postgresFailed(operation string, err error) {
+	if m != nil && countable(err) {
+		m.postgresErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// countable leaves out errors caused by the worker shutting down.
+func countable(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled)
+}
+
+// watchPool exposes how many workers of each queue are busy and idle.
+func (m *Metrics) watchPool(pool *Pool) {
+	if m == nil {
+		return
+	}
+	m.registerer.MustRegister(&poolCollector{
+		pool: pool,
+		desc: prometheus.NewDesc("conversion_workers",
+			"Workers by queue and state (busy or idle).", []string{"queue", "state"}, nil),
+	})
+}
+
+// poolCollector reads the state of the workers on every scrape.
+type poolCollector struct {
+	pool *Pool
+	desc *prometheus.Desc
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	type states struct{ busy, idle float64 }
+	byQueue := map[string]states{}
+	for _, info := range c.pool.GetWorkerInfo() {
+		counts := byQueue[info.QueueName]
+		if info.CurrentJob != "" {
+			counts.busy++
+		} else {
+			counts.idle++
+		}
+		byQueue[info.QueueName] = counts
+	}
+
+	for queueName, counts := range byQueue {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, counts.busy, queueName, "busy")
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, counts.idle, queueName, "idle")
+	}
+}
+
+// WatchRetention exposes the running totals of what retention reclaimed.
+func (m *Metrics) WatchRetention(r *Retention) {
+	if m == nil || r == nil {
+		return
+	}
+	stats := r.Stats()
+	counters := []struct {
+		name, help string
+		value      func() int64
+	}{
+		{"conversion_retention_reclaimed_bytes_total", "Bytes freed by deleting inputs, outputs and cache entries.", stats.ReclaimedBytes.Load},
+		{"conversion_retention_deleted_inputs_total", "Inputs deleted after their job completed.", stats.DeletedInputs.Load},
+		{"conversion_retention_expired_outputs_total", "Outputs expired by retention.", stats.ExpiredOutputs.Load},
+		{"conversion_retention_cache_entries_total", "Conversion cache entries collected.", stats.CacheEntries.Load},
+		{"conversion_retention_outbox_events_total", "Processed outbox events deleted.", stats.OutboxEvents.Load},
+	}
+	factory := promauto.With(m.registerer)
+	for _, c := range counters {
+		value := c.value
+		factory.NewCounterFunc(prometheus.CounterOpts{Name: c.name, Help: c.help},
+			func() float64 { return float64(value()) })
+	}
+}
+
+// Queue wraps q so failed Redis operations are counted.
+func (m *Metrics) Queue(q queue.Queue) queue.Queue {
+	if m == nil {
+		return q
+	}
+	return &instrumentedQueue{Queue: q, metrics: m}
+}
+
+// Repository wraps db so failed PostgreSQL operations are counted.
+func (m *Metrics) Repository(db database.Repository) database.Repository {
+	if m == nil {
+		return db
+	}
+	return &instrumentedRepository{Repository: db, metrics: m}
+}
+
+type instrumentedQueue struct {
+	queue.Queue
+	metrics *Metrics
+}
+
+func (q *instrumentedQueue) PopJob(ctx context.Context, queueName string) (*models.JobData, error) {
+	job, err := q.Queue.PopJob(ctx, queueName)
+	q.metrics.redisFailed("pop", err)
+	return job, err
+}
+
+func (q *instrumentedQueue) RequeueJob(ctx context.Context, queueName string, job *models.JobData) error {
+	err := q.Queue.RequeueJob(ctx, queueName, job)
+	q.metrics.redisFailed("requeue", err)
+	return err
+}
+
+func (q *instrumentedQueue) Ping(ctx context.Context) error {
+	err := q.Queue.Ping(ctx)
+	q.metrics.redisFailed("ping", err)
+	return err
+}
+
+type instrumentedRepository struct {
+	database.Repository
+	metrics *Metrics
+}
+
+func (r *instrumentedRepository) UpdateJobStatus(ctx context.Context, update models.JobUpdate) error {
+	err := r.Repository.UpdateJobStatus(ctx, update)
+	r.metrics.postgresFailed("update_job_status", err)
+	return err
+}
+
+func (r *instrumentedRepository) GetJobByID(ctx context.Context, id string) (*models.JobData, error) {
+	job, err := r.Repository.GetJobByID(ctx, id)
+	r.metrics.postgresFailed("get_job", err)
+	return job, err
+}
+
+func (r *instrumentedRepository) AcquireCachedOutput(ctx context.Context, key string, ttl time.Duration) (*models.CachedOutput, error) {
+	entry, err := r.Repository.AcquireCachedOutput(ctx, key, ttl)
+	r.metrics.postgresFailed("acquire_cached_output", err)
+	return entry, err
+}
+
+func (r *instrumentedRepository) StoreCachedOutput(ctx context.Context, entry models.CachedOutput, ttl time.Duration) (bool, error) {
+	stored, err := r.Repository.StoreCachedOutput(ctx, entry, ttl)
+	r.metrics.postgresFailed("store_cached_output", err)
+	return stored, err
+}
+
+func (r *instrumentedRepository) ReleaseCachedOutput(ctx context.Context, key string) error {
+	err := r.Repository.ReleaseCachedOutput(ctx, key)
+	r.metrics.postgresFailed("release_cached_output", err)
+	return err
+}
+
+func (r *instrumentedRepository) CollectExpiredCache(ctx context.Context, limit int) ([]models.CachedOutput, error) {
+	entries, err := r.Repository.CollectExpiredCache(ctx, limit)
+	r.metrics.postgresFailed("collect_expired_cache", err)
+	return entries, err
+}
+
+func (r *instrumentedRepository) ExpiredOutputs(ctx context.Context, ttl time.Duration, limit, offset int) ([]models.ExpiredOutput, error) {
+	outputs, err := r.Repository.ExpiredOutputs(ctx, ttl, limit, offset)
+	r.metrics.postgresFailed("expired_outputs", err)
+	return outputs, err
+}
+
+func (r *instrumentedRepository) CleanupOutboxEvents(ctx context.Context, days int) (int, error) {
+	deleted, err := r.Repository.CleanupOutboxEvents(ctx, days)
+	r.metrics.postgresFailed("cleanup_outbox_events", err)
+	return deleted, err
+}
+
+// WithAdvisoryLock counts failures to take the lock, not those of fn, whose
+// own queries are counted as they run.
+func (r *instrumentedRepository) WithAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error) {
+	var fnErr error
+	ran, err := r.Repository.WithAdvisoryLock(ctx, key, func(ctx context.Context) error {
+		fnErr = fn(ctx)
+		return fnErr
+	})
+	if err != fnErr {
+		r.metrics.postgresFailed("advisory_lock", err)
+	}
+	return ran, err
+}
+
+func (r *instrumentedRepository) Ping(ctx context.Context) error {
+	err := r.Repository.Ping(ctx)
+	r.metrics.postgresFailed("ping", err)
+	return err
+}
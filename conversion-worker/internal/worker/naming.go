@@ -0,0 +1,181 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/storage"
+)
+
+const (
+	DefaultOutputNameTemplate = "{task_id}.{ext}"
+
+	maxBasenameLength = 100
+	// maxNameAttempts bounds the search for a free name when a template
+	// without {task_id} renders to an object that already exists.
+	maxNameAttempts = 1000
+)
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z_]*)\}`)
+
+// outputPlaceholders are the names a template may use.
+var outputPlaceholders = map[string]bool{
+	"task_id":           true,
+	"date":              true,
+	"year":              true,
+	"month":             true,
+	"day":               true,
+	"original_name":     true,
+	"original_basename": true,
+	"format":            true,
+	"ext":               true,
+	"index":             true,
+}
+
+// NameTemplate names outputs inside the output root, for example
+// "{date}/{task_id}/{original_basename}.{ext}".
+type NameTemplate struct {
+	raw string
+}
+
+// ParseNameTemplate checks that the template only uses known placeholders
+// and stays inside the output root.
+func ParseNameTemplate(raw string) (*NameTemplate, error) {
+	if raw == "" {
+		raw = DefaultOutputNameTemplate
+	}
+	if strings.HasPrefix(raw, "/") {
+		return nil, fmt.Errorf("output name template %q must be relative", raw)
+	}
+	for _, segment := range strings.Split(raw, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return nil, fmt.Errorf("output name template %q has an empty or relative path segment", raw)
+		}
+	}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(raw, -1) {
+		if !outputPlaceholders[match[1]] {
+			return nil, fmt.Errorf("output name template %q uses unknown placeholder %s", raw, match[0])
+		}
+	}
+	return &NameTemplate{raw: raw}, nil
+}
+
+// Render returns the output name for job. index is the output's position,
+// starting at 1, and tells apart outputs that would otherwise share a name.
+func (t *NameTemplate) Render(job *models.JobData, ext string, index int, now time.Time) string {
+	basename := sanitizeBasename(job.OriginalName)
+	if basename == "" {
+		basename = job.ID
+	}
+	name := basename
+	if ext != "" {
+		name += "." + ext
+	}
+
+	values := map[string]string{
+		"task_id":           job.ID,
+		"date":              now.UTC().Format("2006-01-02"),
+		"year":              now.UTC().Format("2006"),
+		"month":             now.UTC().Format("01"),
+		"day":               now.UTC().Format("02"),
+		"original_name":     name,
+		"original_basename": basename,
+		"format":            sanitizeBasename(job.Format),
+		"ext":               ext,
+		"index":             strconv.Itoa(index),
+	}
+	return placeholderPattern.ReplaceAllStringFunc(t.raw, func(placeholder string) string {
+		return values[strings.Trim(placeholder, "{}")]
+	})
+}
+
+// usesIndex reports whether the template tells outputs apart by {index}.
+func (t *NameTemplate) usesIndex() bool {
+	return strings.Contains(t.raw, "{index}")
+}
+
+// sanitizeBasename strips the extension and any directory from a user
+// supplied file name and keeps only letters, digits, dots, dashes and
+// underscores, so it is safe as a path segment and object key.
+func sanitizeBasename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+
+	var b strings.Builder
+	underscore := false
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_' {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore {
+			b.WriteRune('_')
+			underscore = true
+		}
+	}
+
+	clean := strings.Trim(b.String(), "._")
+	if runes := []rune(clean); len(runes) > maxBasenameLength {
+		clean = strings.TrimRight(string(runes[:maxBasenameLength]), "._")
+	}
+	return clean
+}
+
+// attempt renders the name of job's output for its attempt-th try, from 1.
+// Later tries, made when the name is taken in the output root, use the next
+// index; templates without {index} get "_<n>" before the extension instead.
+func (t *NameTemplate) attempt(job *models.JobData, ext string, attempt int, now time.Time) string {
+	if attempt <= 1 || t.usesIndex() {
+		return t.Render(job, ext, max(attempt, 1), now)
+	}
+	base := t.Render(job, ext, 1, now)
+	stem := strings.TrimSuffix(base, path.Ext(base))
+	return fmt.Sprintf("%s_%d%s", stem, attempt, path.Ext(base))
+}
+
+// storeNamedOutput stores the converted file at src under the first free
+// name the template gives job, and returns its URI and name. Names are
+// taken with an exclusive create, so jobs racing for one never overwrite
+// each other's outputs; names already taken are skipped without uploading.
+func (w *Worker) storeNamedOutput(ctx context.Context, job *models.JobData, ext string, now time.Time, src string) (string, string, error) {
+	var name string
+	for attempt := 1; attempt <= maxNameAttempts; attempt++ {
+		name = w.settings.OutputName.attempt(job, ext, attempt, now)
+		if taken, err := w.outputExists(ctx, name); err != nil {
+			return "", "", err
+		} else if taken {
+			continue
+		}
+
+		uri := w.settings.OutputURI.Join(name)
+		err := w.settings.Storage.UploadNew(ctx, src, uri)
+		if err == nil {
+			return uri.String(), name, nil
+		}
+		if !errors.Is(err, storage.ErrExists) {
+			return "", "", err
+		}
+	}
+	return "", "", fmt.Errorf("no free output name for %s after %d attempts", name, maxNameAttempts)
+}
+
+func (w *Worker) outputExists(ctx context.Context, name string) (bool, error) {
+	uri := w.settings.OutputURI.Join(name)
+	store, err := w.settings.Storage.Open(uri)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = store.Stat(ctx, uri.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+)
+
+func TestParseNameTemplate(t *testing.T) {
+	valid := []string{
+		"",
+		"{task_id}.{ext}",
+		"{date}/{task_id}/{original_basename}.{ext}",
+		"{year}/{month}/{day}/{original_name}",
+		"out/{format}-{index}.{ext}",
+	}
+	for _, raw := range valid {
+		if _, err := ParseNameTemplate(raw); err != nil {
+			t.Errorf("ParseNameTemplate(%q) = %v, want no error", raw, err)
+		}
+	}
+
+	invalid := []string{
+		"/{task_id}.{ext}",
+		"../{task_id}.{ext}",
+		"out/./{task_id}",
+		"out//{task_id}",
+		"{task_id}/",
+		"{uuid}.{ext}",
+	}
+	for _, raw := range invalid {
+		if _, err := ParseNameTemplate(raw); err == nil {
+			t.Errorf("ParseNameTemplate(%q) succeeded, want an error", raw)
+		}
+	}
+}
+
+func TestNameTemplateRender(t *testing.T) {
+	now := time.Date(2024, 3, 7, 23, 30, 0, 0, time.FixedZone("", -3*60*60))
+	job := &models.JobData{ID: "task-1", OriginalName: "My Holiday (1).MOV", Format: "mp4"}
+
+	tests := []struct {
+		template string
+		job      *models.JobData
+		ext      string
+		want     string
+	}{
+		{template: "", job: job, ext: "mp4", want: "task-1.mp4"},
+		{template: "{date}/{task_id}/{original_basename}.{ext}", job: job, ext: "mp4", want: "2024-03-08/task-1/My_Holiday_1.mp4"},
+		{template: "{year}/{month}/{day}/{original_name}", job: job, ext: "mp4", want: "2024/03/08/My_Holiday_1.mp4"},
+		{template: "{format}/{original_name}", job: job, ext: "", want: "mp4/My_Holiday_1"},
+		{template: "{original_basename}-{index}.{ext}", job: job, ext: "jpg", want: "My_Holiday_1-2.jpg"},
+		// Without a usable original name the task ID stands in for it.
+		{template: "{original_name}", job: &models.JobData{ID: "task-2", OriginalName: "???.png"}, ext: "webp", want: "task-2.webp"},
+	}
+	for _, tt := range tests {
+		tmpl, err := ParseNameTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tmpl.Render(tt.job, tt.ext, 2, now); got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestNameTemplateAttempt(t *testing.T) {
+	now := time.Date(2024, 3, 7, 0, 0, 0, 0, time.UTC)
+	job := &models.JobData{ID: "task-1", OriginalName: "report.docx"}
+
+	tests := []struct {
+		template string
+		attempt  int
+		want     string
+	}{
+		{template: "{original_basename}.{ext}", attempt: 1, want: "report.pdf"},
+		{template: "{original_basename}.{ext}", attempt: 3, want: "report_3.pdf"},
+		{template: "{original_basename}", attempt: 2, want: "report_2"},
+		{template: "{original_basename}-{index}.{ext}", attempt: 3, want: "report-3.pdf"},
+	}
+	for _, tt := range tests {
+		tmpl, err := ParseNameTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tmpl.attempt(job, "pdf", tt.attempt, now); got != tt.want {
+			t.Errorf("attempt(%q, %d) = %q, want %q", tt.template, tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeBasename(t *testing.T) {
+	tests := map[string]string{
+		"photo.jpg":                    "photo",
+		"archive.tar.gz":               "archive.tar",
+		"../../etc/passwd":             "passwd",
+		`C:\Users\me\clip.mov`:         "clip",
+		"my  file (final)!.png":        "my_file_final",
+		"Relatório de Ação.docx":       "Relatório_de_Ação",
+		".hidden":                      "",
+		"___.png":                      "",
+		"":                             "",
+		strings.Repeat("a", 150):       strings.Repeat("a", maxBasenameLength),
+		strings.Repeat("é", 150):       strings.Repeat("é", maxBasenameLength),
+		strings.Repeat("a", 99) + "_b": strings.Repeat("a", 99),
+	}
+	for name, want := range tests {
+		if got := sanitizeBasename(name); got != want {
+			t.Errorf("sanitizeBasename(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
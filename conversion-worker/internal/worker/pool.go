@@ -11,7 +11,7 @@ import (
 )
 
 type Pool struct {
-	workers []Worker
+	workers []*Worker
 	wg      sync.WaitGroup
 	cancel  context.CancelFunc
 }
@@ -20,6 +20,7 @@ type PoolConfig struct {
 	LightWorkers int
 	HeavyWorkers int
 	WorkerType   string
+	Settings     Settings
 }
 
 func NewPool(config PoolConfig, q queue.Queue, db database.Repository) *Pool {
@@ -28,45 +29,46 @@ func NewPool(config PoolConfig, q queue.Queue, db database.Repository) *Pool {
 	switch config.WorkerType {
 	case "light":
 		logger.Info("Starting %d LIGHT workers", config.LightWorkers)
-		pool.createWorkers(config.LightWorkers, models.QueueTypeLight, q, db)
+		pool.createWorkers(config.LightWorkers, models.QueueTypeLight, q, db, config.Settings)
 	case "heavy":
 		logger.Info("Starting %d HEAVY workers", config.HeavyWorkers)
-		pool.createWorkers(config.HeavyWorkers, models.QueueTypeHeavy, q, db)
+		pool.createWorkers(config.HeavyWorkers, models.QueueTypeHeavy, q, db, config.Settings)
 	default:
 		logger.Info("Starting %d LIGHT and %d HEAVY workers", config.LightWorkers, config.HeavyWorkers)
-		pool.createWorkers(config.LightWorkers, models.QueueTypeLight, q, db)
+		pool.createWorkers(config.LightWorkers, models.QueueTypeLight, q, db, config.Settings)
 		offset := len(pool.workers)
-		pool.createHeavyWorkers(config.HeavyWorkers, offset, q, db)
+		pool.createHeavyWorkers(config.HeavyWorkers, offset, q, db, config.Settings)
 	}
 
+	config.Settings.Metrics.watchPool(pool)
 	return pool
 }
 
-func (p *Pool) createWorkers(count int, queueType models.QueueType, q queue.Queue, db database.Repository) {
+func (p *Pool) createWorkers(count int, queueType models.QueueType, q queue.Queue, db database.Repository, settings Settings) {
 	for i := 0; i < count; i++ {
-		worker := New(i+1, queueType, q, db)
-		p.workers = append(p.workers, *worker)
+		worker := New(i+1, queueType, q, db, settings)
+		p.workers = append(p.workers, worker)
 	}
 }
 
-func (p *Pool) createHeavyWorkers(count, offset int, q queue.Queue, db database.Repository) {
+func (p *Pool) createHeavyWorkers(count, offset int, q queue.Queue, db database.Repository, settings Settings) {
 	for i := 0; i < count; i++ {
-		worker := New(offset+i+1, models.QueueTypeHeavy, q, db)
-		p.workers = append(p.workers, *worker)
+		worker := New(offset+i+1, models.QueueTypeHeavy, q, db, settings)
+		p.workers = append(p.workers, worker)
 	}
 }
 
 func (p *Pool) Start(ctx context.Context) {
 	ctx, p.cancel = context.WithCancel(ctx)
 
-	for i := range p.workers {
+	for _, worker := range p.workers {
 		p.wg.Add(1)
 		go func(worker *Worker) {
 			defer p.wg.Done()
 			if err := worker.Start(ctx); err != nil && err != context.Canceled {
 				logger.Error("Worker %d stopped with error: %v", worker.info.ID, err)
 			}
-		}(&p.workers[i])
+		}(worker)
 	}
 }
 
@@ -79,6 +81,8 @@ func (p *Pool) Stop() {
 	logger.Info("Worker pool stopped successfully")
 }
 
+// GetWorkerInfo returns a snapshot of every worker. It is safe to call while
+// the pool runs.
 func (p *Pool) GetWorkerInfo() []models.WorkerInfo {
 	info := make([]models.WorkerInfo, len(p.workers))
 	for i, worker := range p.workers {
@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// diskSpace returns the bytes available to unprivileged users and the total
+// size of the filesystem holding path.
+func diskSpace(path string) (free, total int64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, fmt.Errorf("failed to stat filesystem of %s: %w", path, err)
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), int64(stat.Blocks) * int64(stat.Bsize), nil
+}
+
+// availableMemory returns MemAvailable from /proc/meminfo: the memory that
+// can be used without swapping, page cache included.
+func availableMemory() (int64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kib, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid MemAvailable in /proc/meminfo: %w", err)
+			}
+			return kib << 10, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errResourceUnknown
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package worker
+
+// Resource probes are only implemented for Linux, where the worker runs in
+// production; elsewhere admission control lets every job through.
+
+func diskSpace(path string) (free, total int64, err error) {
+	return 0, 0, errResourceUnknown
+}
+
+func availableMemory() (int64, error) {
+	return 0, errResourceUnknown
+}
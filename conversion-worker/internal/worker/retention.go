@@ -0,0 +1,306 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/database"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/storage"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/pkg/logger"
+)
+
+// retentionLockKey is the Postgres advisory lock that keeps retention passes
+// of different worker processes from running at the same time.
+const retentionLockKey int64 = 0x636f6e7672657400
+
+// retentionBatch is how many rows one retention query handles.
+const retentionBatch = 100
+
+// RetentionSettings configures what the worker deletes. Zero values turn the
+// corresponding cleanup off.
+type RetentionSettings struct {
+	// DeleteInputs removes a job's inputs once it completes, if they are
+	// uploads: files below InputDir.
+	DeleteInputs bool
+	InputDir     string
+	// OutputTTL is how long outputs are kept after their task completes.
+	OutputTTL time.Duration
+	// Interval is how often expired outputs, cache entries and outbox
+	// events are cleaned up.
+	Interval time.Duration
+	// OutboxDays is how long processed outbox events are kept.
+	OutboxDays int
+	// DryRun logs what would be deleted without deleting anything.
+	DryRun bool
+	// CollectCache deletes expired conversion cache entries.
+	CollectCache bool
+}
+
+// RetentionStats counts what retention has reclaimed since the worker
+// started.
+type RetentionStats struct {
+	ReclaimedBytes atomic.Int64
+	DeletedInputs  atomic.Int64
+	ExpiredOutputs atomic.Int64
+	CacheEntries   atomic.Int64
+	OutboxEvents   atomic.Int64
+}
+
+// Retention deletes inputs and outputs that are no longer needed and marks
+// their tasks as expired.
+type Retention struct {
+	db       database.Repository
+	storage  *storage.Manager
+	settings RetentionSettings
+	stats    RetentionStats
+}
+
+func NewRetention(db database.Repository, store *storage.Manager, settings RetentionSettings) *Retention {
+	return &Retention{db: db, storage: store, settings: settings}
+}
+
+// Stats returns the counters of reclaimed space and deleted objects.
+func (r *Retention) Stats() *RetentionStats {
+	return &r.stats
+}
+
+// scheduled reports whether any periodic cleanup is configured.
+func (r *Retention) scheduled() bool {
+	s := r.settings
+	return s.Interval > 0 && (s.OutputTTL > 0 || s.OutboxDays > 0 || s.CollectCache)
+}
+
+// Run performs a retention pass every interval until ctx is cancelled.
+func (r *Retention) Run(ctx context.Context) {
+	if !r.scheduled() {
+		return
+	}
+	if r.settings.DryRun {
+		logger.Info("Retention running in dry-run mode; nothing will be deleted")
+	}
+
+	ticker := time.NewTicker(r.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ran, err := r.db.WithAdvisoryLock(ctx, retentionLockKey, r.pass)
+			if err != nil {
+				logger.Error("Retention pass failed: %v", err)
+			} else if !ran {
+				logger.Debug("Retention pass skipped: another worker holds the lock")
+			}
+		}
+	}
+}
+
+func (r *Retention) pass(ctx context.Context) error {
+	start := r.stats.ReclaimedBytes.Load()
+	var errs []error
+
+	if r.settings.OutputTTL > 0 {
+		expired, err := r.expireOutputs(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if expired > 0 {
+			logger.Info("Retention expired %d outputs", expired)
+		}
+	}
+
+	if r.settings.CollectCache {
+		removed, err := r.collectCache(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if removed > 0 {
+			logger.Info("Retention removed %d expired cache entries", removed)
+		}
+	}
+
+	if r.settings.OutboxDays > 0 && r.settings.DryRun {
+		logger.Info("Retention dry run: would delete outbox events processed more than %d days ago", r.settings.OutboxDays)
+	} else if r.settings.OutboxDays > 0 {
+		deleted, err := r.db.CleanupOutboxEvents(ctx, r.settings.OutboxDays)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if deleted > 0 {
+			r.stats.OutboxEvents.Add(int64(deleted))
+			logger.Info("Retention deleted %d processed outbox events", deleted)
+		}
+	}
+
+	if reclaimed := r.stats.ReclaimedBytes.Load() - start; reclaimed > 0 {
+		logger.Info("Retention reclaimed %d bytes", reclaimed)
+	}
+	return errors.Join(errs...)
+}
+
+// expireOutputs deletes the outputs of tasks completed more than the output
+// TTL ago and marks the tasks as expired. Outputs shared through the
+// conversion cache are not deleted here; the task's reference is released
+// and the cache entry is collected once no task refers to it.
+func (r *Retention) expireOutputs(ctx context.Context) (int, error) {
+	expired := 0
+	offset := 0
+	for {
+		outputs, err := r.db.ExpiredOutputs(ctx, r.settings.OutputTTL, retentionBatch, offset)
+		if err != nil {
+			return expired, err
+		}
+
+		// Expired tasks drop out of the query; the ones left behind, in a
+		// dry run or after an error, are skipped on the next page.
+		for _, output := range outputs {
+			if r.settings.DryRun {
+				logger.Info("Retention dry run: would expire task %s and delete %s (%d bytes)",
+					output.TaskID, output.Output, output.Size)
+				offset++
+				continue
+			}
+			if err := r.expireOutput(ctx, output); err != nil {
+				logger.Warn("Retention could not expire task %s: %v", output.TaskID, err)
+				offset++
+				continue
+			}
+			expired++
+		}
+
+		if len(outputs) < retentionBatch {
+			return expired, nil
+		}
+	}
+}
+
+func (r *Retention) expireOutput(ctx context.Context, output models.ExpiredOutput) error {
+	if output.CacheKey != "" {
+		if err := r.db.ReleaseCachedOutput(ctx, output.CacheKey); err != nil {
+			return err
+		}
+	} else if output.Output != "" {
+		size, err := r.delete(ctx, output.Output)
+		if err != nil {
+			return err
+		}
+		r.stats.ReclaimedBytes.Add(size)
+	}
+
+	r.stats.ExpiredOutputs.Add(1)
+	return r.db.UpdateJobStatus(ctx, models.JobUpdate{
+		ID:       output.TaskID,
+		Status:   models.JobStatusExpired,
+		Metadata: map[string]any{"expired_at": time.Now().UTC().Format(time.RFC3339)},
+	})
+}
+
+// collectCache deletes expired cache entries that no task refers to any more,
+// together with their outputs.
+func (r *Retention) collectCache(ctx context.Context) (int, error) {
+	if r.settings.DryRun {
+		logger.Info("Retention dry run: skipping conversion cache collection")
+		return 0, nil
+	}
+
+	removed := 0
+	for {
+		entries, err := r.db.CollectExpiredCache(ctx, retentionBatch)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, entry := range entries {
+			size, err := r.delete(ctx, entry.Output)
+			if err != nil {
+				logger.Warn("Retention could not delete cached output %s: %v", entry.Output, err)
+				continue
+			}
+			r.stats.ReclaimedBytes.Add(size)
+		}
+		removed += len(entries)
+		r.stats.CacheEntries.Add(int64(len(entries)))
+
+		if len(entries) < retentionBatch {
+			return removed, nil
+		}
+	}
+}
+
+// deleteInputs removes the inputs of a completed job when configured to.
+// Only uploads the API left in InputDir are the worker's to delete; outputs
+// of other tasks, objects in buckets and files on web servers are left
+// alone. Failures are logged; the job has already succeeded.
+func (r *Retention) deleteInputs(ctx context.Context, job *models.JobData) {
+	if !r.settings.DeleteInputs {
+		return
+	}
+
+	seen := map[string]bool{}
+	uris := []string{job.InputURI}
+	for _, input := range job.Inputs {
+		uris = append(uris, input.URI)
+	}
+
+	for _, uri := range uris {
+		if uri == "" || seen[uri] {
+			continue
+		}
+		if !r.isUpload(uri) {
+			continue
+		}
+		seen[uri] = true
+
+		if r.settings.DryRun {
+			logger.Info("Retention dry run: would delete input %s of job %s", uri, job.ID)
+			continue
+		}
+		size, err := r.delete(ctx, uri)
+		if err != nil {
+			logger.Warn("Retention could not delete input %s of job %s: %v", uri, job.ID, err)
+			continue
+		}
+		r.stats.DeletedInputs.Add(1)
+		r.stats.ReclaimedBytes.Add(size)
+	}
+}
+
+// isUpload reports whether rawURI is a file below InputDir.
+func (r *Retention) isUpload(rawURI string) bool {
+	uri, err := storage.ParseURI(rawURI)
+	if err != nil || !uri.IsLocal() || r.settings.InputDir == "" {
+		return false
+	}
+	return strings.HasPrefix(uri.Key, strings.TrimSuffix(r.settings.InputDir, "/")+"/")
+}
+
+// delete removes the object at rawURI and returns its size. Objects that are
+// already gone are not an error.
+func (r *Retention) delete(ctx context.Context, rawURI string) (int64, error) {
+	uri, err := storage.ParseURI(rawURI)
+	if err != nil {
+		return 0, err
+	}
+	store, err := r.storage.Open(uri)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	if info, err := store.Stat(ctx, uri.Key); err == nil {
+		size = info.Size
+	} else if errors.Is(err, storage.ErrNotFound) {
+		return 0, nil
+	}
+
+	if err := store.Delete(ctx, uri.Key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return 0, err
+	}
+	return size, nil
+}
@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/storage"
+)
+
+// fetchInput returns a path or URL ffmpeg can read for input. Local files
+// are used in place; remote objects, in buckets or on web servers, are
+// downloaded into the job workspace under a name that keeps their
+// extension. Either way the stored content must match the input's checksum
+// when one is given. Encrypted inputs stay encrypted on disk and are read
+// through plain; plaintext ones are rejected when the input records an
+// encryption key or encryption is required. The size returned is that of
+// the input as stored.
+func (w *Worker) fetchInput(ctx context.Context, input models.InputFile, workspace string, index int, plain *plaintextServer) (string, int64, error) {
+	uri, err := storage.ParseURI(input.URI)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var path string
+	if uri.IsLocal() {
+		if path, err = w.settings.Storage.LocalPath(uri); err != nil {
+			return "", 0, fmt.Errorf("input %s: %w", uri, err)
+		}
+		if err := storage.VerifyFile(path, input.Checksum); err != nil {
+			return "", 0, fmt.Errorf("input %s failed verification: %w", uri, err)
+		}
+	} else {
+		path = filepath.Join(workspace, fmt.Sprintf("input_%d%s", index, filepath.Ext(uri.Base())))
+		start := time.Now()
+		if err := w.settings.Storage.Download(ctx, uri, path, input.Checksum); err != nil {
+			return "", 0, err
+		}
+		w.extendJobExpected(time.Since(start))
+	}
+
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+
+	file, err := w.settings.Storage.OpenDecrypted(path)
+	if errors.Is(err, storage.ErrNotEncrypted) {
+		if input.EncryptionKeyID != "" {
+			return "", 0, fmt.Errorf("input %s was stored with encryption key %q but is not encrypted", uri, input.EncryptionKeyID)
+		}
+		if w.settings.Storage.EncryptionRequired() {
+			return "", 0, fmt.Errorf("input %s is not encrypted and encryption is required", uri)
+		}
+		return path, size, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to decrypt %s: %w", uri, err)
+	}
+	url, err := plain.add(file, uri.Base())
+	return url, size, err
+}
+
+// fetchInputs resolves the job's input URIs to paths or, for encrypted
+// inputs, loopback URLs served by plain. It returns the main input and the
+// list of inputs for multi-input jobs.
+func (w *Worker) fetchInputs(ctx context.Context, job *models.JobData, workspace string, plain *plaintextServer) (string, []models.InputFile, error) {
+	main := models.InputFile{
+		URI:             job.InputURI,
+		Checksum:        job.Options.InputChecksum,
+		EncryptionKeyID: job.Options.InputEncryptionKeyID,
+	}
+	input, total, err := w.fetchInput(ctx, main, workspace, 0, plain)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch input: %w", err)
+	}
+
+	inputs := make([]models.InputFile, len(job.Inputs))
+	for i, file := range job.Inputs {
+		if file.URI == job.InputURI {
+			file.Path = input
+		} else {
+			var size int64
+			if file.Path, size, err = w.fetchInput(ctx, file, workspace, i+1, plain); err != nil {
+				return "", nil, fmt.Errorf("failed to fetch input %d: %w", i+1, err)
+			}
+			total += size
+		}
+		inputs[i] = file
+	}
+
+	w.settings.Metrics.inputRead(w.info.QueueName, job, total)
+	return input, inputs, nil
+}
+
+// storeOutput uploads the converted file to name under the configured output
+// URI and returns where it now lives.
+func (w *Worker) storeOutput(ctx context.Context, path, name string) (string, error) {
+	uri := w.settings.OutputURI.Join(name)
+	if err := w.settings.Storage.Upload(ctx, path, uri); err != nil {
+		return "", err
+	}
+	return uri.String(), nil
+}
+
+// presignOutput adds a download URL to the task metadata for outputs in
+// buckets that can presign; local outputs are left as they are. Outputs
+// whose metadata names an encryption key get an encrypted_download_url
+// instead, to the ciphertext, which the API decrypts as it serves the
+// download.
+func (w *Worker) presignOutput(ctx context.Context, output string, metadata map[string]any) error {
+	uri, err := storage.ParseURI(output)
+	if err != nil {
+		return err
+	}
+
+	field := "download_url"
+	if _, encrypted := metadata["encryption_key_id"]; encrypted {
+		field = "encrypted_download_url"
+	}
+
+	url, err := w.settings.Storage.PresignStored(ctx, uri, w.settings.PresignExpiry)
+	switch {
+	case err == nil:
+		metadata[field] = url
+		metadata["download_url_expires_at"] = time.Now().Add(w.settings.PresignExpiry).UTC().Format(time.RFC3339)
+	case !errors.Is(err, storage.ErrNotSupported):
+		return fmt.Errorf("failed to presign output: %w", err)
+	}
+	return nil
+}
@@ -2,24 +2,48 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/converter"
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/database"
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/models"
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/queue"
+	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/internal/storage"
 	"github.com/guijoazeiro/conversion-microservice/tree/main/conversion-worker/pkg/logger"
 )
 
 type Worker struct {
+	// mu guards the fields of info that change while the worker runs.
+	mu        sync.Mutex
 	info      models.WorkerInfo
 	queue     queue.Queue
 	db        database.Repository
 	converter converter.Registry
+	settings  Settings
 }
 
-func New(id int, queueType models.QueueType, q queue.Queue, db database.Repository) *Worker {
+// Settings holds the configuration shared by every worker in the pool.
+type Settings struct {
+	AssetsDir     string
+	WorkDir       string
+	Storage       *storage.Manager
+	OutputURI     storage.URI
+	OutputName    *NameTemplate
+	Cache         CacheSettings
+	Retention     *Retention
+	Admission     AdmissionSettings
+	Liveness      LivenessSettings
+	PresignExpiry time.Duration
+	Metrics       *Metrics
+}
+
+func New(id int, queueType models.QueueType, q queue.Queue, db database.Repository, settings Settings) *Worker {
 	return &Worker{
 		info: models.WorkerInfo{
 			ID:        id,
@@ -30,11 +54,13 @@ func New(id int, queueType models.QueueType, q queue.Queue, db database.Reposito
 		queue:     q,
 		db:        db,
 		converter: converter.NewRegistry(),
+		settings:  settings,
 	}
 }
 
 func (w *Worker) Start(ctx context.Context) error {
 	logger.Info("Worker %d [%s] starting...", w.info.ID, w.info.Type)
+	defer w.setStopped()
 
 	for {
 		select {
@@ -50,6 +76,7 @@ func (w *Worker) Start(ctx context.Context) error {
 }
 
 func (w *Worker) processNextJob(ctx context.Context) error {
+	w.looped(time.Now())
 	job, err := w.queue.PopJob(ctx, w.info.QueueName)
 	if err != nil {
 		logger.Error("Worker %d - Error popping job: %v", w.info.ID, err)
@@ -62,19 +89,38 @@ func (w *Worker) processNextJob(ctx context.Context) error {
 		return nil
 	}
 
-	logger.Info("Worker %d [%s] - Processing job %s", w.info.ID, w.info.Type, job.ID)
-
 	update := models.JobUpdate{
 		ID:     job.ID,
 		Status: models.JobStatusProcessing,
 	}
-	if err := w.db.UpdateJobStatus(ctx, update); err != nil {
-		logger.Warn("Worker %d - Error updating job status to processing: %v", w.info.ID, err)
-	}
 
 	start := time.Now()
-	err = w.processJob(ctx, job)
+	err = converter.CheckTarget(ctx, job.Format)
+	if err == nil {
+		err = w.admit(ctx, job)
+	}
+	var shortage *resourceShortage
+	if errors.As(err, &shortage) {
+		if limit := w.settings.Admission.MaxDeferrals; limit > 0 && job.Deferrals >= limit {
+			err = fmt.Errorf("%v; gave up after deferring the job %d times", shortage, job.Deferrals)
+		} else if err = w.deferJob(ctx, job, shortage); err == nil {
+			return nil
+		}
+	}
+
+	if err == nil {
+		w.setCurrentJob(job.ID, time.Now())
+		defer w.setCurrentJob("", time.Time{})
+		w.settings.Metrics.jobStarted(w.info.QueueName, job, time.Now())
+
+		logger.Info("Worker %d [%s] - Processing job %s", w.info.ID, w.info.Type, job.ID)
+		if err := w.db.UpdateJobStatus(ctx, update); err != nil {
+			logger.Warn("Worker %d - Error updating job status to processing: %v", w.info.ID, err)
+		}
+		err = w.processJob(ctx, job)
+	}
 	duration := time.Since(start)
+	w.settings.Metrics.jobFinished(w.info.QueueName, job, duration, err)
 
 	if err != nil {
 		logger.Error("Worker %d [%s] - Job %s failed after %v: %v",
@@ -82,6 +128,11 @@ func (w *Worker) processNextJob(ctx context.Context) error {
 
 		update.Status = models.JobStatusFailed
 		update.Error = err
+		update.ErrorType = models.ErrorTypeConversion
+		var verifyErr *converter.VerificationError
+		if errors.As(err, &verifyErr) {
+			update.ErrorType = models.ErrorTypeVerification
+		}
 		if dbErr := w.db.UpdateJobStatus(ctx, update); dbErr != nil {
 			logger.Error("Worker %d - Error updating job status to failed: %v", w.info.ID, dbErr)
 		}
@@ -91,38 +142,167 @@ func (w *Worker) processNextJob(ctx context.Context) error {
 	logger.Info("Worker %d [%s] - Job %s completed in %v",
 		w.info.ID, w.info.Type, job.ID, duration)
 
+	if w.settings.Retention != nil {
+		w.settings.Retention.deleteInputs(ctx, job)
+	}
+
+	w.mu.Lock()
 	w.info.JobsCount++
+	w.mu.Unlock()
 	return nil
 }
 
 func (w *Worker) processJob(ctx context.Context, job *models.JobData) error {
-	conv, err := w.converter.GetConverter(job.Mimetype)
+	conv, err := w.converter.ConverterFor(job)
 	if err != nil {
 		return fmt.Errorf("unsupported mimetype %s: %w", job.Mimetype, err)
 	}
 
-	var fileName string
-	if job.Format == "images" {
-		fileName = fmt.Sprintf("%s.zip", job.ID)
-	} else {
-		fileName = fmt.Sprintf("%s.%s", job.ID, job.Format)
+	if err := w.resolveAssets(job); err != nil {
+		return err
+	}
+
+	workspace, err := w.newWorkspace(job.ID)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workspace)
+
+	plain := &plaintextServer{}
+	defer plain.Close()
+
+	fetchStart := time.Now()
+	input, inputs, err := w.fetchInputs(ctx, job, workspace, plain)
+	if err != nil {
+		return err
+	}
+	w.setJobExpected(time.Since(fetchStart) + w.expectedJobTime(ctx, input, inputs))
+
+	ext := converter.OutputExtension(job.Format, job.Options)
+
+	var key string
+	if w.settings.Cache.enabled() {
+		if key, err = cacheKey(job, input, inputs); err != nil {
+			logger.Warn("Worker %d - Conversion cache skipped for job %s: %v", w.info.ID, job.ID, err)
+			key = ""
+		} else if done, err := w.completeFromCache(ctx, job, key, ext); done || err != nil {
+			return err
+		}
 	}
-	outputPath := fmt.Sprintf("/tmp/output/%s", fileName)
 
-	if err := conv.Convert(ctx, job.InputPath, job.Format, outputPath); err != nil {
+	// The output is named when it is stored; until then it has the name of
+	// the template's first try.
+	now := time.Now()
+	fileName := path.Base(w.settings.OutputName.attempt(job, ext, 1, now))
+	outputPath := filepath.Join(workspace, fileName)
+
+	req := converter.Request{
+		Input:     input,
+		Inputs:    inputs,
+		Format:    job.Format,
+		Output:    outputPath,
+		Options:   job.Options,
+		Metadata:  converter.Metadata{},
+		Workspace: workspace,
+	}
+	if w.settings.Storage.EncryptionKeyID() != "" {
+		req.Sealer = outputSealer{storage: w.settings.Storage, plain: plain}
+	}
+	err = conv.Convert(ctx, req)
+	w.settings.Metrics.conversionExited(err)
+	if err != nil {
 		return fmt.Errorf("conversion failed: %w", err)
 	}
 
+	info, err := converter.VerifyOutput(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if keyID := w.settings.Storage.EncryptionKeyID(); keyID != "" {
+		req.Metadata.Set("encryption_key_id", keyID)
+	}
+	req.Metadata.Set("filename", fileName)
+
 	update := models.JobUpdate{
 		ID:       job.ID,
 		Status:   models.JobStatusCompleted,
-		Output:   outputPath,
 		Filename: fileName,
+		Size:     info.Size,
+		Checksum: info.Checksum,
+		Metadata: req.Metadata,
+	}
+
+	// An output offered to the cache is stored under its cache key, so the
+	// tasks it is shared with don't get the name of the task that made it.
+	// Only the task whose entry is stored writes there; the others keep
+	// their output under their own name.
+	if key != "" {
+		update.Output, update.CacheKey, err = w.storeCachedOutput(ctx, key, ext, outputPath, update)
+		if err != nil {
+			return fmt.Errorf("failed to store output: %w", err)
+		}
+		req.Metadata.Set("cache", cacheMiss)
+	}
+	if update.Output == "" {
+		var storedName string
+		if update.Output, storedName, err = w.storeNamedOutput(ctx, job, ext, now, outputPath); err != nil {
+			return fmt.Errorf("failed to store output: %w", err)
+		}
+		update.Filename = path.Base(storedName)
+		req.Metadata.Set("filename", update.Filename)
 	}
+	w.settings.Metrics.outputWritten(w.info.QueueName, job, info.Size)
 
-	return w.db.UpdateJobStatus(ctx, update)
+	err = w.presignOutput(ctx, update.Output, req.Metadata)
+	if err == nil {
+		err = w.db.UpdateJobStatus(ctx, update)
+	}
+	if err != nil && update.CacheKey != "" {
+		if releaseErr := w.db.ReleaseCachedOutput(ctx, key); releaseErr != nil {
+			logger.Warn("Worker %d - %v", w.info.ID, releaseErr)
+		}
+	}
+	return err
 }
 
 func (w *Worker) GetInfo() models.WorkerInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return w.info
 }
+
+func (w *Worker) setCurrentJob(id string, started time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.info.CurrentJob = id
+	w.info.JobStartedAt = started
+	w.info.JobExpected = w.settings.Liveness.JobBase
+}
+
+func (w *Worker) setJobExpected(expected time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.info.JobExpected = expected
+}
+
+// extendJobExpected adds d, spent fetching an input, to the time the current
+// job is expected to take, so a large download does not make the worker look
+// stuck.
+func (w *Worker) extendJobExpected(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.info.JobExpected += d
+}
+
+func (w *Worker) looped(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.info.LoopAt = now
+}
+
+func (w *Worker) setStopped() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.info.Stopped = true
+}
@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WorkspaceRoot returns the directory this host keeps job workspaces in.
+// Workers on other hosts may share dir, so each host only ever touches its
+// own subdirectory.
+func WorkspaceRoot(dir string) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return filepath.Join(dir, host)
+}
+
+// newWorkspace creates the directory a job reads remote inputs into and
+// writes its output and scratch files in. Nothing in it is visible to the
+// API until the output is stored.
+func (w *Worker) newWorkspace(jobID string) (string, error) {
+	if err := os.MkdirAll(w.settings.WorkDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %w", err)
+	}
+	workspace, err := os.MkdirTemp(w.settings.WorkDir, "job_"+jobID+"_*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create job workspace: %w", err)
+	}
+	return workspace, nil
+}
+
+// CleanWorkspaces removes the workspaces a previous run left behind when it
+// crashed or was killed mid-job. It must run before the pool starts.
+func CleanWorkspaces(root string) (int, error) {
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
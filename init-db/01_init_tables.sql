@@ -4,22 +4,61 @@ CREATE TABLE conversion_tasks (
   id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
   original_name VARCHAR(255) NOT NULL,
   stored_name VARCHAR(255) NOT NULL,
-  input_path VARCHAR(255) NOT NULL,
+  input_path TEXT NOT NULL,
   mimetype VARCHAR(100) NOT NULL,
   format VARCHAR(50) NOT NULL,
   file_size BIGINT NOT NULL,
   status VARCHAR(50) NOT NULL DEFAULT 'pending',
+  options JSONB NOT NULL DEFAULT '{}'::jsonb,
+  metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
   
   created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
   updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
   
   error_message TEXT,
+  error_type VARCHAR(50),
   processing_started_at TIMESTAMP WITH TIME ZONE,
   processing_completed_at TIMESTAMP WITH TIME ZONE,
   output_path TEXT,
   output_size BIGINT,
+  output_checksum VARCHAR(64),
+  cache_key VARCHAR(64),
   
-  CONSTRAINT chk_status CHECK (status IN ('pending', 'queued', 'processing', 'completed', 'failed', 'cancelled'))
+  CONSTRAINT chk_status CHECK (status IN ('pending', 'queued', 'processing', 'completed', 'failed', 'cancelled', 'expired')),
+  CONSTRAINT chk_error_type CHECK (error_type IS NULL OR error_type IN ('conversion', 'verification'))
+);
+
+CREATE TABLE conversion_task_inputs (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  task_id UUID NOT NULL REFERENCES conversion_tasks(id) ON DELETE CASCADE,
+  position INTEGER NOT NULL,
+  original_name VARCHAR(255),
+  input_path TEXT NOT NULL,
+  mimetype VARCHAR(100),
+  file_size BIGINT,
+  duration NUMERIC(10, 3),
+  checksum VARCHAR(71),
+  encryption_key_id VARCHAR(255),
+  
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  
+  CONSTRAINT uq_conversion_task_inputs_position UNIQUE (task_id, position),
+  CONSTRAINT chk_input_duration CHECK (duration IS NULL OR duration >= 0)
+);
+
+CREATE TABLE conversion_cache (
+  cache_key VARCHAR(64) PRIMARY KEY,
+  output_path TEXT NOT NULL,
+  output_size BIGINT,
+  output_checksum VARCHAR(64),
+  metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
+  ref_count INTEGER NOT NULL DEFAULT 1,
+  
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  last_used_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  
+  CONSTRAINT chk_cache_ref_count CHECK (ref_count >= 0)
 );
 
 CREATE TABLE outbox_events (
@@ -45,6 +84,11 @@ CREATE INDEX idx_conversion_tasks_created_at ON conversion_tasks(created_at);
 CREATE INDEX idx_conversion_tasks_format ON conversion_tasks(format);
 CREATE INDEX idx_conversion_tasks_mimetype ON conversion_tasks(mimetype);
 CREATE INDEX idx_conversion_tasks_queued ON conversion_tasks(status, created_at) WHERE status = 'queued';
+CREATE INDEX idx_conversion_tasks_completed ON conversion_tasks(updated_at) WHERE status = 'completed';
+
+CREATE INDEX idx_conversion_task_inputs_task_id ON conversion_task_inputs(task_id);
+
+CREATE INDEX idx_conversion_cache_collectable ON conversion_cache(expires_at) WHERE ref_count = 0;
 
 CREATE INDEX idx_outbox_events_status ON outbox_events(status);
 CREATE INDEX idx_outbox_events_created_at ON outbox_events(created_at);
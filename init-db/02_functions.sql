@@ -1,15 +1,29 @@
+-- Stored paths are local to the API container; jobs carry them as storage
+-- URIs so workers can tell them apart from objects in a bucket.
+CREATE OR REPLACE FUNCTION storage_uri(p_path TEXT) RETURNS TEXT AS $$
+    SELECT CASE
+        WHEN p_path LIKE '/%' THEN 'file://' || p_path
+        ELSE p_path
+    END;
+$$ LANGUAGE sql IMMUTABLE;
+
 CREATE OR REPLACE FUNCTION create_conversion_task_with_outbox(
     p_original_name VARCHAR(255),
     p_stored_name VARCHAR(255),
-    p_input_path VARCHAR(255),
+    p_input_path TEXT,
     p_mimetype VARCHAR(100),
     p_format VARCHAR(50),
     p_file_size BIGINT,
-    p_output_path TEXT DEFAULT NULL
+    p_output_path TEXT DEFAULT NULL,
+    p_options JSONB DEFAULT NULL,
+    p_inputs JSONB DEFAULT NULL
 ) RETURNS UUID AS $$
 DECLARE
     new_task_id UUID;
     event_data JSONB;
+    inputs_data JSONB := '[]'::jsonb;
+    all_inputs JSONB;
+    total_size BIGINT := p_file_size;
 BEGIN
     INSERT INTO conversion_tasks (
         original_name,
@@ -19,6 +33,7 @@ BEGIN
         format,
         file_size,
         output_path,
+        options,
         status
     ) VALUES (
         p_original_name,
@@ -28,16 +43,73 @@ BEGIN
         p_format,
         p_file_size,
         p_output_path,
+        COALESCE(p_options, '{}'::jsonb),
         'pending'
     )
     RETURNING id INTO new_task_id;
     
+    IF jsonb_typeof(p_inputs) = 'array' AND jsonb_array_length(p_inputs) > 0 THEN
+        -- The main input is always the first input. Callers may list it
+        -- first in p_inputs, to give its duration; otherwise it is added.
+        all_inputs := p_inputs;
+        IF p_inputs->0->>'path' IS DISTINCT FROM p_input_path THEN
+            all_inputs := jsonb_build_array(jsonb_strip_nulls(jsonb_build_object(
+                'path', p_input_path,
+                'original_name', p_original_name,
+                'mimetype', p_mimetype,
+                'file_size', p_file_size,
+                'checksum', p_options->>'input_checksum',
+                'encryption_key_id', p_options->>'input_encryption_key_id'
+            ))) || p_inputs;
+        END IF;
+
+        INSERT INTO conversion_task_inputs (
+            task_id,
+            position,
+            original_name,
+            input_path,
+            mimetype,
+            file_size,
+            duration,
+            checksum,
+            encryption_key_id
+        )
+        SELECT
+            new_task_id,
+            input.position - 1,
+            input.value->>'original_name',
+            input.value->>'path',
+            input.value->>'mimetype',
+            (input.value->>'file_size')::BIGINT,
+            (input.value->>'duration')::NUMERIC,
+            input.value->>'checksum',
+            input.value->>'encryption_key_id'
+        FROM jsonb_array_elements(all_inputs) WITH ORDINALITY AS input(value, position);
+        
+        SELECT
+            jsonb_agg(jsonb_strip_nulls(jsonb_build_object(
+                'uri', storage_uri(input_path),
+                'mimetype', mimetype,
+                'duration', duration,
+                'checksum', checksum,
+                'encryption_key_id', encryption_key_id
+            )) ORDER BY position),
+            COALESCE(SUM(file_size), p_file_size)
+        INTO inputs_data, total_size
+        FROM conversion_task_inputs
+        WHERE task_id = new_task_id;
+    END IF;
+    
     event_data := jsonb_build_object(
         'id', new_task_id,
         'input_path', p_input_path,
+        'input_uri', storage_uri(p_input_path),
+        'inputs', inputs_data,
+        'original_name', p_original_name,
         'mimetype', p_mimetype,
         'format', p_format,
-        'file_size', p_file_size,
+        'file_size', total_size,
+        'options', COALESCE(p_options, '{}'::jsonb),
         'status', 'pending'
     );
     
@@ -60,7 +132,13 @@ $$ LANGUAGE plpgsql;
 CREATE OR REPLACE FUNCTION update_task_status_with_outbox(
     p_task_id UUID,
     p_new_status VARCHAR(50),
-    p_output_path TEXT DEFAULT NULL
+    p_output_path TEXT DEFAULT NULL,
+    p_metadata JSONB DEFAULT NULL,
+    p_output_size BIGINT DEFAULT NULL,
+    p_output_checksum VARCHAR(64) DEFAULT NULL,
+    p_error_message TEXT DEFAULT NULL,
+    p_error_type VARCHAR(50) DEFAULT NULL,
+    p_cache_key VARCHAR(64) DEFAULT NULL
 ) RETURNS BOOLEAN AS $$
 DECLARE
     event_data JSONB;
@@ -78,6 +156,12 @@ BEGIN
     SET 
         status = p_new_status,
         output_path = COALESCE(p_output_path, output_path),
+        metadata = metadata || COALESCE(p_metadata, '{}'::jsonb),
+        output_size = COALESCE(p_output_size, output_size),
+        output_checksum = COALESCE(p_output_checksum, output_checksum),
+        error_message = COALESCE(p_error_message, error_message),
+        error_type = COALESCE(p_error_type, error_type),
+        cache_key = COALESCE(p_cache_key, cache_key),
         updated_at = NOW()
     WHERE id = p_task_id;    
 
@@ -85,7 +169,12 @@ BEGIN
         'id', p_task_id,
         'oldStatus', old_status,
         'newStatus', p_new_status,
-        'outputPath', p_output_path
+        'outputPath', p_output_path,
+        'metadata', p_metadata,
+        'outputSize', p_output_size,
+        'outputChecksum', p_output_checksum,
+        'errorMessage', p_error_message,
+        'errorType', p_error_type
     );   
    
     INSERT INTO outbox_events (
@@ -226,4 +315,117 @@ BEGIN
     
     RETURN FOUND;
 END;
-$$ LANGUAGE plpgsql;
\ No newline at end of file
+$$ LANGUAGE plpgsql;
+-- Conversion cache: each entry is an output shared by every completed task
+-- whose input, format and options hash to cache_key. ref_count counts those
+-- tasks; an entry is only collected once it has expired and no task refers
+-- to it any more.
+
+CREATE OR REPLACE FUNCTION acquire_cached_conversion(
+    p_cache_key VARCHAR(64),
+    p_ttl_seconds INTEGER
+) RETURNS TABLE (
+    output_path TEXT,
+    output_size BIGINT,
+    output_checksum VARCHAR(64),
+    metadata JSONB
+) AS $$
+BEGIN
+    RETURN QUERY
+    UPDATE conversion_cache c
+    SET 
+        ref_count = c.ref_count + 1,
+        last_used_at = NOW(),
+        expires_at = GREATEST(c.expires_at, NOW() + INTERVAL '1 second' * p_ttl_seconds)
+    WHERE c.cache_key = p_cache_key
+    AND c.expires_at > NOW()
+    RETURNING c.output_path, c.output_size, c.output_checksum, c.metadata;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION store_cached_conversion(
+    p_cache_key VARCHAR(64),
+    p_output_path TEXT,
+    p_output_size BIGINT,
+    p_output_checksum VARCHAR(64),
+    p_metadata JSONB,
+    p_ttl_seconds INTEGER
+) RETURNS BOOLEAN AS $$
+BEGIN
+    INSERT INTO conversion_cache (
+        cache_key,
+        output_path,
+        output_size,
+        output_checksum,
+        metadata,
+        expires_at
+    ) VALUES (
+        p_cache_key,
+        p_output_path,
+        p_output_size,
+        p_output_checksum,
+        COALESCE(p_metadata, '{}'::jsonb),
+        NOW() + INTERVAL '1 second' * p_ttl_seconds
+    )
+    ON CONFLICT (cache_key) DO NOTHING;
+    
+    RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION release_cached_conversion(
+    p_cache_key VARCHAR(64)
+) RETURNS BOOLEAN AS $$
+BEGIN
+    UPDATE conversion_cache 
+    SET ref_count = GREATEST(ref_count - 1, 0)
+    WHERE cache_key = p_cache_key;
+    
+    RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION collect_expired_cache_entries(
+    p_limit INTEGER DEFAULT 100
+) RETURNS TABLE (
+    cache_key VARCHAR(64),
+    output_path TEXT,
+    output_size BIGINT
+) AS $$
+BEGIN
+    RETURN QUERY
+    DELETE FROM conversion_cache c
+    WHERE c.cache_key IN (
+        SELECT e.cache_key
+        FROM conversion_cache e
+        WHERE e.ref_count = 0
+        AND e.expires_at < NOW()
+        ORDER BY e.expires_at
+        LIMIT p_limit
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING c.cache_key, c.output_path, c.output_size;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_expired_task_outputs(
+    p_ttl_seconds INTEGER,
+    p_limit INTEGER DEFAULT 100,
+    p_offset INTEGER DEFAULT 0
+) RETURNS TABLE (
+    task_id UUID,
+    output_path TEXT,
+    output_size BIGINT,
+    cache_key VARCHAR(64)
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT t.id, t.output_path, t.output_size, t.cache_key
+    FROM conversion_tasks t
+    WHERE t.status = 'completed'
+    AND COALESCE(t.processing_completed_at, t.updated_at) < NOW() - INTERVAL '1 second' * p_ttl_seconds
+    ORDER BY COALESCE(t.processing_completed_at, t.updated_at), t.id
+    LIMIT p_limit
+    OFFSET p_offset;
+END;
+$$ LANGUAGE plpgsql;